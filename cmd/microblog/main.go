@@ -2,10 +2,79 @@ package main
 
 import (
 	"blog/internal/microblog"
+	"blog/internal/microblog/storage/mongostorage"
+	"context"
+	"flag"
+	"fmt"
+	"log"
 	"os"
 )
 
 func main() {
-	srv := microblog.NewMicroblogServer(os.Getenv("MONGO_URL"))
-	srv.StartNewMicrobologServer(8081)
+	cfg := microblog.ConfigFromEnv()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(cfg, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		setRole(cfg, os.Args[2:])
+		return
+	}
+
+	srv, err := microblog.NewMicroblogServer(cfg)
+
+	if err != nil {
+		log.Fatalf("microblog: %v", err)
+	}
+
+	if err := srv.StartNewMicrobologServer(8081); err != nil {
+		log.Fatalf("microblog: %v", err)
+	}
+}
+
+func migrate(cfg microblog.Config, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only print migrations which would be applied")
+	flags.Parse(args)
+
+	migrations, err := mongostorage.Migrate(context.Background(), cfg.MongoUrl, *dryRun)
+
+	for _, m := range migrations {
+		if *dryRun {
+			fmt.Printf("pending %d - %s\n", m.Version, m.Description)
+		} else {
+			fmt.Printf("applied %d - %s\n", m.Version, m.Description)
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+
+	if len(migrations) == 0 {
+		fmt.Println("database is up to date")
+	}
+}
+
+func setRole(cfg microblog.Config, args []string) {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: set-role <login> <user|moderator|admin>")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Migrations are left to migrate command
+	cfg.MigrateOnStart = false
+	if err := microblog.SetRole(context.Background(), cfg, flags.Arg(0), flags.Arg(1)); err != nil {
+		log.Fatalf("set-role: %v", err)
+	}
+
+	fmt.Printf("%s is %s now\n", flags.Arg(0), flags.Arg(1))
 }
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Another replica may append between reading the last entry and appending after it
+	maxAppendAttempts = 5
+	verifyBatchSize   = 500
+)
+
+// ErrSeqTaken is returned by Store.Append when entry with the same Seq already exists
+var ErrSeqTaken = errors.New("audit entry sequence number is taken")
+
+// Security relevant event
+type Entry struct {
+	// Position in chain, assigned by Log starting from 1
+	Seq    int64     `bson:"seq"`
+	Time   time.Time `bson:"time"`
+	Actor  string    `bson:"actor"`
+	Action string    `bson:"action"`
+	Target string    `bson:"target"`
+	// Client ip of the request which caused the event
+	Ip      string            `bson:"ip,omitempty"`
+	Details map[string]string `bson:"details,omitempty"`
+	// Hash of previous entry, empty for the first one
+	PrevHash []byte `bson:"prevHash"`
+	// SHA-256 of entry including PrevHash, so change or removal of any entry breaks the chain
+	Hash []byte `bson:"hash"`
+}
+
+// Filter of Query, zero fields match everything
+type Filter struct {
+	Actor  string
+	Action string
+	// Inclusive bounds of entry time
+	From time.Time
+	To   time.Time
+	// Only entries older than this Seq, for pagination
+	BeforeSeq int64
+}
+
+type Store interface {
+	// Entry with the greatest Seq, nil if log is empty
+	Last(ctx context.Context) (*Entry, error)
+	// ErrSeqTaken if entry with the same Seq exists
+	Append(ctx context.Context, e *Entry) error
+	// At most size entries matching filter, newest first
+	Find(ctx context.Context, f Filter, size int) ([]Entry, error)
+	// At most size entries with Seq greater than afterSeq, oldest first
+	Scan(ctx context.Context, afterSeq int64, size int) ([]Entry, error)
+}
+
+// Recorder is what components which produce events depend on
+type Recorder interface {
+	Record(ctx context.Context, e *Entry) error
+}
+
+// Log appends entries to hash chain kept in store
+type Log struct {
+	store Store
+	now   func() time.Time
+	// Appends of one replica don't race with each other
+	mu sync.Mutex
+}
+
+func NewLog(store Store) *Log {
+	return &Log{store: store, now: time.Now}
+}
+
+// canonical is what hash of entry covers, time has precision of mongo dates so hash survives round trip
+func canonical(e *Entry) []byte {
+	details := e.Details
+	if len(details) == 0 {
+		details = nil
+	}
+
+	// Keys of maps are sorted by json, so encoding is stable
+	b, _ := json.Marshal(struct {
+		Seq      int64
+		Time     string
+		Actor    string
+		Action   string
+		Target   string
+		Ip       string
+		Details  map[string]string
+		PrevHash []byte
+	}{e.Seq, e.Time.UTC().Format(time.RFC3339Nano), e.Actor, e.Action, e.Target, e.Ip, details, e.PrevHash})
+
+	return b
+}
+
+func hash(e *Entry) []byte {
+	sum := sha256.Sum256(canonical(e))
+	return sum[:]
+}
+
+// Link makes e the entry after prev, nil prev makes it the first one
+func Link(prev, e *Entry) {
+	e.Seq, e.PrevHash = 1, nil
+	if prev != nil {
+		e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+	}
+	e.Hash = hash(e)
+}
+
+// Record appends entry to the end of chain, Time is set to now unless it is set already
+func (l *Log) Record(ctx context.Context, e *Entry) error {
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	e.Time = e.Time.UTC().Truncate(time.Millisecond)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		last, err := l.store.Last(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		Link(last, e)
+
+		if err := l.store.Append(ctx, e); !errors.Is(err, ErrSeqTaken) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("can't append audit entry %s after %d attempts", e.Action, maxAppendAttempts)
+}
+
+// Query returns at most size entries matching filter, newest first
+func (l *Log) Query(ctx context.Context, f Filter, size int) ([]Entry, error) {
+	return l.store.Find(ctx, f, size)
+}
+
+type Verification struct {
+	Entries int64
+	// Last entry of valid chain, operators keep its hash elsewhere to detect removal of the newest entries
+	Head *Entry
+	// Seq of the first entry which breaks the chain, zero if chain is valid
+	BrokenAt int64
+}
+
+// Verify walks the whole chain and recomputes hashes
+func (l *Log) Verify(ctx context.Context) (*Verification, error) {
+	v := &Verification{}
+	var prev *Entry
+
+	for {
+		entries, err := l.store.Scan(ctx, v.Entries, verifyBatchSize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range entries {
+			e := &entries[i]
+			valid := e.Seq == v.Entries+1 && bytes.Equal(e.Hash, hash(e))
+
+			if prev == nil {
+				valid = valid && len(e.PrevHash) == 0
+			} else {
+				valid = valid && bytes.Equal(e.PrevHash, prev.Hash)
+			}
+
+			if !valid {
+				// Seq of removed entry is the one after the last valid
+				v.BrokenAt = v.Entries + 1
+				return v, nil
+			}
+
+			v.Entries, v.Head, prev = e.Seq, e, e
+		}
+
+		if len(entries) < verifyBatchSize {
+			return v, nil
+		}
+	}
+}
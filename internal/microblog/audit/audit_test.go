@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func record(t *testing.T, l *Log, actor, action string) {
+	require.NoError(t, l.Record(context.Background(), &Entry{Actor: actor, Action: action, Target: actor}))
+}
+
+func TestChain(t *testing.T) {
+	store := NewMemoryStore()
+	l := NewLog(store)
+
+	v, err := l.Verify(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &Verification{}, v)
+
+	for _, action := range []string{"user.register", "login.failure", "login.success"} {
+		record(t, l, "alice", action)
+	}
+
+	v, err = l.Verify(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(3), v.Entries)
+	require.Zero(t, v.BrokenAt)
+	require.Equal(t, store.entries[2].Hash, v.Head.Hash)
+	require.Equal(t, store.entries[1].Hash, store.entries[2].PrevHash)
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	for name, test := range map[string]struct {
+		tamper   func(s *MemoryStore)
+		brokenAt int64
+	}{
+		"change": {func(s *MemoryStore) { s.entries[1].Action = "login.success" }, 2},
+		"remove": {func(s *MemoryStore) { s.entries = append(s.entries[:1], s.entries[2:]...) }, 2},
+		// Recomputed hash doesn't match PrevHash of the next entry
+		"rehash": {func(s *MemoryStore) {
+			s.entries[1].Actor = "mallory"
+			s.entries[1].Hash = hash(&s.entries[1])
+		}, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := NewMemoryStore()
+			l := NewLog(store)
+			for i := 0; i < 3; i++ {
+				record(t, l, "alice", "login.failure")
+			}
+
+			test.tamper(store)
+
+			v, err := l.Verify(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, test.brokenAt, v.BrokenAt)
+			require.Equal(t, test.brokenAt-1, v.Entries)
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLog(NewMemoryStore())
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		record(t, l, "alice", "login.success")
+		record(t, l, "bob", "login.failure")
+		now = now.Add(time.Hour)
+	}
+
+	entries, err := l.Query(context.Background(), Filter{Actor: "alice"}, 3)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, []int64{9, 7, 5}, seqs(entries))
+
+	entries, err = l.Query(context.Background(), Filter{Actor: "alice", BeforeSeq: 5}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 1}, seqs(entries))
+
+	from := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	entries, err = l.Query(context.Background(), Filter{Action: "login.failure", From: from, To: from.Add(time.Hour)}, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{6, 4}, seqs(entries))
+}
+
+func seqs(entries []Entry) []int64 {
+	result := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.Seq)
+	}
+
+	return result
+}
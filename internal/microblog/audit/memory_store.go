@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps entries of one replica in memory, they are lost on restart
+type MemoryStore struct {
+	mu sync.RWMutex
+	// Ordered by Seq, entries[i].Seq == i+1
+	entries []Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make([]Entry, 0)}
+}
+
+func (m *MemoryStore) Last(context.Context) (*Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.entries) == 0 {
+		return nil, nil
+	}
+
+	last := m.entries[len(m.entries)-1]
+	return &last, nil
+}
+
+func (m *MemoryStore) Append(_ context.Context, e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.Seq != int64(len(m.entries))+1 {
+		return ErrSeqTaken
+	}
+
+	m.entries = append(m.entries, *e)
+
+	return nil
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || !e.Time.After(f.To)) &&
+		(f.BeforeSeq == 0 || e.Seq < f.BeforeSeq)
+}
+
+func (m *MemoryStore) Find(_ context.Context, f Filter, size int) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]Entry, 0)
+	for i := len(m.entries) - 1; i >= 0 && len(entries) < size; i-- {
+		if f.matches(&m.entries[i]) {
+			entries = append(entries, m.entries[i])
+		}
+	}
+
+	return entries, nil
+}
+
+func (m *MemoryStore) Scan(_ context.Context, afterSeq int64, size int) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]Entry, 0)
+	for i := int(max(afterSeq, 0)); i < len(m.entries) && len(entries) < size; i++ {
+		entries = append(entries, m.entries[i])
+	}
+
+	return entries, nil
+}
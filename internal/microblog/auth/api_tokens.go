@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Scopes of API tokens
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	// Password, second factor and tokens management, never granted to API tokens
+	ScopeAccount = "account"
+)
+
+var ApiTokenScopes = []string{ScopePostsRead, ScopePostsWrite}
+
+// API tokens are told apart from access tokens by prefix, it also helps secret scanners
+const ApiTokenPrefix = "mbt_"
+
+// Last use is recorded at most this often, so every request isn't a write
+const lastUsedResolution = time.Minute
+
+func ValidScope(scope string) bool {
+	for _, s := range ApiTokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewApiToken issues token of apiToken.UserId with apiToken.Scopes, zero ttl means it never expires
+func (a *Authenticator) NewApiToken(ctx context.Context, apiToken *storage.ApiToken, ttl time.Duration) (string, error) {
+	secret, err := randomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	token := ApiTokenPrefix + secret
+	now := a.now().UTC()
+	apiToken.Prefix = token[:len(ApiTokenPrefix)+6]
+	apiToken.TokenHash = HashOpaqueToken(token)
+	apiToken.CreatedAt = now
+
+	if ttl > 0 {
+		apiToken.ExpiresAt = now.Add(ttl)
+	}
+
+	if err := (*a.s).AddApiToken(ctx, apiToken); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AuthenticateApiToken returns API token if it exists and is not expired, ErrUnauthenticated otherwise
+func (a *Authenticator) AuthenticateApiToken(ctx context.Context, token string) (*storage.ApiToken, error) {
+	apiToken, err := (*a.s).GetApiTokenByHash(ctx, HashOpaqueToken(token))
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w - unknown api token", ErrUnauthenticated)
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	if !apiToken.ExpiresAt.IsZero() && !apiToken.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("%w - api token expired", ErrUnauthenticated)
+	}
+
+	// Failure to record last use must not fail the request
+	if now.Sub(apiToken.LastUsedAt) > lastUsedResolution {
+		if err := (*a.s).TouchApiToken(ctx, apiToken.Id, now.UTC()); err != nil {
+			slog.Default().Error("can't record api token use", slog.String("error", err.Error()))
+		}
+	}
+
+	return apiToken, nil
+}
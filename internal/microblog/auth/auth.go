@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"blog/internal/microblog/keys"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// Audience of challenge tokens, they only prove the first login step
+	challengeAudience = "login-challenge"
+	challengeTTL      = 5 * time.Minute
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator issues access tokens bound to sessions and checks them.
+// Token is valid only while its session is neither expired nor revoked.
+type Authenticator struct {
+	s          *storage.Storage
+	keys       *keys.Manager
+	sessionTTL time.Duration
+	now        func() time.Time
+}
+
+func NewAuthenticator(s *storage.Storage, k *keys.Manager, sessionTTL time.Duration) *Authenticator {
+	return &Authenticator{s: s, keys: k, sessionTTL: sessionTTL, now: time.Now}
+}
+
+// NewSession starts session of user and returns its access token
+func (a *Authenticator) NewSession(ctx context.Context, user *storage.User) (string, *storage.Session, error) {
+	id, err := randomToken()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := a.now().UTC()
+	session := &storage.Session{Id: id, UserId: user.Id, CreatedAt: now, ExpiresAt: now.Add(a.sessionTTL)}
+
+	if err := (*a.s).AddSession(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	token, err := a.keys.Sign(jwt.StandardClaims{
+		Id:        session.Id,
+		Subject:   user.Id,
+		Issuer:    user.Login,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, session, nil
+}
+
+// NewChallenge returns short-lived token of user who passed password check but not second factor yet
+func (a *Authenticator) NewChallenge(user *storage.User) (string, error) {
+	now := a.now()
+	return a.keys.Sign(jwt.StandardClaims{
+		Audience:  challengeAudience,
+		Subject:   user.Id,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(challengeTTL).Unix(),
+	})
+}
+
+// VerifyChallenge returns id of user whose challenge token it is
+func (a *Authenticator) VerifyChallenge(token string) (string, error) {
+	claims, err := a.parse(token)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !claims.VerifyAudience(challengeAudience, true) {
+		return "", fmt.Errorf("%w - not a challenge token", ErrUnauthenticated)
+	}
+
+	return claims.Subject, nil
+}
+
+func (a *Authenticator) parse(token string) (*jwt.StandardClaims, error) {
+	var claims jwt.StandardClaims
+	_, err := jwt.ParseWithClaims(token, &claims, a.keys.Keyfunc)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w - %v", ErrUnauthenticated, err)
+	}
+
+	return &claims, nil
+}
+
+// Authenticate returns active session of token, ErrUnauthenticated if there is none
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*storage.Session, error) {
+	claims, err := a.parse(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Audience != "" {
+		return nil, fmt.Errorf("%w - not an access token", ErrUnauthenticated)
+	}
+
+	session, err := (*a.s).GetSession(ctx, claims.Id)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w - unknown session", ErrUnauthenticated)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if session.Revoked || !session.ExpiresAt.After(a.now()) || session.UserId != claims.Subject {
+		return nil, fmt.Errorf("%w - session is not active", ErrUnauthenticated)
+	}
+
+	return session, nil
+}
+
+type principalKey struct{}
+
+// Principal is who made authenticated request, either with access token of session or with API token
+type Principal struct {
+	UserId string
+	// Role of user at the moment of request, changes apply to existing tokens at once
+	Role string
+	// Exactly one of Session and ApiToken is set
+	Session  *storage.Session
+	ApiToken *storage.ApiToken
+}
+
+// Sessions have every scope, API tokens only granted ones
+func (p *Principal) HasScope(scope string) bool {
+	if p.ApiToken == nil {
+		return true
+	}
+
+	for _, s := range p.ApiToken.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Principal of authenticated request, nil for anonymous ones
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// ContextWithPrincipal marks ctx as authenticated by principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func (a *Authenticator) authenticateRequest(ctx context.Context, token string) (*Principal, error) {
+	var principal *Principal
+	if strings.HasPrefix(token, ApiTokenPrefix) {
+		apiToken, err := a.AuthenticateApiToken(ctx, token)
+
+		if err != nil {
+			return nil, err
+		}
+
+		principal = &Principal{UserId: apiToken.UserId, ApiToken: apiToken}
+	} else {
+		session, err := a.Authenticate(ctx, token)
+
+		if err != nil {
+			return nil, err
+		}
+
+		principal = &Principal{UserId: session.UserId, Session: session}
+	}
+
+	// Role and suspension are read on every request, so they don't wait for tokens to expire
+	user, err := (*a.s).GetUserById(ctx, principal.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Suspended {
+		return nil, ErrSuspended
+	}
+
+	principal.Role = RoleOf(user.Role)
+
+	return principal, nil
+}
+
+// Required rejects requests without valid bearer token with 401 and requests without scope with 403
+func (a *Authenticator) Required(scope string, next http.HandlerFunc) http.Handler {
+	return a.middleware(scope, false, next)
+}
+
+// Optional is Required which lets requests without Authorization header through as anonymous
+func (a *Authenticator) Optional(scope string, next http.HandlerFunc) http.Handler {
+	return a.middleware(scope, true, next)
+}
+
+func (a *Authenticator) middleware(scope string, optional bool, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("Authorization")
+
+		if header == "" && optional {
+			next(w, req)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.WriteErrorToResponse(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		principal, err := a.authenticateRequest(req.Context(), token)
+
+		if errors.Is(err, ErrSuspended) {
+			utils.WriteErrorToResponse(w, http.StatusForbidden, err.Error())
+			return
+		} else if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			utils.WriteErrorToResponse(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		} else if err != nil {
+			logging.ForRequest(slog.Default(), req).Error("can't authenticate", slog.String("error", err.Error()))
+			utils.WriteErrorToResponse(w, http.StatusInternalServerError, "something went wrong")
+			return
+		}
+
+		if !principal.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			utils.WriteErrorToResponse(w, http.StatusForbidden, "token lacks scope "+scope)
+			return
+		}
+
+		next(w, req.WithContext(ContextWithPrincipal(req.Context(), principal)))
+	})
+}
+
+// NewOpaqueToken returns random token for user and its hash for storage
+func NewOpaqueToken() (string, []byte, error) {
+	token, err := randomToken()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, HashOpaqueToken(token), nil
+}
+
+func HashOpaqueToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// NewId returns random public identifier, it must not be used as secret
+func NewId() (string, error) {
+	return randomString(16)
+}
+
+func randomToken() (string, error) {
+	return randomString(32)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate random string - %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"blog/internal/microblog/utils"
+	"errors"
+	"net/http"
+)
+
+// Roles of users, users without role have RoleUser
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions route handlers require
+const (
+	PermissionUsersRead     = "users:read"
+	PermissionUsersSuspend  = "users:suspend"
+	PermissionRolesAssign   = "roles:assign"
+	PermissionPostsDelete   = "posts:delete"
+	PermissionLockoutsAdmin = "lockouts:admin"
+	PermissionAuditRead     = "audit:read"
+)
+
+var ErrSuspended = errors.New("account is suspended")
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionUsersRead, PermissionUsersSuspend, PermissionPostsDelete},
+	RoleAdmin: {PermissionUsersRead, PermissionUsersSuspend, PermissionPostsDelete, PermissionRolesAssign,
+		PermissionLockoutsAdmin, PermissionAuditRead},
+}
+
+// Ranks of roles, users may act only on users of lower rank
+var roleRanks = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleOf treats empty role of users created before roles appeared as RoleUser
+func RoleOf(role string) string {
+	if role == "" {
+		return RoleUser
+	}
+
+	return role
+}
+
+func RoleHasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[RoleOf(role)] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Outranks reports whether role may act on users with role target
+func Outranks(role, target string) bool {
+	return roleRanks[RoleOf(role)] > roleRanks[RoleOf(target)]
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	return RoleHasPermission(p.Role, permission)
+}
+
+// Authorized is Required for account scope which also rejects principals without permission with 403
+func (a *Authenticator) Authorized(permission string, next http.HandlerFunc) http.Handler {
+	return a.Required(ScopeAccount, func(w http.ResponseWriter, req *http.Request) {
+		if !PrincipalFromContext(req.Context()).HasPermission(permission) {
+			utils.WriteErrorToResponse(w, http.StatusForbidden, "permission "+permission+" required")
+			return
+		}
+
+		next(w, req)
+	})
+}
@@ -0,0 +1,41 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// Kinds of stores
+const (
+	FileStore = "fs"
+	S3Store   = "s3"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrBadKey   = errors.New("blob key is not a relative path of plain names")
+)
+
+// Dots only separate names, so keys can't refer to parent directories
+var keyRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*(/[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*)*$`)
+
+// Store keeps blobs by key, keys are names of letters, digits, "_" and "-" with dots between them separated by "/"
+type Store interface {
+	// Put replaces blob with key
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// ErrNotFound if there is no blob with key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Deleting missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Keys are checked before they become paths of files or objects
+func checkKey(key string) error {
+	if !keyRegex.MatchString(key) {
+		return ErrBadKey
+	}
+
+	return nil
+}
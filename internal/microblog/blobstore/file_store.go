@@ -0,0 +1,99 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fileStore keeps blobs as files under dir, replicas share blobs only if dir is shared
+type fileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("can't create blob directory %s - %w", dir, err)
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes temporary file and renames it, so readers never see partial blob
+func (f *fileStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	path, err := f.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("can't create directory of blob %s - %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+
+	if err != nil {
+		return fmt.Errorf("can't create blob %s - %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can't write blob %s - %w", key, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't write blob %s - %w", key, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can't write blob %s - %w", key, err)
+	}
+
+	return nil
+}
+
+func (f *fileStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := f.path(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't open blob %s - %w", key, err)
+	}
+
+	return file, nil
+}
+
+func (f *fileStore) Delete(_ context.Context, key string) error {
+	path, err := f.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("can't delete blob %s - %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package composer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Kinds of entities
+const (
+	URL     = "url"
+	Hashtag = "hashtag"
+	Mention = "mention"
+)
+
+// Length of post in user-perceived characters
+const DefaultMaxLength = 500
+
+var (
+	ErrEmpty   = errors.New("post text is empty")
+	ErrTooLong = errors.New("post text is too long")
+)
+
+var (
+	urlRegex     = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+	hashtagRegex = regexp.MustCompile(`#[\p{L}\p{N}_]+`)
+	mentionRegex = regexp.MustCompile(`@[A-Za-z0-9_]+`)
+	newLines     = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\t", " ")
+)
+
+// Entity is part of post text which clients show as link
+type Entity struct {
+	Type string `json:"type"`
+	// Offsets in code points of text, End is exclusive
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// URL as written, hashtag and mention without # and @
+	Value string `json:"value"`
+}
+
+// Compose returns text in the form it is stored: NFC normalized, without control characters except new lines,
+// without surrounding spaces and at most maxLength characters long
+func Compose(text string, maxLength int) (string, error) {
+	text = strings.TrimSpace(norm.NFC.String(stripControls(text)))
+
+	if text == "" {
+		return "", ErrEmpty
+	}
+
+	if Length(text) > maxLength {
+		return "", fmt.Errorf("%w - at most %d characters are allowed", ErrTooLong, maxLength)
+	}
+
+	return text, nil
+}
+
+// isBidiControl reports embeddings, overrides and isolates, which let text be shown in order it isn't written
+func isBidiControl(r rune) bool {
+	return r >= '\u202a' && r <= '\u202e' || r >= '\u2066' && r <= '\u2069'
+}
+
+func stripControls(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		} else if unicode.IsControl(r) || isBidiControl(r) || r == '\ufeff' {
+			return -1
+		}
+
+		return r
+	}, newLines.Replace(text))
+}
+
+// Length counts grapheme clusters of text as defined by Unicode Standard Annex #29,
+// so accented letters, flags and emoji sequences count as one character
+func Length(text string) int {
+	return uniseg.GraphemeClusterCount(text)
+}
+
+// TrimUrl drops punctuation which ends sentence or markdown emphasis rather than URL,
+// markdown autolinks end where URL entities do
+func TrimUrl(url string) string {
+	for url != "" {
+		last := url[len(url)-1]
+		if strings.IndexByte(".,:;!?'*_", last) >= 0 ||
+			last == ')' && strings.Count(url, "(") < strings.Count(url, ")") {
+			url = url[:len(url)-1]
+		} else {
+			break
+		}
+	}
+
+	return url
+}
+
+// atWordStart reports whether entity at byte offset start isn't a part of word, like @ of email
+func atWordStart(text string, start int) bool {
+	prev, _ := utf8.DecodeLastRuneInString(text[:start])
+
+	return start == 0 || !(unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '_' || prev == '/' ||
+		prev == '#' || prev == '@')
+}
+
+// Entities finds URLs, hashtags and mentions of text ordered by offset, hashtags and mentions inside URLs are ignored
+func Entities(text string) []Entity {
+	type span struct {
+		kind       string
+		start, end int
+	}
+
+	var spans []span
+	for _, loc := range urlRegex.FindAllStringIndex(text, -1) {
+		end := loc[0] + len(TrimUrl(text[loc[0]:loc[1]]))
+		if end > loc[0]+len("http://") {
+			spans = append(spans, span{URL, loc[0], end})
+		}
+	}
+
+	insideUrl := func(start int) bool {
+		for _, s := range spans {
+			if s.kind == URL && start >= s.start && start < s.end {
+				return true
+			}
+		}
+		return false
+	}
+
+	for kind, regex := range map[string]*regexp.Regexp{Hashtag: hashtagRegex, Mention: mentionRegex} {
+		for _, loc := range regex.FindAllStringIndex(text, -1) {
+			if atWordStart(text, loc[0]) && !insideUrl(loc[0]) {
+				spans = append(spans, span{kind, loc[0], loc[1]})
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	// Byte offsets become code point offsets
+	var entities []Entity
+	runes, offset := 0, 0
+	for _, s := range spans {
+		runes += utf8.RuneCountInString(text[offset:s.start])
+		entity := Entity{Type: s.kind, Start: runes, End: runes + utf8.RuneCountInString(text[s.start:s.end])}
+		runes, offset = entity.End, s.end
+
+		entity.Value = text[s.start:s.end]
+		if s.kind != URL {
+			entity.Value = entity.Value[1:]
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities
+}
+
+// Hashtags returns unique lowercase hashtags of text without #
+func Hashtags(text string) []string {
+	var hashtags []string
+	seen := map[string]bool{}
+
+	for _, entity := range Entities(text) {
+		tag := strings.ToLower(entity.Value)
+		if entity.Type == Hashtag && !seen[tag] {
+			seen[tag] = true
+			hashtags = append(hashtags, tag)
+		}
+	}
+
+	return hashtags
+}
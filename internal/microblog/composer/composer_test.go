@@ -0,0 +1,64 @@
+package composer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLength(t *testing.T) {
+	for text, expected := range map[string]int{
+		"":                     0,
+		"hello":                5,
+		"привет":               6,
+		"e\u0301":              1, // e with combining acute accent
+		"\U0001F44D\U0001F3FD": 1, // thumbs up with skin tone
+		"\U0001F468\u200d\U0001F469\u200d\U0001F467": 1, // family joined with ZWJ
+		"\U0001F1F7\U0001F1FA\U0001F1EB\U0001F1F7":   2, // two flags
+		"\u2764\ufe0f!":      2, // heart with variation selector
+		"\u0301a":            2, // mark without base
+		"\u1100\u1161\u11a8": 1, // hangul syllable of conjoining jamo
+		"\r\n":               1,
+	} {
+		require.Equal(t, expected, Length(text), "%q", text)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	text, err := Compose("  cafe\u0301\r\nline\ttab\u0007\u202ereversed\ufeff  ", 100)
+	require.NoError(t, err)
+	require.Equal(t, "caf\u00e9\nline tabreversed", text)
+
+	_, err = Compose(" \u0000\n\u202e ", 100)
+	require.ErrorIs(t, err, ErrEmpty)
+
+	_, err = Compose("", 100)
+	require.ErrorIs(t, err, ErrEmpty)
+
+	_, err = Compose(strings.Repeat("\U0001F44D\U0001F3FD", 11), 10)
+	require.ErrorIs(t, err, ErrTooLong)
+
+	text, err = Compose(strings.Repeat("\U0001F44D\U0001F3FD", 10), 10)
+	require.NoError(t, err)
+	require.Len(t, []rune(text), 20, "limit counts characters, not code points")
+}
+
+func TestEntities(t *testing.T) {
+	text := "Привет @Alice, see https://example.com/a_(b)?q=#frag. #Go #го mail@example.com (http://x.io/y)"
+	require.Equal(t, []Entity{
+		{Type: Mention, Start: 7, End: 13, Value: "Alice"},
+		{Type: URL, Start: 19, End: 52, Value: "https://example.com/a_(b)?q=#frag"},
+		{Type: Hashtag, Start: 54, End: 57, Value: "Go"},
+		{Type: Hashtag, Start: 58, End: 61, Value: "го"},
+		{Type: URL, Start: 80, End: 93, Value: "http://x.io/y"},
+	}, Entities(text))
+
+	for _, entity := range Entities(text) {
+		require.Equal(t, string([]rune(text)[entity.Start:entity.End]), map[string]string{
+			URL: "", Hashtag: "#", Mention: "@"}[entity.Type]+entity.Value)
+	}
+
+	require.Empty(t, Entities("a#b c@d http:// ##"))
+	require.Equal(t, []string{"go", "safari"}, Hashtags("#Go #go #safari https://x.io/#frag"))
+}
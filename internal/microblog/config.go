@@ -0,0 +1,208 @@
+package microblog
+
+import (
+	"blog/internal/microblog/blobstore"
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/keys"
+	"blog/internal/microblog/lockout"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/media"
+	"blog/internal/microblog/notify"
+	"blog/internal/microblog/password"
+	"blog/internal/microblog/preview"
+	"blog/internal/microblog/tracing"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	MongoStorage  = "mongo"
+	MemoryStorage = "memory"
+)
+
+type Config struct {
+	// MongoStorage or MemoryStorage, in-memory storage loses everything on restart
+	Storage  string
+	MongoUrl string
+	// Apply pending schema migrations on start, otherwise they must be applied with migrate command
+	MigrateOnStart bool
+	// How long in-flight requests and background workers are waited for on shutdown
+	ShutdownTimeout time.Duration
+	// How long readiness fails before listener is closed on shutdown
+	ShutdownDelay time.Duration
+	// One of tracing exporters, spans are not exported by default
+	TracingExporter string
+	LogFormat       string
+	LogLevel        string
+
+	RateLimitEnabled bool
+	// MemoryStorage keeps limits of each replica separately, MongoStorage shares them between replicas
+	RateLimitStore string
+	// Policies in "<requests>/<duration>" format, strict one for login and registration
+	RateLimitAuth    string
+	RateLimitPosting string
+	// Client ip is taken from X-Forwarded-For, only for deployments behind proxies which append to it
+	TrustForwardedFor bool
+	// Number of such proxies, client ip is the entry added by the outermost one
+	TrustedProxyHops int
+
+	// Failed logins after which login or client ip is locked out
+	LoginLockoutThreshold int
+	IpLockoutThreshold    int
+	LoginLockoutDuration  time.Duration
+	IpLockoutDuration     time.Duration
+	PasswordHash          password.Params
+	PasswordPolicy        password.Policy
+	// File with SHA-1 hashes of compromised passwords, breach check is disabled without it
+	PasswordBreachList string
+	// Lifetime of login session and its access token
+	SessionTTL       time.Duration
+	PasswordResetTTL time.Duration
+	// One of notifiers which deliver password reset tokens, NotifierFile is used by file notifier
+	Notifier     string
+	NotifierFile string
+	TotpIssuer   string
+	// Lifetime of access tokens issued to OAuth clients
+	OAuthTokenTTL time.Duration
+	// Keys which sign access tokens, see keys.Policy
+	SigningKeys keys.Policy
+
+	// Longest post in characters as they are counted by composer.Length
+	PostMaxLength int
+	// Posts in markdown format are rejected when it is disabled, existing ones are shown as plain text
+	MarkdownEnabled bool
+	// Renderings of posts kept in memory
+	MarkdownCacheSize int
+
+	// blobstore.FileStore keeps uploaded images in MediaDir, blobstore.S3Store in bucket of MediaS3
+	MediaStore  string
+	MediaDir    string
+	MediaS3     blobstore.S3Config
+	MediaLimits media.Limits
+
+	// Pages linked by posts are fetched within LinkPreview policy and refetched after LinkPreviewTTL
+	LinkPreviewEnabled bool
+	LinkPreview        preview.Policy
+	LinkPreviewTTL     time.Duration
+}
+
+func ConfigFromEnv() Config {
+	return Config{
+		Storage:         envString("STORAGE", MongoStorage),
+		MongoUrl:        os.Getenv("MONGO_URL"),
+		MigrateOnStart:  envBool("MIGRATE_ON_START", true),
+		ShutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		ShutdownDelay:   envDuration("SHUTDOWN_DELAY", 0),
+		TracingExporter: envString("TRACING_EXPORTER", tracing.NoneExporter),
+		LogFormat:       envString("LOG_FORMAT", logging.TextFormat),
+		LogLevel:        envString("LOG_LEVEL", "info"),
+
+		RateLimitEnabled:  envBool("RATE_LIMIT_ENABLED", true),
+		RateLimitStore:    envString("RATE_LIMIT_STORE", MemoryStorage),
+		RateLimitAuth:     envString("RATE_LIMIT_AUTH", "10/1m"),
+		RateLimitPosting:  envString("RATE_LIMIT_POSTING", "30/1m"),
+		TrustForwardedFor: envBool("TRUST_FORWARDED_FOR", false),
+		TrustedProxyHops:  envInt("TRUSTED_PROXY_HOPS", 1),
+
+		LoginLockoutThreshold: envInt("LOGIN_LOCKOUT_THRESHOLD", lockout.DefaultLoginPolicy.Threshold),
+		IpLockoutThreshold:    envInt("IP_LOCKOUT_THRESHOLD", lockout.DefaultIpPolicy.Threshold),
+		LoginLockoutDuration:  envDuration("LOGIN_LOCKOUT_DURATION", lockout.DefaultLoginPolicy.LockoutDuration),
+		IpLockoutDuration:     envDuration("IP_LOCKOUT_DURATION", lockout.DefaultIpPolicy.LockoutDuration),
+
+		PasswordHash: password.Params{
+			Algorithm:         envString("PASSWORD_HASH_ALGORITHM", password.DefaultParams.Algorithm),
+			BcryptCost:        envInt("PASSWORD_BCRYPT_COST", password.DefaultParams.BcryptCost),
+			Argon2Memory:      uint32(envInt("PASSWORD_ARGON2_MEMORY", int(password.DefaultParams.Argon2Memory))),
+			Argon2Iterations:  uint32(envInt("PASSWORD_ARGON2_ITERATIONS", int(password.DefaultParams.Argon2Iterations))),
+			Argon2Parallelism: uint8(envInt("PASSWORD_ARGON2_PARALLELISM", int(password.DefaultParams.Argon2Parallelism))),
+		},
+		PasswordPolicy: password.Policy{
+			MinLength:      envInt("PASSWORD_MIN_LENGTH", password.DefaultPolicy.MinLength),
+			MaxLength:      envInt("PASSWORD_MAX_LENGTH", password.DefaultPolicy.MaxLength),
+			MinCharClasses: envInt("PASSWORD_MIN_CHAR_CLASSES", password.DefaultPolicy.MinCharClasses),
+			DisallowLogin:  envBool("PASSWORD_DISALLOW_LOGIN", password.DefaultPolicy.DisallowLogin),
+		},
+		PasswordBreachList: os.Getenv("PASSWORD_BREACH_LIST"),
+		SessionTTL:         envDuration("SESSION_TTL", time.Hour),
+		PasswordResetTTL:   envDuration("PASSWORD_RESET_TTL", 30*time.Minute),
+		Notifier:           envString("NOTIFIER", notify.NoneNotifier),
+		NotifierFile:       envString("NOTIFIER_FILE", "notifications.jsonl"),
+		TotpIssuer:         envString("TOTP_ISSUER", "microblog"),
+		OAuthTokenTTL:      envDuration("OAUTH_TOKEN_TTL", time.Hour),
+		SigningKeys: keys.Policy{
+			Algorithm:       envString("SIGNING_ALGORITHM", keys.EdDSA),
+			Dir:             os.Getenv("SIGNING_KEYS_DIR"),
+			Rotation:        envDuration("SIGNING_KEY_ROTATION", 24*time.Hour),
+			ActivationDelay: envDuration("SIGNING_KEY_ACTIVATION_DELAY", 5*time.Minute),
+			Overlap:         envDuration("SIGNING_KEY_OVERLAP", 2*time.Hour),
+		},
+
+		PostMaxLength:     envInt("POST_MAX_LENGTH", composer.DefaultMaxLength),
+		MarkdownEnabled:   envBool("MARKDOWN_ENABLED", true),
+		MarkdownCacheSize: envInt("MARKDOWN_CACHE_SIZE", 10000),
+
+		MediaStore: envString("MEDIA_STORE", blobstore.FileStore),
+		MediaDir:   envString("MEDIA_DIR", "media"),
+		MediaS3: blobstore.S3Config{
+			Endpoint:        os.Getenv("MEDIA_S3_ENDPOINT"),
+			Region:          envString("MEDIA_S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("MEDIA_S3_BUCKET"),
+			AccessKeyId:     os.Getenv("MEDIA_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("MEDIA_S3_SECRET_ACCESS_KEY"),
+		},
+		MediaLimits: media.Limits{
+			MaxSize:       int64(envInt("MEDIA_MAX_SIZE", int(media.DefaultLimits.MaxSize))),
+			MaxPixels:     envInt("MEDIA_MAX_PIXELS", media.DefaultLimits.MaxPixels),
+			MaxFrames:     envInt("MEDIA_MAX_FRAMES", media.DefaultLimits.MaxFrames),
+			ThumbnailSize: envInt("MEDIA_THUMBNAIL_SIZE", media.DefaultLimits.ThumbnailSize),
+		},
+
+		LinkPreviewEnabled: envBool("LINK_PREVIEW_ENABLED", true),
+		LinkPreview: preview.Policy{
+			Timeout:      envDuration("LINK_PREVIEW_TIMEOUT", preview.DefaultPolicy.Timeout),
+			MaxBodySize:  int64(envInt("LINK_PREVIEW_MAX_SIZE", int(preview.DefaultPolicy.MaxBodySize))),
+			MaxRedirects: envInt("LINK_PREVIEW_MAX_REDIRECTS", preview.DefaultPolicy.MaxRedirects),
+			AllowPrivate: envBool("LINK_PREVIEW_ALLOW_PRIVATE", false),
+		},
+		LinkPreviewTTL: envDuration("LINK_PREVIEW_TTL", 24*time.Hour),
+	}
+}
+
+func envString(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+func envBool(name string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 100
+)
+
+type lockoutResponse struct {
+	// Either login or ip
+	Kind        string `json:"kind"`
+	Value       string `json:"value"`
+	Failures    int    `json:"failures"`
+	LockedUntil string `json:"lockedUntil"`
+}
+
+type adminUserResponse struct {
+	Id          string `json:"id"`
+	Login       string `json:"login"`
+	Role        string `json:"role"`
+	Suspended   bool   `json:"suspended"`
+	TotpEnabled bool   `json:"totpEnabled"`
+}
+
+type suspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func newAdminUserResponse(user *storage.User) adminUserResponse {
+	return adminUserResponse{
+		Id:          user.Id,
+		Login:       user.Login,
+		Role:        auth.RoleOf(user.Role),
+		Suspended:   user.Suspended,
+		TotpEnabled: user.Totp != nil && user.Totp.Enabled,
+	}
+}
+
+// auditAdmin records action of principal
+func (h *Handler) auditAdmin(req *http.Request, action, target string, details map[string]string) {
+	h.record(req, &audit.Entry{
+		Actor:   auth.PrincipalFromContext(req.Context()).UserId,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+}
+
+func (h *Handler) ListLockouts(w http.ResponseWriter, req *http.Request) {
+	listLockoutsLogger := h.errorLogger(req, "ListLockouts")
+	lockouts, err := h.guard.Lockouts(req.Context())
+
+	if listLockoutsLogger.CheckError(err, w, "can't get lockouts", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	response := make([]lockoutResponse, 0, len(lockouts))
+	for _, l := range lockouts {
+		kind, value, _ := strings.Cut(l.Key, ":")
+		response = append(response, lockoutResponse{
+			Kind:        kind,
+			Value:       value,
+			Failures:    l.Failures,
+			LockedUntil: l.BlockedUntil.UTC().Format(time.RFC3339),
+		})
+	}
+
+	resp, _ := json.Marshal(map[string]interface{}{"lockouts": response})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+func (h *Handler) Unlock(w http.ResponseWriter, req *http.Request) {
+	unlockLogger := h.errorLogger(req, "Unlock")
+	vars := mux.Vars(req)
+
+	if vars["kind"] != "login" && vars["kind"] != "ip" {
+		unlockLogger.WriteError(w, "kind must be login or ip", http.StatusBadRequest)
+		return
+	}
+
+	actor := auth.PrincipalFromContext(req.Context()).UserId
+	err := h.guard.Unlock(req.Context(), vars["kind"]+":"+strings.ToLower(vars["value"]), actor, utils.ClientIp(req))
+
+	if unlockLogger.CheckError(err, w, "can't unlock", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListUsers(w http.ResponseWriter, req *http.Request) {
+	listUsersLogger := h.errorLogger(req, "ListUsers")
+	size := defaultUsersPageSize
+
+	if req.FormValue("size") != "" {
+		var err error
+		if size, err = strconv.Atoi(req.FormValue("size")); err != nil || size < 1 || size > maxUsersPageSize {
+			listUsersLogger.WriteError(w, "1 <= size <= 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := (*h.s).ListUsers(req.Context(), req.FormValue("page"), size)
+
+	if listUsersLogger.CheckError(err, w, "wrong page", http.StatusBadRequest) != nil {
+		return
+	}
+
+	response := make([]adminUserResponse, 0, len(users))
+	for i := range users {
+		response = append(response, newAdminUserResponse(&users[i]))
+	}
+
+	mapForResponse := map[string]interface{}{"users": response}
+	if len(users) == size {
+		mapForResponse["nextPage"] = users[len(users)-1].Id
+	}
+
+	resp, _ := json.Marshal(mapForResponse)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// targetUser returns user from path whom principal outranks, otherwise writes error and returns nil
+func (h *Handler) targetUser(w http.ResponseWriter, req *http.Request, xLogger *utils.ErrorLogger) *storage.User {
+	principal := auth.PrincipalFromContext(req.Context())
+	user, err := (*h.s).GetUserById(req.Context(), mux.Vars(req)["userId"])
+
+	if xLogger.CheckError(err, w, "user not found", http.StatusNotFound) != nil {
+		return nil
+	}
+
+	if user.Id == principal.UserId || !auth.Outranks(principal.Role, user.Role) {
+		xLogger.WriteError(w, "only users of lower role can be managed", http.StatusForbidden)
+		return nil
+	}
+
+	return user
+}
+
+// SuspendUser blocks login and every token of user until UnsuspendUser
+func (h *Handler) SuspendUser(w http.ResponseWriter, req *http.Request) {
+	suspendLogger := h.errorLogger(req, "SuspendUser")
+
+	var body suspendUserRequest
+	if suspendLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	user := h.targetUser(w, req, suspendLogger)
+
+	if user == nil {
+		return
+	}
+
+	err := (*h.s).SetUserSuspended(req.Context(), user.Id, true)
+
+	if suspendLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	err = (*h.s).RevokeSessions(req.Context(), user.Id, "")
+
+	if suspendLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.auditAdmin(req, "user.suspend", user.Id, map[string]string{"reason": body.Reason})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) UnsuspendUser(w http.ResponseWriter, req *http.Request) {
+	unsuspendLogger := h.errorLogger(req, "UnsuspendUser")
+	user := h.targetUser(w, req, unsuspendLogger)
+
+	if user == nil {
+		return
+	}
+
+	err := (*h.s).SetUserSuspended(req.Context(), user.Id, false)
+
+	if unsuspendLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.auditAdmin(req, "user.unsuspend", user.Id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole changes role of user of lower role, so admins can't demote each other
+func (h *Handler) SetUserRole(w http.ResponseWriter, req *http.Request) {
+	setRoleLogger := h.errorLogger(req, "SetUserRole")
+
+	var body setRoleRequest
+	if setRoleLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	if !auth.ValidRole(body.Role) {
+		setRoleLogger.WriteError(w, "unknown role "+body.Role, http.StatusBadRequest)
+		return
+	}
+
+	user := h.targetUser(w, req, setRoleLogger)
+
+	if user == nil {
+		return
+	}
+
+	err := (*h.s).SetUserRole(req.Context(), user.Id, body.Role)
+
+	if setRoleLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.auditAdmin(req, "user.role", user.Id, map[string]string{"from": auth.RoleOf(user.Role), "to": body.Role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForceDeletePost deletes post of any author
+func (h *Handler) ForceDeletePost(w http.ResponseWriter, req *http.Request) {
+	deletePostLogger := h.errorLogger(req, "ForceDeletePost")
+	post, err := (*h.s).DeletePost(req.Context(), mux.Vars(req)["postId"])
+
+	if errors.Is(err, storage.ErrNotFound) {
+		deletePostLogger.CheckError(err, w, "post not found", http.StatusNotFound)
+		return
+	} else if deletePostLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.unindexPost(req, post.Id)
+	h.auditAdmin(req, "post.delete", mux.Vars(req)["postId"], map[string]string{"author": post.AuthorId})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxApiTokenNameLength = 100
+
+type createApiTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Days until token expires, 0 means never
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+type apiTokenResponse struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	Prefix     string   `json:"prefix"`
+	CreatedAt  string   `json:"createdAt"`
+	LastUsedAt string   `json:"lastUsedAt,omitempty"`
+	ExpiresAt  string   `json:"expiresAt,omitempty"`
+	// OAuth client token was issued to
+	ClientId string `json:"clientId,omitempty"`
+	// Present only in response to creation
+	Token string `json:"token,omitempty"`
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newApiTokenResponse(t *storage.ApiToken) apiTokenResponse {
+	return apiTokenResponse{
+		Id:         t.Id,
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		Prefix:     t.Prefix,
+		CreatedAt:  t.CreatedAt.UTC().Format(time.RFC3339),
+		LastUsedAt: formatOptionalTime(t.LastUsedAt),
+		ExpiresAt:  formatOptionalTime(t.ExpiresAt),
+		ClientId:   t.ClientId,
+	}
+}
+
+func (h *Handler) auditApiToken(req *http.Request, action string, t *storage.ApiToken) {
+	h.record(req, &audit.Entry{
+		Actor:   t.UserId,
+		Action:  action,
+		Target:  t.Id,
+		Details: map[string]string{"name": t.Name, "scopes": strings.Join(t.Scopes, " ")},
+	})
+}
+
+func (h *Handler) CreateApiToken(w http.ResponseWriter, req *http.Request) {
+	createLogger := h.errorLogger(req, "CreateApiToken")
+	principal := auth.PrincipalFromContext(req.Context())
+
+	var body createApiTokenRequest
+	if createLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	if body.Name = strings.TrimSpace(body.Name); body.Name == "" || len(body.Name) > maxApiTokenNameLength {
+		createLogger.WriteError(w, "name must be non-empty and at most 100 bytes", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Scopes) == 0 || body.ExpiresInDays < 0 {
+		createLogger.WriteError(w, "scopes must be non-empty and expiresInDays non-negative", http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range body.Scopes {
+		if !auth.ValidScope(scope) {
+			createLogger.WriteError(w, "unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	apiToken := &storage.ApiToken{UserId: principal.UserId, Name: body.Name, Scopes: body.Scopes}
+	token, err := h.auth.NewApiToken(req.Context(), apiToken, time.Duration(body.ExpiresInDays)*24*time.Hour)
+
+	if createLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.auditApiToken(req, "api_token.create", apiToken)
+
+	response := newApiTokenResponse(apiToken)
+	response.Token = token
+	resp, _ := json.Marshal(response)
+	utils.WriteJsonToResponse(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) ListApiTokens(w http.ResponseWriter, req *http.Request) {
+	listLogger := h.errorLogger(req, "ListApiTokens")
+	principal := auth.PrincipalFromContext(req.Context())
+	tokens, err := (*h.s).ListApiTokens(req.Context(), principal.UserId)
+
+	if listLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	response := make([]apiTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, newApiTokenResponse(&tokens[i]))
+	}
+
+	resp, _ := json.Marshal(map[string]interface{}{"tokens": response})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+func (h *Handler) DeleteApiToken(w http.ResponseWriter, req *http.Request) {
+	deleteLogger := h.errorLogger(req, "DeleteApiToken")
+	principal := auth.PrincipalFromContext(req.Context())
+	tokenId := mux.Vars(req)["tokenId"]
+
+	err := (*h.s).DeleteApiToken(req.Context(), principal.UserId, tokenId)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		deleteLogger.CheckError(err, w, "token not found", http.StatusNotFound)
+		return
+	} else if deleteLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.auditApiToken(req, "api_token.delete", &storage.ApiToken{Id: tokenId, UserId: principal.UserId})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/utils"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 100
+)
+
+type auditEntryResponse struct {
+	Seq     int64             `json:"seq"`
+	Time    string            `json:"time"`
+	Actor   string            `json:"actor"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target"`
+	Ip      string            `json:"ip,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Hash    string            `json:"hash"`
+}
+
+type auditVerificationResponse struct {
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`
+	HeadSeq  int64  `json:"headSeq"`
+	HeadHash string `json:"headHash,omitempty"`
+	BrokenAt int64  `json:"brokenAt,omitempty"`
+}
+
+func newAuditEntryResponse(e *audit.Entry) auditEntryResponse {
+	return auditEntryResponse{
+		Seq:     e.Seq,
+		Time:    e.Time.UTC().Format(time.RFC3339Nano),
+		Actor:   e.Actor,
+		Action:  e.Action,
+		Target:  e.Target,
+		Ip:      e.Ip,
+		Details: e.Details,
+		Hash:    hex.EncodeToString(e.Hash),
+	}
+}
+
+// parseAuditFilter reads filter from query, page is seq of the last entry of previous page
+func parseAuditFilter(req *http.Request) (audit.Filter, error) {
+	f := audit.Filter{Actor: req.FormValue("actor"), Action: req.FormValue("action")}
+	var err error
+
+	if req.FormValue("from") != "" {
+		if f.From, err = time.Parse(time.RFC3339, req.FormValue("from")); err != nil {
+			return f, err
+		}
+	}
+
+	if req.FormValue("to") != "" {
+		if f.To, err = time.Parse(time.RFC3339, req.FormValue("to")); err != nil {
+			return f, err
+		}
+	}
+
+	if req.FormValue("page") != "" {
+		if f.BeforeSeq, err = strconv.ParseInt(req.FormValue("page"), 10, 64); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+func (h *Handler) ListAuditEntries(w http.ResponseWriter, req *http.Request) {
+	listAuditLogger := h.errorLogger(req, "ListAuditEntries")
+	size := defaultAuditPageSize
+
+	if req.FormValue("size") != "" {
+		var err error
+		if size, err = strconv.Atoi(req.FormValue("size")); err != nil || size < 1 || size > maxAuditPageSize {
+			listAuditLogger.WriteError(w, "1 <= size <= 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := parseAuditFilter(req)
+
+	if listAuditLogger.CheckError(err, w, "wrong filter", http.StatusBadRequest) != nil {
+		return
+	}
+
+	entries, err := h.audit.Query(req.Context(), filter, size)
+
+	if listAuditLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	response := make([]auditEntryResponse, 0, len(entries))
+	for i := range entries {
+		response = append(response, newAuditEntryResponse(&entries[i]))
+	}
+
+	mapForResponse := map[string]interface{}{"entries": response}
+	if len(entries) == size {
+		mapForResponse["nextPage"] = strconv.FormatInt(entries[len(entries)-1].Seq, 10)
+	}
+
+	resp, _ := json.Marshal(mapForResponse)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// VerifyAuditLog recomputes the whole chain, head hash should be compared with the one recorded earlier
+func (h *Handler) VerifyAuditLog(w http.ResponseWriter, req *http.Request) {
+	verifyLogger := h.errorLogger(req, "VerifyAuditLog")
+	v, err := h.audit.Verify(req.Context())
+
+	if verifyLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	response := auditVerificationResponse{Valid: v.BrokenAt == 0, Entries: v.Entries, BrokenAt: v.BrokenAt}
+	if v.Head != nil {
+		response.HeadSeq, response.HeadHash = v.Head.Seq, hex.EncodeToString(v.Head.Hash)
+	}
+
+	resp, _ := json.Marshal(response)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
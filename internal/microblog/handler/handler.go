@@ -1,42 +1,145 @@
 package handler
 
 import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/blobstore"
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/keys"
+	"blog/internal/microblog/lockout"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/markdown"
+	"blog/internal/microblog/media"
+	"blog/internal/microblog/metrics"
+	"blog/internal/microblog/notify"
+	"blog/internal/microblog/oauth"
+	"blog/internal/microblog/password"
+	"blog/internal/microblog/preview"
+	"blog/internal/microblog/search"
 	"blog/internal/microblog/storage"
 	"blog/internal/microblog/utils"
-	"context"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Components handler depends on besides storage
+type Components struct {
+	Guard            *lockout.Guard
+	Hasher           *password.Hasher
+	Policy           password.Policy
+	Auth             *auth.Authenticator
+	Audit            *audit.Log
+	Keys             *keys.Manager
+	Notifier         notify.Notifier
+	Metrics          *metrics.Metrics
+	OAuth            *oauth.Server
+	Search           search.Searcher
+	Blobs            blobstore.Store
+	MediaLimits      media.Limits
+	PostMaxLength    int
+	Markdown         *markdown.Renderer
+	Previews         *preview.Worker
+	PasswordResetTTL time.Duration
+	// Issuer shown in authenticator apps
+	TotpIssuer string
+}
+
 type Handler struct {
-	s *storage.Storage
+	s                *storage.Storage
+	guard            *lockout.Guard
+	hasher           *password.Hasher
+	policy           password.Policy
+	auth             *auth.Authenticator
+	audit            *audit.Log
+	keys             *keys.Manager
+	notifier         notify.Notifier
+	metrics          *metrics.Metrics
+	oauth            *oauth.Server
+	search           search.Searcher
+	blobs            blobstore.Store
+	mediaLimits      media.Limits
+	postMaxLength    int
+	markdown         *markdown.Renderer
+	previews         *preview.Worker
+	passwordResetTTL time.Duration
+	totpIssuer       string
+	logger           *slog.Logger
+
+	// Compared with password of unknown login to spend the same time as for existing one
+	dummyPasswordHash []byte
 }
 
-func NewHandler(s *storage.Storage) *Handler {
-	return &Handler{s: s}
+func NewHandler(s *storage.Storage, c Components, logger *slog.Logger) (*Handler, error) {
+	dummyPasswordHash, err := c.Hasher.Hash("dummy password")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		s:                 s,
+		guard:             c.Guard,
+		hasher:            c.Hasher,
+		policy:            c.Policy,
+		auth:              c.Auth,
+		audit:             c.Audit,
+		keys:              c.Keys,
+		notifier:          c.Notifier,
+		metrics:           c.Metrics,
+		oauth:             c.OAuth,
+		search:            c.Search,
+		blobs:             c.Blobs,
+		mediaLimits:       c.MediaLimits,
+		postMaxLength:     c.PostMaxLength,
+		markdown:          c.Markdown,
+		previews:          c.Previews,
+		passwordResetTTL:  c.PasswordResetTTL,
+		totpIssuer:        c.TotpIssuer,
+		logger:            logger,
+		dummyPasswordHash: dummyPasswordHash,
+	}, nil
 }
 
-var (
-	registerLogger     = utils.NewErrorLogger("RegisterNewUser")
-	loginLogger        = utils.NewErrorLogger("Login")
-	addPostLogger      = utils.NewErrorLogger("AddPost")
-	getPostLogger      = utils.NewErrorLogger("GetPost")
-	getUserPostsLogger = utils.NewErrorLogger("GetUserPosts")
+// checkPassword writes violated rules of password policy to client
+func (h *Handler) checkPassword(w http.ResponseWriter, xLogger *utils.ErrorLogger, login, pwd string) error {
+	err := h.policy.Validate(login, pwd)
 
-	passwordSalt = "abcdefgh12345"
-)
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		xLogger.WriteErrorDetails(w, "password doesn't satisfy policy", http.StatusBadRequest,
+			map[string]any{"violations": policyErr.Violations})
+	}
+
+	return err
+}
+
+// record writes audit entry of request, action is already done, so failure is only logged
+func (h *Handler) record(req *http.Request, entry *audit.Entry) {
+	entry.Ip = utils.ClientIp(req)
+
+	if err := h.audit.Record(req.Context(), entry); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't record audit entry", slog.String("action", entry.Action),
+			slog.String("error", err.Error()))
+	}
+}
+
+// Logger of handler funcName with request correlation attributes
+func (h *Handler) errorLogger(req *http.Request, funcName string) *utils.ErrorLogger {
+	return utils.NewErrorLogger(logging.ForRequest(h.logger, req), funcName)
+}
 
 // Todo: interface
 func (h *Handler) RegisterNewUser(w http.ResponseWriter, req *http.Request) {
+	registerLogger := h.errorLogger(req, "RegisterNewUser")
 	reqBody, err := io.ReadAll(req.Body)
 
 	if registerLogger.CheckError(err, w, "can't read body", http.StatusBadRequest) != nil {
@@ -49,11 +152,7 @@ func (h *Handler) RegisterNewUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	pwdHash, _ := bcrypt.GenerateFromPassword([]byte(userCredentials.Password + passwordSalt), 10)
-	newUser := storage.User{
-		Login:        userCredentials.Login,
-		PasswordHash: pwdHash,
-	}
+	newUser := storage.User{Login: userCredentials.Login}
 
 	validate := validator.New()
 	validate.RegisterValidation("login", utils.ValidateLogin)
@@ -61,33 +160,54 @@ func (h *Handler) RegisterNewUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = (*h.s).AddUser(context.Background(), &newUser)
-	if registerLogger.CheckError(err, w, "something went wrong", http.StatusBadRequest) != nil {
+	if h.checkPassword(w, registerLogger, userCredentials.Login, userCredentials.Password) != nil {
+		return
+	}
+
+	newUser.PasswordHash, err = h.hasher.Hash(userCredentials.Password)
+	newUser.PasswordHashVersion = password.EncodedVersion
+
+	if registerLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	err = (*h.s).AddUser(req.Context(), &newUser)
+
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		registerLogger.CheckError(err, w, "user with this login already exists", http.StatusConflict)
+		return
+	} else if registerLogger.CheckError(err, w, "something went wrong", http.StatusBadRequest) != nil {
 		return
 	}
 
+	h.record(req, &audit.Entry{
+		Actor:   newUser.Id,
+		Action:  "user.register",
+		Target:  newUser.Id,
+		Details: map[string]string{"login": newUser.Login},
+	})
+
 	resp, _ := json.Marshal(map[string]string{"id": newUser.Id})
 	utils.WriteJsonToResponse(w, http.StatusOK, resp)
 }
 
 func (h *Handler) AddPost(w http.ResponseWriter, req *http.Request) {
+	addPostLogger := h.errorLogger(req, "AddPost")
 	reqBody, err := io.ReadAll(req.Body)
 
 	if addPostLogger.CheckError(err, w, "can't read body", http.StatusBadRequest) != nil {
 		return
 	}
 
-	reqUserId, exist := (*req).Header["System-Design-User-Id"]
+	user, err := (*h.s).GetUserById(req.Context(), auth.PrincipalFromContext(req.Context()).UserId)
 
-	if !exist || len(reqUserId) != 1 {
-		log.Print("addPost: wrong id format")
-		utils.WriteErrorToResponse(w, http.StatusUnauthorized, "wrong id format")
+	if addPostLogger.CheckError(err, w, "user not found", http.StatusUnauthorized) != nil {
 		return
 	}
 
-	user, err := (*h.s).GetUserById(context.Background(), reqUserId[0])
-
-	if addPostLogger.CheckError(err, w, "user not found", http.StatusUnauthorized) != nil {
+	if user.Suspended {
+		addPostLogger.WriteError(w, auth.ErrSuspended.Error(), http.StatusForbidden)
 		return
 	}
 
@@ -98,35 +218,83 @@ func (h *Handler) AddPost(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	post.Text, err = composer.Compose(post.Text, h.postMaxLength)
+
+	if errors.Is(err, composer.ErrEmpty) || errors.Is(err, composer.ErrTooLong) {
+		addPostLogger.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if post.Format == storage.PlainFormat {
+		post.Format = ""
+	} else if post.Format == storage.MarkdownFormat && h.markdown == nil {
+		addPostLogger.WriteError(w, "markdown is disabled", http.StatusBadRequest)
+		return
+	} else if post.Format != "" && post.Format != storage.MarkdownFormat {
+		addPostLogger.WriteError(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	err = h.checkAttachments(req.Context(), user.Id, post.MediaIds)
+
+	if errors.Is(err, errBadAttachment) {
+		addPostLogger.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if addPostLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
 	post.AuthorId = user.Id
-	(*h.s).AddPost(context.Background(), &post)
+	err = (*h.s).AddPost(req.Context(), &post)
+
+	if addPostLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.indexPost(req, &post)
+	h.render(&post)
+	// Link of new post is fetched now, so preview is likely ready when followers read it
+	posts := []storage.Post{post}
+	h.attachPreviews(req, posts)
 
-	resp, _ := json.Marshal(post)
+	resp, _ := json.Marshal(posts[0])
 
 	utils.WriteJsonToResponse(w, http.StatusOK, resp)
 }
 
 // нужен ли указатель?
 func (h *Handler) GetPost(w http.ResponseWriter, req *http.Request) {
+	getPostLogger := h.errorLogger(req, "GetPost")
 	postId, ex := mux.Vars(req)["postId"]
 
 	if !ex {
-		log.Print("getPost: bad post id")
-		utils.WriteErrorToResponse(w, http.StatusNotFound, "bad post id")
+		getPostLogger.WriteError(w, "bad post id", http.StatusNotFound)
 		return
 	}
 
-	post, err := (*h.s).GetPost(context.Background(), postId)
+	post, err := (*h.s).GetPost(req.Context(), postId)
 
 	if getPostLogger.CheckError(err, w, "post not found", http.StatusNotFound) != nil {
 		return
 	}
 
-	resp, _ := json.Marshal(post)
+	h.render(post)
+	posts := []storage.Post{*post}
+	h.attachPreviews(req, posts)
+
+	resp, _ := json.Marshal(posts[0])
 	utils.WriteJsonToResponse(w, http.StatusOK, resp)
 }
 
+// render sets Html of markdown post, unchanged sources are rendered once, markdown is disabled without renderer
+func (h *Handler) render(post *storage.Post) {
+	if post.Format == storage.MarkdownFormat && h.markdown != nil {
+		post.Html = h.markdown.Render(post.Text)
+	}
+}
+
 func (h *Handler) GetUserPosts(w http.ResponseWriter, req *http.Request) {
+	getUserPostsLogger := h.errorLogger(req, "GetUserPosts")
 	page := req.FormValue("page")
 	size, err := strconv.Atoi(req.FormValue("size"))
 
@@ -137,15 +305,13 @@ func (h *Handler) GetUserPosts(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if size < 0 || size > 100 {
-		log.Print("getUserPosts: bad size value")
-		utils.WriteErrorToResponse(w, http.StatusBadRequest, "0 <= size <= 100")
+		getUserPostsLogger.WriteError(w, "0 <= size <= 100", http.StatusBadRequest)
 		return
 	}
 
 	userId, ex := mux.Vars(req)["userId"]
 	if !ex {
-		log.Print("getUserPosts: wrong url format")
-		utils.WriteErrorToResponse(w, http.StatusBadRequest, "wrong url format")
+		getUserPostsLogger.WriteError(w, "wrong url format", http.StatusBadRequest)
 		return
 	}
 
@@ -153,15 +319,20 @@ func (h *Handler) GetUserPosts(w http.ResponseWriter, req *http.Request) {
 	var nextPageToken string
 
 	if page == "" {
-		posts, nextPageToken, err = (*h.s).GetFirstPosts(context.Background(), userId, size)
+		posts, nextPageToken, err = (*h.s).GetFirstPosts(req.Context(), userId, size)
 	} else {
-		posts, nextPageToken, err = (*h.s).GetPostsFrom(context.Background(), page, userId, size)
+		posts, nextPageToken, err = (*h.s).GetPostsFrom(req.Context(), page, userId, size)
 	}
 
 	if getUserPostsLogger.CheckError(err, w, "wrong url format", http.StatusBadRequest) != nil {
 		return
 	}
 
+	for i := range posts {
+		h.render(&posts[i])
+	}
+	h.attachPreviews(req, posts)
+
 	mapForResponse := map[string]interface{}{"posts": posts}
 
 	if nextPageToken != "" {
@@ -174,6 +345,7 @@ func (h *Handler) GetUserPosts(w http.ResponseWriter, req *http.Request) {
 
 // TODO: messages in errors
 func (h *Handler) Login(w http.ResponseWriter, req *http.Request) {
+	loginLogger := h.errorLogger(req, "Login")
 	reqBody, err := io.ReadAll(req.Body)
 
 	if loginLogger.CheckError(err, w, "can't read body", http.StatusBadRequest) != nil {
@@ -186,29 +358,111 @@ func (h *Handler) Login(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := (*h.s).GetUserByLogin(context.Background(), userCredentials.Login)
+	ip := utils.ClientIp(req)
+	wait, err := h.guard.Check(req.Context(), userCredentials.Login, ip)
 
-	if loginLogger.CheckError(err, w, "user not found", http.StatusBadRequest) != nil {
+	if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(userCredentials.Password))
-	if loginLogger.CheckError(err, w, "wrong password", http.StatusBadRequest) != nil {
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		loginLogger.WriteError(w, "too many failed attempts, try later", http.StatusTooManyRequests)
 		return
 	}
 
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer:    user.Login,
-		ExpiresAt: time.Now().Add(time.Hour * 1).Unix(),
-	})
+	// Unknown login and wrong password must be indistinguishable, even by response time
+	user, err := (*h.s).GetUserByLogin(req.Context(), userCredentials.Login)
+	pwdHash, pwdHashVersion := h.dummyPasswordHash, password.EncodedVersion
+	if err == nil {
+		pwdHash, pwdHashVersion = user.PasswordHash, user.PasswordHashVersion
+	}
+
+	rehash, pwdErr := h.hasher.Verify(userCredentials.Password, pwdHash, pwdHashVersion)
+	if err == nil {
+		err = pwdErr
+	}
+
+	if loginLogger.CheckError(err, w, "wrong login or password", http.StatusBadRequest) != nil {
+		h.metrics.ObserveLogin(false)
+		h.auditLoginFailure(req, "anonymous", userCredentials.Login, "password")
+
+		if err := h.guard.Failure(req.Context(), userCredentials.Login, ip); err != nil {
+			logging.ForRequest(h.logger, req).Error("can't record login failure", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	// Password is known only now, so outdated hash is replaced on login. Failure here must not fail login.
+	if rehash {
+		if err := h.rehashPassword(req, user, userCredentials.Password); err != nil {
+			logging.ForRequest(h.logger, req).Error("can't rehash password", slog.String("error", err.Error()))
+		}
+	}
 
-	token, err := claims.SignedString([]byte("secretKeycxvsdfdsfsdsdffsdsdfdsfsdfsdfsfdfsfdssfd"))
+	// Suspension is revealed only to whoever knows the password
+	if user.Suspended {
+		loginLogger.WriteError(w, auth.ErrSuspended.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Second factor is checked by LoginTotp, which accepts challenge instead of password
+	if user.Totp != nil && user.Totp.Enabled {
+		challenge, err := h.auth.NewChallenge(user)
 
-	if loginLogger.CheckError(err, w, "wrong password", http.StatusBadRequest) != nil {
+		if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+			return
+		}
+
+		response, _ := json.Marshal(map[string]string{"challenge": challenge, "factor": "totp"})
+		utils.WriteJsonToResponse(w, http.StatusOK, response)
 		return
 	}
 
+	// Failures are forgotten only when session is issued, otherwise password login would reset attempts of second factor
+	if err := h.guard.Success(req.Context(), userCredentials.Login); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't reset login attempts", slog.String("error", err.Error()))
+	}
+
+	token, _, err := h.auth.NewSession(req.Context(), user)
+
+	if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.metrics.ObserveLogin(true)
+	h.auditLoginSuccess(req, user, "password")
 	response, _ := json.Marshal(map[string]string{"token": token})
 
 	utils.WriteJsonToResponse(w, http.StatusOK, response)
 }
+
+// Factor is the last one user passed: password, totp or recoveryCode
+func (h *Handler) auditLoginSuccess(req *http.Request, user *storage.User, factor string) {
+	h.record(req, &audit.Entry{
+		Actor:   user.Id,
+		Action:  "login.success",
+		Target:  user.Id,
+		Details: map[string]string{"factor": factor},
+	})
+}
+
+// Target is login key like in lockout entries, login may not exist
+func (h *Handler) auditLoginFailure(req *http.Request, actor, login, factor string) {
+	h.record(req, &audit.Entry{
+		Actor:   actor,
+		Action:  "login.failure",
+		Target:  lockout.LoginKey(login),
+		Details: map[string]string{"factor": factor},
+	})
+}
+
+func (h *Handler) rehashPassword(req *http.Request, user *storage.User, pwd string) error {
+	hash, err := h.hasher.Hash(pwd)
+
+	if err != nil {
+		return err
+	}
+
+	return (*h.s).UpdatePasswordHash(req.Context(), user.Id, hash, password.EncodedVersion)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	s      *storage.Storage
+	logger *slog.Logger
+	// Non-zero after shutdown began, balancers must stop sending requests
+	shuttingDown int32
+}
+
+func NewHealthHandler(s *storage.Storage, logger *slog.Logger) *HealthHandler {
+	return &HealthHandler{s: s, logger: logger}
+}
+
+func (h *HealthHandler) SetShuttingDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// Process is alive while it can serve this request
+func (h *HealthHandler) Liveness(w http.ResponseWriter, req *http.Request) {
+	resp, _ := json.Marshal(map[string]string{"status": "ok"})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+func (h *HealthHandler) Readiness(w http.ResponseWriter, req *http.Request) {
+	readinessLogger := utils.NewErrorLogger(logging.ForRequest(h.logger, req), "Readiness")
+
+	if atomic.LoadInt32(&h.shuttingDown) != 0 {
+		readinessLogger.WriteError(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+	defer cancel()
+
+	if readinessLogger.CheckError((*h.s).Ping(ctx), w, "storage is not ready", http.StatusServiceUnavailable) != nil {
+		return
+	}
+
+	resp, _ := json.Marshal(map[string]string{"status": "ok"})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"net/http"
+)
+
+// Jwks publishes public keys which verify access tokens
+func (h *Handler) Jwks(w http.ResponseWriter, req *http.Request) {
+	resp, _ := json.Marshal(h.keys.JWKS())
+
+	// Cached for less than activation delay, so verifiers get new key before it signs
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
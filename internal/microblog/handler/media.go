@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/blobstore"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/media"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxAttachments = 4
+	// Room for boundaries and headers of multipart body around the file
+	multipartOverhead = 64 << 10
+	// Media is never changed, new upload gets new id
+	mediaCacheControl = "public, max-age=31536000, immutable"
+)
+
+var errBadAttachment = errors.New("bad attachment")
+
+type mediaResponse struct {
+	Id           string `json:"id"`
+	ContentType  string `json:"contentType"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Url          string `json:"url"`
+	ThumbnailUrl string `json:"thumbnailUrl"`
+}
+
+func newMediaId() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate media id - %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// checkAttachments allows at most maxAttachments distinct media uploaded by user
+func (h *Handler) checkAttachments(ctx context.Context, userId string, mediaIds []string) error {
+	if len(mediaIds) > maxAttachments {
+		return fmt.Errorf("%w - post can have at most %d media", errBadAttachment, maxAttachments)
+	}
+
+	seen := map[string]bool{}
+	for _, id := range mediaIds {
+		if seen[id] {
+			return fmt.Errorf("%w - media %s is attached twice", errBadAttachment, id)
+		}
+		seen[id] = true
+
+		m, err := (*h.s).GetMedia(ctx, id)
+
+		if errors.Is(err, storage.ErrNotFound) || err == nil && m.OwnerId != userId {
+			return fmt.Errorf("%w - media %s not found", errBadAttachment, id)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readUpload processes "file" part of multipart body without keeping the whole body in memory
+func (h *Handler) readUpload(req *http.Request) (*media.Image, error) {
+	reader, err := req.MultipartReader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			return nil, errors.New("file is missing")
+		} else if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "file" {
+			return media.Process(part, h.mediaLimits)
+		}
+	}
+}
+
+// UploadMedia stores image without metadata and its thumbnail, then it can be attached to posts
+func (h *Handler) UploadMedia(w http.ResponseWriter, req *http.Request) {
+	uploadLogger := h.errorLogger(req, "UploadMedia")
+	req.Body = http.MaxBytesReader(w, req.Body, h.mediaLimits.MaxSize+multipartOverhead)
+	img, err := h.readUpload(req)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, media.ErrTooLarge) || errors.Is(err, media.ErrTooManyPix) || errors.Is(err, media.ErrTooManyFrames) ||
+		errors.As(err, &maxBytesErr) {
+		uploadLogger.WriteError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if errors.Is(err, media.ErrUnsupported) {
+		uploadLogger.WriteError(w, media.ErrUnsupported.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if uploadLogger.CheckError(err, w, "multipart body with file is expected", http.StatusBadRequest) != nil {
+		return
+	}
+
+	id, err := newMediaId()
+
+	if uploadLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	m := &storage.Media{
+		Id:                   id,
+		OwnerId:              auth.PrincipalFromContext(req.Context()).UserId,
+		ContentType:          img.ContentType,
+		Size:                 len(img.Data),
+		Width:                img.Width,
+		Height:               img.Height,
+		Key:                  "media/" + id + "/original",
+		ThumbnailKey:         "media/" + id + "/thumbnail",
+		ThumbnailContentType: img.ThumbnailContentType,
+	}
+
+	err = h.blobs.Put(req.Context(), m.Key, img.Data, m.ContentType)
+	if err == nil {
+		err = h.blobs.Put(req.Context(), m.ThumbnailKey, img.Thumbnail, m.ThumbnailContentType)
+	}
+	if err == nil {
+		err = (*h.s).AddMedia(req.Context(), m)
+	}
+
+	if err != nil {
+		h.deleteBlobs(req, m)
+		uploadLogger.CheckError(err, w, "can't store media", http.StatusInternalServerError)
+		return
+	}
+
+	resp, _ := json.Marshal(mediaResponse{
+		Id:           m.Id,
+		ContentType:  m.ContentType,
+		Size:         m.Size,
+		Width:        m.Width,
+		Height:       m.Height,
+		Url:          "/api/v1/media/" + m.Id,
+		ThumbnailUrl: "/api/v1/media/" + m.Id + "/thumbnail",
+	})
+
+	utils.WriteJsonToResponse(w, http.StatusCreated, resp)
+}
+
+// deleteBlobs cleans up after failed upload, blobs left behind are only wasted space
+func (h *Handler) deleteBlobs(req *http.Request, m *storage.Media) {
+	for _, key := range []string{m.Key, m.ThumbnailKey} {
+		if err := h.blobs.Delete(req.Context(), key); err != nil {
+			logging.ForRequest(h.logger, req).Warn("can't delete blob", slog.String("key", key),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (h *Handler) GetMedia(w http.ResponseWriter, req *http.Request) {
+	h.serveMedia(w, req, "GetMedia", false)
+}
+
+func (h *Handler) GetMediaThumbnail(w http.ResponseWriter, req *http.Request) {
+	h.serveMedia(w, req, "GetMediaThumbnail", true)
+}
+
+func (h *Handler) serveMedia(w http.ResponseWriter, req *http.Request, funcName string, thumbnail bool) {
+	getMediaLogger := h.errorLogger(req, funcName)
+	m, err := (*h.s).GetMedia(req.Context(), mux.Vars(req)["mediaId"])
+
+	if errors.Is(err, storage.ErrNotFound) {
+		getMediaLogger.WriteError(w, "media not found", http.StatusNotFound)
+		return
+	} else if getMediaLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	key, contentType := m.Key, m.ContentType
+	if thumbnail {
+		key, contentType = m.ThumbnailKey, m.ThumbnailContentType
+	}
+
+	blob, err := h.blobs.Get(req.Context(), key)
+
+	if errors.Is(err, blobstore.ErrNotFound) {
+		getMediaLogger.WriteError(w, "media not found", http.StatusNotFound)
+		return
+	} else if getMediaLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", mediaCacheControl)
+	// Browsers must not guess another type of content uploaded by user
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.Itoa(m.Size))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	io.Copy(w, blob)
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/oauth"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type registerOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	// Confidential clients get secret, public ones like mobile apps rely on PKCE only
+	Confidential bool `json:"confidential"`
+}
+
+type oauthClientResponse struct {
+	ClientId     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+}
+
+type consentResponse struct {
+	ClientId    string   `json:"clientId"`
+	ClientName  string   `json:"clientName"`
+	Scopes      []string `json:"scopes"`
+	RedirectUri string   `json:"redirectUri"`
+}
+
+type consentDecisionRequest struct {
+	Approved bool `json:"approved"`
+}
+
+// writeOAuthError writes error of oauth package in RFC 6749 format
+func (h *Handler) writeOAuthError(w http.ResponseWriter, req *http.Request, xLogger *utils.ErrorLogger, err error) {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		xLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == oauth.ErrInvalidClient {
+		status = http.StatusUnauthorized
+	}
+
+	logging.ForRequest(h.logger, req).Warn("oauth error", slog.Int("status", status), slog.String("error", oauthErr.Error()))
+	resp, _ := json.Marshal(oauthErr)
+	utils.WriteJsonToResponse(w, status, resp)
+}
+
+// redirectWithError sends error of validated client to its redirect uri, as RFC 6749 requires
+func redirectWithError(w http.ResponseWriter, status int, r *oauth.AuthorizationRequest, oauthErr *oauth.Error) {
+	params := url.Values{"error": {oauthErr.Code}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if r.State != "" {
+		params.Set("state", r.State)
+	}
+
+	resp, _ := json.Marshal(map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+		"redirectTo":        oauth.RedirectUri(r.RedirectUri, params),
+	})
+	utils.WriteJsonToResponse(w, status, resp)
+}
+
+func (h *Handler) RegisterOAuthClient(w http.ResponseWriter, req *http.Request) {
+	registerLogger := h.errorLogger(req, "RegisterOAuthClient")
+	principal := auth.PrincipalFromContext(req.Context())
+
+	var body registerOAuthClientRequest
+	if registerLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	client, secret, err := h.oauth.RegisterClient(req.Context(), principal.UserId, strings.TrimSpace(body.Name),
+		body.RedirectUris, body.Scopes, body.Confidential)
+
+	if err != nil {
+		h.writeOAuthError(w, req, registerLogger, err)
+		return
+	}
+
+	resp, _ := json.Marshal(oauthClientResponse{
+		ClientId:     client.Id,
+		ClientSecret: secret,
+		Name:         client.Name,
+		RedirectUris: client.RedirectUris,
+		Scopes:       client.Scopes,
+	})
+	utils.WriteJsonToResponse(w, http.StatusCreated, resp)
+}
+
+// validateAuthorization writes error and returns nil if authorization request in query is invalid
+func (h *Handler) validateAuthorization(w http.ResponseWriter, req *http.Request, xLogger *utils.ErrorLogger) *oauth.AuthorizationRequest {
+	r := oauth.ParseAuthorizationRequest(req.URL.Query())
+	client, err := h.oauth.ValidateClient(req.Context(), &r)
+
+	if err != nil {
+		h.writeOAuthError(w, req, xLogger, err)
+		return nil
+	}
+
+	var oauthErr *oauth.Error
+	if err := h.oauth.ValidateParams(client, &r); errors.As(err, &oauthErr) {
+		redirectWithError(w, http.StatusBadRequest, &r, oauthErr)
+		return nil
+	}
+
+	return &r
+}
+
+// AuthorizationConsent describes authorization request for consent screen
+func (h *Handler) AuthorizationConsent(w http.ResponseWriter, req *http.Request) {
+	consentLogger := h.errorLogger(req, "AuthorizationConsent")
+	r := h.validateAuthorization(w, req, consentLogger)
+
+	if r == nil {
+		return
+	}
+
+	client, err := (*h.s).GetOAuthClient(req.Context(), r.ClientId)
+
+	if consentLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	resp, _ := json.Marshal(consentResponse{
+		ClientId:    client.Id,
+		ClientName:  client.Name,
+		Scopes:      r.Scopes,
+		RedirectUri: r.RedirectUri,
+	})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// AuthorizationDecision records consent of user and returns where user agent must be redirected
+func (h *Handler) AuthorizationDecision(w http.ResponseWriter, req *http.Request) {
+	decisionLogger := h.errorLogger(req, "AuthorizationDecision")
+	principal := auth.PrincipalFromContext(req.Context())
+	r := h.validateAuthorization(w, req, decisionLogger)
+
+	if r == nil {
+		return
+	}
+
+	var body consentDecisionRequest
+	if decisionLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	if !body.Approved {
+		redirectWithError(w, http.StatusOK, r, &oauth.Error{Code: oauth.ErrAccessDenied, Description: "user denied access"})
+		return
+	}
+
+	code, err := h.oauth.Authorize(req.Context(), principal.UserId, r)
+
+	if decisionLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.record(req, &audit.Entry{
+		Actor:   principal.UserId,
+		Action:  "oauth.authorize",
+		Target:  r.ClientId,
+		Details: map[string]string{"scopes": strings.Join(r.Scopes, " ")},
+	})
+
+	params := url.Values{"code": {code}}
+	if r.State != "" {
+		params.Set("state", r.State)
+	}
+
+	resp, _ := json.Marshal(map[string]string{"redirectTo": oauth.RedirectUri(r.RedirectUri, params)})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// Client credentials are taken from basic auth, or from form for clients which can't send it
+func clientCredentials(req *http.Request) (string, string) {
+	if id, secret, ok := req.BasicAuth(); ok {
+		return id, secret
+	}
+
+	return req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+}
+
+func (h *Handler) OAuthToken(w http.ResponseWriter, req *http.Request) {
+	tokenLogger := h.errorLogger(req, "OAuthToken")
+
+	if tokenLogger.CheckError(req.ParseForm(), w, "can't parse form", http.StatusBadRequest) != nil {
+		return
+	}
+
+	clientId, clientSecret := clientCredentials(req)
+	response, apiToken, err := h.oauth.Exchange(req.Context(), &oauth.TokenRequest{
+		GrantType:    req.PostFormValue("grant_type"),
+		Code:         req.PostFormValue("code"),
+		RedirectUri:  req.PostFormValue("redirect_uri"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		CodeVerifier: req.PostFormValue("code_verifier"),
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		h.writeOAuthError(w, req, tokenLogger, err)
+		return
+	}
+
+	h.auditApiToken(req, "oauth.token", apiToken)
+	resp, _ := json.Marshal(response)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+func (h *Handler) OAuthIntrospect(w http.ResponseWriter, req *http.Request) {
+	introspectLogger := h.errorLogger(req, "OAuthIntrospect")
+
+	if introspectLogger.CheckError(req.ParseForm(), w, "can't parse form", http.StatusBadRequest) != nil {
+		return
+	}
+
+	clientId, clientSecret := clientCredentials(req)
+	response, err := h.oauth.Introspect(req.Context(), clientId, clientSecret, req.PostFormValue("token"))
+
+	if err != nil {
+		h.writeOAuthError(w, req, introspectLogger, err)
+		return
+	}
+
+	resp, _ := json.Marshal(response)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
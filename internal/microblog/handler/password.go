@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/notify"
+	"blog/internal/microblog/password"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type passwordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type confirmPasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func readJson(req *http.Request, v interface{}) error {
+	reqBody, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(reqBody, v)
+}
+
+// setPassword stores hash of new password and revokes every session of user except keepSession
+func (h *Handler) setPassword(req *http.Request, user *storage.User, pwd string, keepSession string, action string) error {
+	hash, err := h.hasher.Hash(pwd)
+
+	if err != nil {
+		return err
+	}
+
+	if err := (*h.s).UpdatePasswordHash(req.Context(), user.Id, hash, password.EncodedVersion); err != nil {
+		return err
+	}
+
+	if err := (*h.s).RevokeSessions(req.Context(), user.Id, keepSession); err != nil {
+		return err
+	}
+
+	return h.audit.Record(req.Context(), &audit.Entry{
+		Actor:  user.Id,
+		Action: action,
+		Target: user.Id,
+		Ip:     utils.ClientIp(req),
+	})
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, req *http.Request) {
+	changeLogger := h.errorLogger(req, "ChangePassword")
+	principal := auth.PrincipalFromContext(req.Context())
+
+	var body changePasswordRequest
+	if changeLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserById(req.Context(), principal.UserId)
+
+	if changeLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	// Stolen token must not allow guessing current password faster than login does
+	ip := utils.ClientIp(req)
+	wait, err := h.guard.Check(req.Context(), user.Login, ip)
+
+	if changeLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		changeLogger.WriteError(w, "too many failed attempts, try later", http.StatusTooManyRequests)
+		return
+	}
+
+	_, err = h.hasher.Verify(body.CurrentPassword, user.PasswordHash, user.PasswordHashVersion)
+
+	if changeLogger.CheckError(err, w, "wrong current password", http.StatusBadRequest) != nil {
+		if err := h.guard.Failure(req.Context(), user.Login, ip); err != nil {
+			logging.ForRequest(h.logger, req).Error("can't record login failure", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	if h.checkPassword(w, changeLogger, user.Login, body.NewPassword) != nil {
+		return
+	}
+
+	err = h.setPassword(req, user, body.NewPassword, principal.Session.Id, "password.change")
+
+	if changeLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestPasswordReset answers the same whether login exists or not
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	resetLogger := h.errorLogger(req, "RequestPasswordReset")
+
+	var body passwordResetRequest
+	if resetLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserByLogin(req.Context(), body.Login)
+
+	if err != nil {
+		logging.ForRequest(h.logger, req).Info("password reset of unknown login", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Failure is only logged, otherwise its status would reveal that login exists
+	if err := h.sendPasswordReset(req, user); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't send password reset", slog.String("userId", user.Id),
+			slog.String("error", err.Error()))
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) sendPasswordReset(req *http.Request, user *storage.User) error {
+	token, tokenHash, err := auth.NewOpaqueToken()
+
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(h.passwordResetTTL).UTC()
+	err = (*h.s).AddPasswordReset(req.Context(), &storage.PasswordReset{TokenHash: tokenHash, UserId: user.Id, ExpiresAt: expiresAt})
+
+	if err != nil {
+		return fmt.Errorf("can't add password reset - %w", err)
+	}
+
+	err = h.notifier.Notify(req.Context(), notify.Message{
+		UserId:  user.Id,
+		Login:   user.Login,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use token %s to reset your password until %s. Ignore this message if you didn't request reset.",
+			token, expiresAt.Format(time.RFC3339)),
+	})
+
+	if err != nil {
+		return fmt.Errorf("can't notify user - %w", err)
+	}
+
+	return nil
+}
+
+func (h *Handler) ConfirmPasswordReset(w http.ResponseWriter, req *http.Request) {
+	confirmLogger := h.errorLogger(req, "ConfirmPasswordReset")
+
+	var body confirmPasswordResetRequest
+	if confirmLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	tokenHash := auth.HashOpaqueToken(body.Token)
+	reset, err := (*h.s).GetPasswordReset(req.Context(), tokenHash, time.Now())
+
+	if errors.Is(err, storage.ErrNotFound) {
+		confirmLogger.CheckError(err, w, "invalid or expired reset token", http.StatusBadRequest)
+		return
+	} else if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserById(req.Context(), reset.UserId)
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	// Policy is checked before token is used, so rejected password doesn't burn it
+	if h.checkPassword(w, confirmLogger, user.Login, body.Password) != nil {
+		return
+	}
+
+	err = (*h.s).UsePasswordReset(req.Context(), tokenHash, time.Now())
+
+	if errors.Is(err, storage.ErrNotFound) {
+		confirmLogger.CheckError(err, w, "invalid or expired reset token", http.StatusBadRequest)
+		return
+	} else if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	err = h.setPassword(req, user, body.Password, "", "password.reset")
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	// Owner proved control over the account, lockout must not keep them out
+	if err := h.guard.Success(req.Context(), user.Login); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't reset login attempts", slog.String("error", err.Error()))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
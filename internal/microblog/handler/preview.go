@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/preview"
+	"blog/internal/microblog/storage"
+	"log/slog"
+	"net/http"
+)
+
+// previewUrl is normalized first link of post text, empty if there is none
+func previewUrl(text string) string {
+	for _, entity := range composer.Entities(text) {
+		if entity.Type != composer.URL {
+			continue
+		}
+
+		if url, err := preview.Normalize(entity.Value); err == nil {
+			return url
+		}
+	}
+
+	return ""
+}
+
+// attachPreviews sets Preview of posts whose link is already fetched, others are fetched in background
+// and shown on later reads. Previews are optional, so failure is only logged
+func (h *Handler) attachPreviews(req *http.Request, posts []storage.Post) {
+	if h.previews == nil {
+		return
+	}
+
+	urls := make([]string, len(posts))
+	linked := make([]string, 0, len(posts))
+	for i := range posts {
+		urls[i] = previewUrl(posts[i].Text)
+		if urls[i] != "" {
+			linked = append(linked, urls[i])
+		}
+	}
+
+	previews, err := h.previews.Previews(req.Context(), linked)
+
+	if err != nil {
+		logging.ForRequest(h.logger, req).Warn("can't find link previews", slog.String("error", err.Error()))
+		return
+	}
+
+	for i, url := range urls {
+		if p, ok := previews[url]; ok {
+			posts[i].Preview = &p
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/profile"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchQueryLength  = 50
+)
+
+// profileResponse is the only form in which users are shown to other users
+type profileResponse struct {
+	Id            string `json:"id"`
+	Login         string `json:"login"`
+	DisplayName   string `json:"displayName,omitempty"`
+	Bio           string `json:"bio,omitempty"`
+	Location      string `json:"location,omitempty"`
+	Website       string `json:"website,omitempty"`
+	AvatarMediaId string `json:"avatarMediaId,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+func newProfileResponse(user *storage.User) profileResponse {
+	return profileResponse{
+		Id:            user.Id,
+		Login:         user.Login,
+		DisplayName:   user.Profile.DisplayName,
+		Bio:           user.Profile.Bio,
+		Location:      user.Profile.Location,
+		Website:       user.Profile.Website,
+		AvatarMediaId: user.Profile.AvatarMediaId,
+		CreatedAt:     user.CreatedAt().Format(time.RFC3339),
+	}
+}
+
+func writeProfile(w http.ResponseWriter, user *storage.User) {
+	resp, _ := json.Marshal(newProfileResponse(user))
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+func (h *Handler) GetProfile(w http.ResponseWriter, req *http.Request) {
+	getProfileLogger := h.errorLogger(req, "GetProfile")
+	user, err := (*h.s).GetUserById(req.Context(), mux.Vars(req)["userId"])
+
+	if getProfileLogger.CheckError(err, w, "user not found", http.StatusNotFound) != nil {
+		return
+	}
+
+	writeProfile(w, user)
+}
+
+func (h *Handler) GetProfileByLogin(w http.ResponseWriter, req *http.Request) {
+	getProfileLogger := h.errorLogger(req, "GetProfileByLogin")
+	user, err := (*h.s).GetUserByLogin(req.Context(), mux.Vars(req)["login"])
+
+	if getProfileLogger.CheckError(err, w, "user not found", http.StatusNotFound) != nil {
+		return
+	}
+
+	writeProfile(w, user)
+}
+
+// SearchUsers finds users by prefix of login, display name or its words ignoring case
+func (h *Handler) SearchUsers(w http.ResponseWriter, req *http.Request) {
+	searchLogger := h.errorLogger(req, "SearchUsers")
+	query := strings.ToLower(strings.TrimSpace(req.FormValue("q")))
+
+	if query == "" || utf8.RuneCountInString(query) > maxSearchQueryLength {
+		searchLogger.WriteError(w, "1 <= length of q <= 50", http.StatusBadRequest)
+		return
+	}
+
+	size := defaultSearchPageSize
+	if req.FormValue("size") != "" {
+		var err error
+		if size, err = strconv.Atoi(req.FormValue("size")); err != nil || size < 1 || size > maxSearchPageSize {
+			searchLogger.WriteError(w, "1 <= size <= 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := (*h.s).SearchUsers(req.Context(), query, req.FormValue("page"), size)
+
+	if searchLogger.CheckError(err, w, "wrong page", http.StatusBadRequest) != nil {
+		return
+	}
+
+	response := make([]profileResponse, 0, len(users))
+	for i := range users {
+		response = append(response, newProfileResponse(&users[i]))
+	}
+
+	mapForResponse := map[string]interface{}{"users": response}
+	if len(users) == size {
+		mapForResponse["nextPage"] = users[len(users)-1].Id
+	}
+
+	resp, _ := json.Marshal(mapForResponse)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// UpdateProfile changes fields present in body, empty string clears field
+func (h *Handler) UpdateProfile(w http.ResponseWriter, req *http.Request) {
+	updateProfileLogger := h.errorLogger(req, "UpdateProfile")
+
+	var patch profile.Patch
+	if updateProfileLogger.CheckError(readJson(req, &patch), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserById(req.Context(), auth.PrincipalFromContext(req.Context()).UserId)
+
+	if updateProfileLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	user.Profile = patch.Apply(user.Profile)
+	err = profile.Validate(&user.Profile)
+
+	var validationErr *profile.ValidationError
+	if errors.As(err, &validationErr) {
+		updateProfileLogger.WriteErrorDetails(w, "profile is invalid", http.StatusBadRequest,
+			map[string]any{"violations": validationErr.Violations})
+		return
+	}
+
+	// Avatar is one of images uploaded by user
+	if patch.AvatarMediaId != nil && user.Profile.AvatarMediaId != "" {
+		err = h.checkAttachments(req.Context(), user.Id, []string{user.Profile.AvatarMediaId})
+
+		if errors.Is(err, errBadAttachment) {
+			updateProfileLogger.WriteErrorDetails(w, "profile is invalid", http.StatusBadRequest,
+				map[string]any{"violations": []profile.Violation{{Field: "avatarMediaId", Message: "media not found"}}})
+			return
+		} else if updateProfileLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+			return
+		}
+	}
+
+	err = (*h.s).UpdateProfile(req.Context(), user.Id, &user.Profile)
+
+	if updateProfileLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	writeProfile(w, user)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/search"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// indexPost makes stored post findable, post is stored anyway, so failure is only logged
+func (h *Handler) indexPost(req *http.Request, post *storage.Post) {
+	if err := h.search.Index(req.Context(), post); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't index post", slog.String("postId", post.Id),
+			slog.String("error", err.Error()))
+	}
+}
+
+func (h *Handler) unindexPost(req *http.Request, postId string) {
+	if err := h.search.Remove(req.Context(), postId); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't remove post from index", slog.String("postId", postId),
+			slog.String("error", err.Error()))
+	}
+}
+
+// parseSearchQuery reads q with its filters, sort, time range and page from query
+func parseSearchQuery(req *http.Request) (*search.Query, error) {
+	q, err := search.Parse(req.FormValue("q"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.FormValue("sort") {
+	case "":
+	case search.SortRelevance, search.SortRecent:
+		q.Sort = req.FormValue("sort")
+	default:
+		return nil, errors.New("sort must be relevance or recent")
+	}
+
+	if req.FormValue("from") != "" {
+		if q.From, err = time.Parse(time.RFC3339, req.FormValue("from")); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.FormValue("to") != "" {
+		if q.To, err = time.Parse(time.RFC3339, req.FormValue("to")); err != nil {
+			return nil, err
+		}
+	}
+
+	q.Page = req.FormValue("page")
+
+	return q, nil
+}
+
+// SearchPosts finds posts by words, "phrases", from:login and #hashtags of q
+func (h *Handler) SearchPosts(w http.ResponseWriter, req *http.Request) {
+	searchLogger := h.errorLogger(req, "SearchPosts")
+	query, err := parseSearchQuery(req)
+
+	if searchLogger.CheckError(err, w, "wrong query", http.StatusBadRequest) != nil {
+		return
+	}
+
+	size := defaultSearchPageSize
+	if req.FormValue("size") != "" {
+		if size, err = strconv.Atoi(req.FormValue("size")); err != nil || size < 1 || size > maxSearchPageSize {
+			searchLogger.WriteError(w, "1 <= size <= 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := &search.Result{Posts: make([]storage.Post, 0)}
+	if query.AuthorLogin != "" {
+		author, err := (*h.s).GetUserByLogin(req.Context(), query.AuthorLogin)
+
+		// Unknown author has no posts
+		if err != nil {
+			h.writeSearchResult(w, req, result)
+			return
+		}
+
+		query.AuthorId = author.Id
+	}
+
+	result, err = h.search.Search(req.Context(), query, size)
+
+	if errors.Is(err, search.ErrBadPage) {
+		searchLogger.CheckError(err, w, "wrong page", http.StatusBadRequest)
+		return
+	} else if searchLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.writeSearchResult(w, req, result)
+}
+
+func (h *Handler) writeSearchResult(w http.ResponseWriter, req *http.Request, result *search.Result) {
+	for i := range result.Posts {
+		h.render(&result.Posts[i])
+	}
+	h.attachPreviews(req, result.Posts)
+
+	mapForResponse := map[string]interface{}{"posts": result.Posts}
+	if result.NextPage != "" {
+		mapForResponse["nextPage"] = result.NextPage
+	}
+
+	resp, _ := json.Marshal(mapForResponse)
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
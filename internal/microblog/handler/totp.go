@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/totp"
+	"blog/internal/microblog/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const recoveryCodesCount = 10
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type loginTotpRequest struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Recovery codes are compared ignoring case and separators
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
+
+// Codes like "abcde-fghij" and their hashes for storage
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([][]byte, 0, recoveryCodesCount)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, 5)
+
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// EnrollTotp generates new secret, it is required on login only after ConfirmTotp
+func (h *Handler) EnrollTotp(w http.ResponseWriter, req *http.Request) {
+	enrollLogger := h.errorLogger(req, "EnrollTotp")
+	principal := auth.PrincipalFromContext(req.Context())
+	user, err := (*h.s).GetUserById(req.Context(), principal.UserId)
+
+	if enrollLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if user.Totp != nil && user.Totp.Enabled {
+		enrollLogger.WriteError(w, "totp is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if enrollLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	err = (*h.s).UpdateTotp(req.Context(), user.Id, &storage.Totp{Secret: secret})
+
+	if enrollLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	resp, _ := json.Marshal(map[string]string{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(h.totpIssuer, user.Login, secret),
+	})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// ConfirmTotp enables TOTP once user proves the app generates valid codes, recovery codes are shown only here
+func (h *Handler) ConfirmTotp(w http.ResponseWriter, req *http.Request) {
+	confirmLogger := h.errorLogger(req, "ConfirmTotp")
+	principal := auth.PrincipalFromContext(req.Context())
+
+	var body totpCodeRequest
+	if confirmLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserById(req.Context(), principal.UserId)
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if user.Totp == nil || user.Totp.Enabled {
+		confirmLogger.WriteError(w, "totp enrollment is not started", http.StatusConflict)
+		return
+	}
+
+	step, ok, err := totp.Validate(user.Totp.Secret, body.Code, time.Now())
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if !ok {
+		confirmLogger.WriteError(w, "wrong code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	err = (*h.s).UpdateTotp(req.Context(), user.Id, &storage.Totp{
+		Secret:        user.Totp.Secret,
+		Enabled:       true,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	})
+
+	if confirmLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.record(req, &audit.Entry{Actor: user.Id, Action: "totp.enable", Target: user.Id})
+
+	resp, _ := json.Marshal(map[string][]string{"recoveryCodes": codes})
+	utils.WriteJsonToResponse(w, http.StatusOK, resp)
+}
+
+// LoginTotp exchanges challenge token of Login and TOTP or recovery code for access token
+func (h *Handler) LoginTotp(w http.ResponseWriter, req *http.Request) {
+	loginLogger := h.errorLogger(req, "LoginTotp")
+
+	var body loginTotpRequest
+	if loginLogger.CheckError(readJson(req, &body), w, "can't parse body", http.StatusBadRequest) != nil {
+		return
+	}
+
+	userId, err := h.auth.VerifyChallenge(body.Challenge)
+
+	if loginLogger.CheckError(err, w, "invalid or expired challenge", http.StatusUnauthorized) != nil {
+		return
+	}
+
+	user, err := (*h.s).GetUserById(req.Context(), userId)
+
+	if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if user.Totp == nil || !user.Totp.Enabled {
+		loginLogger.WriteError(w, "totp is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if user.Suspended {
+		loginLogger.WriteError(w, auth.ErrSuspended.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Codes are guessable, so they count as login attempts
+	ip := utils.ClientIp(req)
+	wait, err := h.guard.Check(req.Context(), user.Login, ip)
+
+	if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		loginLogger.WriteError(w, "too many failed attempts, try later", http.StatusTooManyRequests)
+		return
+	}
+
+	factor := "totp"
+	if body.RecoveryCode != "" {
+		factor = "recoveryCode"
+		err = (*h.s).UseRecoveryCode(req.Context(), user.Id, hashRecoveryCode(body.RecoveryCode))
+	} else {
+		err = h.useTotpCode(req, user, body.Code)
+	}
+
+	if errors.Is(err, storage.ErrNotFound) {
+		loginLogger.CheckError(err, w, "wrong code", http.StatusBadRequest)
+		h.metrics.ObserveLogin(false)
+		// Password was right, so failure is attributed to user
+		h.auditLoginFailure(req, user.Id, user.Login, factor)
+
+		if err := h.guard.Failure(req.Context(), user.Login, ip); err != nil {
+			logging.ForRequest(h.logger, req).Error("can't record login failure", slog.String("error", err.Error()))
+		}
+		return
+	} else if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	if err := h.guard.Success(req.Context(), user.Login); err != nil {
+		logging.ForRequest(h.logger, req).Error("can't reset login attempts", slog.String("error", err.Error()))
+	}
+
+	token, _, err := h.auth.NewSession(req.Context(), user)
+
+	if loginLogger.CheckError(err, w, "something went wrong", http.StatusInternalServerError) != nil {
+		return
+	}
+
+	h.metrics.ObserveLogin(true)
+	h.auditLoginSuccess(req, user, factor)
+	response, _ := json.Marshal(map[string]string{"token": token})
+
+	utils.WriteJsonToResponse(w, http.StatusOK, response)
+}
+
+// useTotpCode returns storage.ErrNotFound for wrong and replayed codes
+func (h *Handler) useTotpCode(req *http.Request, user *storage.User, code string) error {
+	step, ok, err := totp.Validate(user.Totp.Secret, code, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	return (*h.s).UseTotpStep(req.Context(), user.Id, step)
+}
@@ -0,0 +1,455 @@
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	reloadInterval = time.Minute
+	rsaBits        = 2048
+	// HMAC secrets shorter than output of SHA-256 weaken it
+	minSecretLength = 32
+)
+
+type Policy struct {
+	// Algorithm of generated keys
+	Algorithm string
+	// Directory of key files, keys are generated in memory without it
+	Dir string
+	// How often generated keys are replaced, zero disables rotation
+	Rotation time.Duration
+	// How long new key is published before it signs, so verifiers fetch it in time
+	ActivationDelay time.Duration
+	// How long replaced generated key still verifies tokens, must not be shorter than token lifetime
+	Overlap time.Duration
+}
+
+// Key signs tokens from ActivatesAt and verifies them until ExpiresAt, zero ExpiresAt means until it is removed
+type Key struct {
+	Id          string
+	Algorithm   string
+	ActivatesAt time.Time
+	ExpiresAt   time.Time
+
+	signingKey      interface{}
+	verificationKey interface{}
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *Key) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !k.ExpiresAt.After(now)
+}
+
+// Manager keeps signing keys, loads them from directory or generates and rotates them.
+// Generated keys live only in memory of one replica, replicas sharing tokens must load keys from files.
+type Manager struct {
+	policy Policy
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+func NewManager(p Policy, logger *slog.Logger) (*Manager, error) {
+	m := &Manager{policy: p, logger: logger, now: time.Now}
+
+	if p.Dir != "" {
+		keys, err := LoadDir(p.Dir, p.ActivationDelay)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.keys = keys
+		return m, nil
+	}
+
+	// The first key signs right away, there are no tokens to verify yet
+	key, err := Generate(p.Algorithm, m.now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.keys = []*Key{key}
+	logger.Warn("signing keys are generated in memory, tokens become invalid on restart", slog.String("kid", key.Id))
+
+	return m, nil
+}
+
+// Generate returns new key of algorithm with random id
+func Generate(algorithm string, activatesAt time.Time) (*Key, error) {
+	key := &Key{Algorithm: algorithm, ActivatesAt: activatesAt.UTC()}
+
+	switch algorithm {
+	case EdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			return nil, fmt.Errorf("can't generate key - %w", err)
+		}
+
+		key.signingKey, key.verificationKey = private, public
+	case RS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaBits)
+
+		if err != nil {
+			return nil, fmt.Errorf("can't generate key - %w", err)
+		}
+
+		key.signingKey, key.verificationKey = private, &private.PublicKey
+	case HS256:
+		secret := make([]byte, minSecretLength)
+
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("can't generate key - %w", err)
+		}
+
+		key.signingKey, key.verificationKey = secret, secret
+	default:
+		return nil, fmt.Errorf("unknown signing algorithm %q", algorithm)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("can't generate key id - %w", err)
+	}
+	key.Id = base64.RawURLEncoding.EncodeToString(id)
+
+	return key, nil
+}
+
+// LoadDir loads keys from files named after their ids: PEM private keys "<kid>.pem" of EdDSA or RS256
+// and HS256 secrets "<kid>.key". Key activates activationDelay after its file was modified.
+func LoadDir(dir string, activationDelay time.Duration) ([]*Key, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't read keys directory - %w", err)
+	}
+
+	var keys []*Key
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+
+		if entry.IsDir() || (ext != ".pem" && ext != ".key") {
+			continue
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return nil, fmt.Errorf("can't read key %s - %w", entry.Name(), err)
+		}
+
+		key, err := loadFile(filepath.Join(dir, entry.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		key.Id = strings.TrimSuffix(entry.Name(), ext)
+		key.ActivatesAt = info.ModTime().Add(activationDelay).UTC()
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", dir)
+	}
+
+	return keys, nil
+}
+
+func loadFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't read key - %w", err)
+	}
+
+	if filepath.Ext(path) == ".key" {
+		secret := []byte(strings.TrimSpace(string(data)))
+
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("secret %s is shorter than %d bytes", path, minSecretLength)
+		}
+
+		return &Key{Algorithm: HS256, signingKey: secret, verificationKey: secret}, nil
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s contains %s instead of private key", path, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't parse key %s - %w", path, err)
+	}
+
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		return &Key{Algorithm: EdDSA, signingKey: private, verificationKey: private.Public()}, nil
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < rsaBits {
+			return nil, fmt.Errorf("rsa key %s is shorter than %d bits", path, rsaBits)
+		}
+
+		return &Key{Algorithm: RS256, signingKey: private, verificationKey: &private.PublicKey}, nil
+	default:
+		return nil, fmt.Errorf("%s is neither ed25519 nor rsa key", path)
+	}
+}
+
+// signingKey returns the latest active key, or the earliest one if none is active yet, so fresh deployment can sign
+func (m *Manager) signingKey(now time.Time) *Key {
+	var current, earliest *Key
+
+	for _, key := range m.keys {
+		if key.expired(now) {
+			continue
+		}
+
+		if !key.ActivatesAt.After(now) && (current == nil || key.ActivatesAt.After(current.ActivatesAt)) {
+			current = key
+		}
+
+		if earliest == nil || key.ActivatesAt.Before(earliest.ActivatesAt) {
+			earliest = key
+		}
+	}
+
+	if current == nil {
+		return earliest
+	}
+
+	return current
+}
+
+// Sign signs claims with current key, id of key is set in kid header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.signingKey(m.now())
+	m.mu.RUnlock()
+
+	if key == nil {
+		return "", errors.New("no signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.Id
+
+	signed, err := token.SignedString(key.signingKey)
+
+	if err != nil {
+		return "", fmt.Errorf("can't sign token - %w", err)
+	}
+
+	return signed, nil
+}
+
+// Keyfunc returns verification key of token for jwt.Parse, algorithm of token must match its key
+func (m *Manager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	for _, key := range m.keys {
+		if key.Id != kid || key.expired(now) {
+			continue
+		}
+
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token of key %s is signed with %v", kid, t.Header["alg"])
+		}
+
+		return key.verificationKey, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Keys returns keys which verify tokens now
+func (m *Manager) Keys() []Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	keys := make([]Key, 0, len(m.keys))
+	for _, key := range m.keys {
+		if !key.expired(now) {
+			keys = append(keys, *key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+
+	return keys
+}
+
+// JWK is public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public keys which verify tokens, HS256 secrets are never published
+func (m *Manager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range m.Keys() {
+		jwk := JWK{Use: "sig", Kid: key.Id, Alg: key.Algorithm}
+
+		switch public := key.verificationKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// Run reloads key files or rotates generated keys until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	if m.policy.Dir == "" && m.policy.Rotation <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.refresh()
+		}
+	}
+}
+
+func (m *Manager) refresh() {
+	if m.policy.Dir == "" {
+		if err := m.rotate(); err != nil {
+			m.logger.Error("can't rotate signing key", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	// Broken or emptied directory must not leave replica without keys
+	keys, err := LoadDir(m.policy.Dir, m.policy.ActivationDelay)
+
+	if err != nil {
+		m.logger.Error("can't reload signing keys, previous ones are kept", slog.String("error", err.Error()))
+		return
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+}
+
+// rotate publishes successor of the latest key ActivationDelay before it is due,
+// replaced key expires Overlap after successor activates
+func (m *Manager) rotate() error {
+	now := m.now()
+
+	m.mu.RLock()
+	latest := m.keys[0]
+	for _, key := range m.keys {
+		if key.ActivatesAt.After(latest.ActivatesAt) {
+			latest = key
+		}
+	}
+	m.mu.RUnlock()
+
+	activatesAt := latest.ActivatesAt.Add(m.policy.Rotation)
+	if activatesAt.After(now.Add(m.policy.ActivationDelay)) {
+		return nil
+	}
+
+	if earliest := now.Add(m.policy.ActivationDelay); activatesAt.Before(earliest) {
+		activatesAt = earliest
+	}
+
+	key, err := Generate(m.policy.Algorithm, activatesAt)
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest.ExpiresAt = key.ActivatesAt.Add(m.policy.Overlap)
+	keys := []*Key{key}
+	for _, k := range m.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	m.keys = keys
+
+	m.logger.Info("signing key rotated", slog.String("kid", key.Id), slog.Time("activatesAt", key.ActivatesAt))
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func parse(m *Manager, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, m.Keyfunc)
+	return err
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{EdDSA, RS256, HS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			m, err := NewManager(Policy{Algorithm: algorithm}, discard)
+			require.NoError(t, err)
+
+			token, err := m.Sign(jwt.StandardClaims{Subject: "user"})
+			require.NoError(t, err)
+			require.NoError(t, parse(m, token))
+
+			other, err := NewManager(Policy{Algorithm: algorithm}, discard)
+			require.NoError(t, err)
+			require.Error(t, parse(other, token), "key of other manager must not verify token")
+		})
+	}
+}
+
+func TestAlgorithmOfKeyIsEnforced(t *testing.T) {
+	m, err := NewManager(Policy{Algorithm: EdDSA}, discard)
+	require.NoError(t, err)
+	kid := m.Keys()[0].Id
+
+	// Public key is known to everyone, it must not be accepted as HMAC secret
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "user"})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString([]byte(m.keys[0].verificationKey.(ed25519.PublicKey)))
+	require.NoError(t, err)
+
+	require.Error(t, parse(m, signed))
+}
+
+func TestRotation(t *testing.T) {
+	now := time.Now()
+	m, err := NewManager(Policy{Algorithm: EdDSA, Rotation: time.Hour, ActivationDelay: 5 * time.Minute,
+		Overlap: 30 * time.Minute}, discard)
+	require.NoError(t, err)
+	m.now = func() time.Time { return now }
+
+	first := m.Keys()[0].Id
+	oldToken, err := m.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+
+	now = now.Add(50 * time.Minute)
+	require.NoError(t, m.rotate())
+	require.Len(t, m.Keys(), 1, "successor is not due yet")
+
+	now = now.Add(6 * time.Minute)
+	require.NoError(t, m.rotate())
+	require.Len(t, m.Keys(), 2)
+	require.Len(t, m.JWKS().Keys, 2, "successor is published before it signs")
+
+	token, err := m.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+	require.Equal(t, first, kidOf(t, token))
+
+	now = now.Add(5 * time.Minute)
+	token, err = m.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+	require.NotEqual(t, first, kidOf(t, token))
+	require.NoError(t, parse(m, oldToken), "replaced key verifies during overlap")
+
+	now = now.Add(30 * time.Minute)
+	require.Error(t, parse(m, oldToken))
+	require.Len(t, m.Keys(), 1)
+}
+
+func kidOf(t *testing.T, token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.StandardClaims{})
+	require.NoError(t, err)
+
+	return parsed.Header["kid"].(string)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.key"), []byte(strings.Repeat("s", 32)+"\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0600))
+
+	now := time.Now()
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "old.pem"), now.Add(-time.Hour), now.Add(-time.Hour)))
+
+	m, err := NewManager(Policy{Dir: dir, ActivationDelay: 5 * time.Minute}, discard)
+	require.NoError(t, err)
+	require.Len(t, m.Keys(), 2)
+
+	jwks := m.JWKS()
+	require.Len(t, jwks.Keys, 1, "secrets are not published")
+	require.Equal(t, JWK{Kty: "OKP", Use: "sig", Kid: "old", Alg: EdDSA, Crv: "Ed25519", X: jwks.Keys[0].X}, jwks.Keys[0])
+
+	token, err := m.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "old", kidOf(t, token), "new key is not active yet")
+
+	m.now = func() time.Time { return now.Add(6 * time.Minute) }
+	token, err = m.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "new", kidOf(t, token))
+}
+
+func TestLoadDirRejectsWeakSecret(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "short.key"), []byte("secret"), 0600))
+
+	_, err := LoadDir(dir, 0)
+	require.Error(t, err)
+}
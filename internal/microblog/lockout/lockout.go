@@ -0,0 +1,188 @@
+package lockout
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/storage"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	// Failures which are not delayed at all
+	FreeAttempts int
+	// Delay after the first delayed failure, every next failure doubles it
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures after which key is locked out for LockoutDuration
+	Threshold       int
+	LockoutDuration time.Duration
+	// Failures older than Window are forgotten
+	Window time.Duration
+}
+
+var (
+	DefaultLoginPolicy = Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		Threshold:       10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+
+	// Single ip tries many logins when credentials are stuffed, so it gets more attempts
+	DefaultIpPolicy = Policy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		Threshold:       100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+// How long key is blocked after failures and whether it is a lockout
+func (p Policy) block(failures int) (time.Duration, bool) {
+	if failures >= p.Threshold {
+		return p.LockoutDuration, true
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay, false
+}
+
+// Guard tracks failed logins per requested login and per client ip.
+// Logins are tracked whether they exist or not, so responses don't reveal existing ones.
+type Guard struct {
+	s           *storage.Storage
+	audit       audit.Recorder
+	loginPolicy Policy
+	ipPolicy    Policy
+	now         func() time.Time
+}
+
+func NewGuard(s *storage.Storage, a audit.Recorder, loginPolicy, ipPolicy Policy) *Guard {
+	return &Guard{s: s, audit: a, loginPolicy: loginPolicy, ipPolicy: ipPolicy, now: time.Now}
+}
+
+func LoginKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+func IpKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long caller must wait before attempt is allowed
+func (g *Guard) Check(ctx context.Context, login, ip string) (time.Duration, error) {
+	now := g.now()
+	var wait time.Duration
+
+	for _, key := range []string{LoginKey(login), IpKey(ip)} {
+		attempts, err := (*g.s).GetLoginAttempts(ctx, key)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if blocked := attempts.BlockedUntil.Sub(now); blocked > wait {
+			wait = blocked
+		}
+	}
+
+	return wait, nil
+}
+
+func (g *Guard) Failure(ctx context.Context, login, ip string) error {
+	if err := g.fail(ctx, LoginKey(login), g.loginPolicy, ip); err != nil {
+		return err
+	}
+
+	return g.fail(ctx, IpKey(ip), g.ipPolicy, ip)
+}
+
+func (g *Guard) fail(ctx context.Context, key string, p Policy, ip string) error {
+	now := g.now()
+	attempts, err := (*g.s).GetLoginAttempts(ctx, key)
+
+	if err != nil {
+		return err
+	}
+
+	if attempts.Failures != 0 && now.Sub(attempts.LastFailureAt) > p.Window {
+		if err := (*g.s).ResetLoginAttempts(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	attempts, err = (*g.s).AddLoginFailure(ctx, key, now)
+
+	if err != nil {
+		return err
+	}
+
+	delay, lockedOut := p.block(attempts.Failures)
+
+	if delay == 0 {
+		return nil
+	}
+
+	until := now.Add(delay)
+	if err := (*g.s).BlockLogin(ctx, key, until, lockedOut); err != nil {
+		return err
+	}
+
+	if !lockedOut {
+		return nil
+	}
+
+	return g.audit.Record(ctx, &audit.Entry{
+		Time:   now.UTC(),
+		Actor:  "anonymous",
+		Action: "login.lockout",
+		Target: key,
+		Ip:     ip,
+		Details: map[string]string{
+			"failures":    strconv.Itoa(attempts.Failures),
+			"lockedUntil": until.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// Success forgets failures of login, failures of ip are kept to catch credential stuffing
+func (g *Guard) Success(ctx context.Context, login string) error {
+	return (*g.s).ResetLoginAttempts(ctx, LoginKey(login))
+}
+
+func (g *Guard) Lockouts(ctx context.Context) ([]storage.LoginAttempts, error) {
+	return (*g.s).GetLockouts(ctx, g.now())
+}
+
+// Unlock lifts lockout of key by request of admin
+func (g *Guard) Unlock(ctx context.Context, key, actor, ip string) error {
+	if err := (*g.s).ResetLoginAttempts(ctx, key); err != nil {
+		return err
+	}
+
+	return g.audit.Record(ctx, &audit.Entry{
+		Time:   g.now().UTC(),
+		Actor:  actor,
+		Action: "login.unlock",
+		Target: key,
+		Ip:     ip,
+	})
+}
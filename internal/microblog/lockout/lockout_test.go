@@ -0,0 +1,60 @@
+package lockout
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/storage/mapstorage"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyBlock(t *testing.T) {
+	p := Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Threshold: 6, LockoutDuration: time.Hour}
+
+	for failures, want := range []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second} {
+		delay, lockedOut := p.block(failures)
+		require.Equal(t, want, delay, "failures: %d", failures)
+		require.False(t, lockedOut)
+	}
+
+	delay, lockedOut := p.block(6)
+	require.Equal(t, time.Hour, delay)
+	require.True(t, lockedOut)
+}
+
+func TestGuardLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := mapstorage.NewMapStorage()
+	p := Policy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Second, Threshold: 3, LockoutDuration: time.Hour, Window: 24 * time.Hour}
+	g := NewGuard(&s, audit.NewLog(audit.NewMemoryStore()), p, DefaultIpPolicy)
+	g.now = func() time.Time { return now }
+
+	require.NoError(t, g.Failure(ctx, "Victim", "10.0.0.1"))
+	wait, err := g.Check(ctx, "victim", "10.0.0.2")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	require.NoError(t, g.Failure(ctx, "victim", "10.0.0.1"))
+	wait, err = g.Check(ctx, "victim", "10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, time.Second, wait)
+
+	now = now.Add(time.Second)
+	require.NoError(t, g.Failure(ctx, "victim", "10.0.0.1"))
+	wait, err = g.Check(ctx, "victim", "10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, wait)
+
+	lockouts, err := g.Lockouts(ctx)
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+	require.Equal(t, LoginKey("victim"), lockouts[0].Key)
+
+	require.NoError(t, g.Unlock(ctx, LoginKey("victim"), "admin", "10.0.0.3"))
+	wait, err = g.Check(ctx, "victim", "10.0.0.2")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"blog/internal/microblog/utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TextFormat = "text"
+	JsonFormat = "json"
+
+	RequestIdHeader = "X-Request-Id"
+)
+
+type requestIdKey struct{}
+
+// Incoming request ids are accepted only if they can't break log lines
+var requestIdRegex = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,128}$`)
+
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("bad log level %q - %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case TextFormat:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case JsonFormat:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+
+	return id
+}
+
+// ForRequest returns logger with request correlation attributes
+func ForRequest(logger *slog.Logger, req *http.Request) *slog.Logger {
+	logger = logger.With(slog.String("requestId", RequestId(req.Context())))
+
+	if span := trace.SpanContextFromContext(req.Context()); span.IsValid() {
+		logger = logger.With(slog.String("traceId", span.TraceID().String()))
+	}
+
+	return logger
+}
+
+// Middleware propagates X-Request-Id or generates a new one and writes access log
+func Middleware(logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+
+			id := req.Header.Get(RequestIdHeader)
+			if !requestIdRegex.MatchString(id) {
+				id = newRequestId()
+			}
+
+			w.Header().Set(RequestIdHeader, id)
+			trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("http.request_id", id))
+			req = req.WithContext(context.WithValue(req.Context(), requestIdKey{}, id))
+
+			recorder := utils.NewStatusRecorder(w)
+			next.ServeHTTP(recorder, req)
+
+			route := ""
+			if r := mux.CurrentRoute(req); r != nil {
+				route = r.GetName()
+			}
+
+			ForRequest(logger, req).Info("request",
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("route", route),
+				slog.Int("status", recorder.Status),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("clientIp", utils.ClientIp(req)),
+			)
+		})
+	}
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,220 @@
+package markdown
+
+import (
+	"blog/internal/microblog/composer"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Blockquotes deeper than this are shown as text
+const maxQuoteDepth = 3
+
+// Only these schemes become links, so javascript: and data: URLs are never rendered
+var allowedSchemes = []string{"http://", "https://", "mailto:"}
+
+var (
+	autolinkRegex  = regexp.MustCompile(`(?i)^https?://[^\s<>"]+`)
+	orderedRegex   = regexp.MustCompile(`^\d{1,9}[.)] `)
+	unorderedRegex = regexp.MustCompile(`^[-*+] `)
+)
+
+// ToHtml renders restricted Markdown: paragraphs, line breaks, **strong**, *emphasis*, `code`, fenced code blocks,
+// blockquotes, lists, [links](url) and bare URLs. Anything else, HTML included, is escaped and shown as text.
+// Produced tags are p, br, strong, em, code, pre, blockquote, ul, ol, li and a with href and rel only
+func ToHtml(source string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(source, "\n"), 0)
+
+	return b.String()
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case isFence(line):
+			// Info string after ``` is ignored, unclosed block lasts till the end
+			end := i + 1
+			for end < len(lines) && !isFence(lines[end]) {
+				end++
+			}
+
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+			b.WriteString("</code></pre>\n")
+			i = min(end+1, len(lines))
+		case strings.HasPrefix(line, ">") && depth < maxQuoteDepth:
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				quoted = append(quoted, strings.TrimPrefix(lines[i][1:], " "))
+			}
+
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, depth+1)
+			b.WriteString("</blockquote>\n")
+		case unorderedRegex.MatchString(line):
+			i = renderList(b, lines, i, "ul", unorderedRegex)
+		case orderedRegex.MatchString(line):
+			i = renderList(b, lines, i, "ol", orderedRegex)
+		default:
+			var paragraph []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(paragraph) == 0 || !startsBlock(lines[i])); i++ {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+			}
+
+			b.WriteString("<p>")
+			for j, text := range paragraph {
+				if j != 0 {
+					b.WriteString("<br>\n")
+				}
+				renderInline(b, text, true)
+			}
+			b.WriteString("</p>\n")
+		}
+	}
+}
+
+func startsBlock(line string) bool {
+	return isFence(line) || strings.HasPrefix(line, ">") || unorderedRegex.MatchString(line) ||
+		orderedRegex.MatchString(line)
+}
+
+// renderList writes consecutive items of one kind, returns index of the first line after them
+func renderList(b *strings.Builder, lines []string, i int, tag string, marker *regexp.Regexp) int {
+	b.WriteString("<" + tag + ">\n")
+	for ; i < len(lines) && marker.MatchString(lines[i]); i++ {
+		b.WriteString("<li>")
+		renderInline(b, strings.TrimSpace(lines[i][len(marker.FindString(lines[i])):]), true)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+
+	return i
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func allowedUrl(url string) bool {
+	for _, scheme := range allowedSchemes {
+		if len(url) > len(scheme) && strings.EqualFold(url[:len(scheme)], scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeLink(b *strings.Builder, url string, render func()) {
+	b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+	render()
+	b.WriteString("</a>")
+}
+
+// closing finds delimiter which closes span started before text, it must follow non-space
+func closing(text, delimiter string) int {
+	for i := 1; i+len(delimiter) <= len(text); i++ {
+		if text[i] == '\\' {
+			i++
+		} else if strings.HasPrefix(text[i:], delimiter) && text[i-1] != ' ' {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// renderInline writes text with its spans, links are not nested into links
+func renderInline(b *strings.Builder, text string, links bool) {
+	prev := ' '
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte("\\`*_[]()#>-+.!", rest[1]) >= 0:
+			b.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			prev = rune(rest[1])
+			continue
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:end+1]) + "</code>")
+				i += end + 2
+				prev = '`'
+				continue
+			}
+		case links && !isWordRune(prev) && autolinkRegex.MatchString(rest):
+			url := composer.TrimUrl(autolinkRegex.FindString(rest))
+			if allowedUrl(url) {
+				writeLink(b, url, func() { b.WriteString(html.EscapeString(url)) })
+				i += len(url)
+				prev = '/'
+				continue
+			}
+		case links && rest[0] == '[':
+			if label, url, n := parseLink(rest); n > 0 {
+				writeLink(b, url, func() { renderInline(b, label, false) })
+				i += n
+				prev = ')'
+				continue
+			}
+		case strings.HasPrefix(rest, "**") && len(rest) > 2 && rest[2] != ' ':
+			if end := closing(rest[2:], "**"); end > 0 {
+				b.WriteString("<strong>")
+				renderInline(b, rest[2:end+2], links)
+				b.WriteString("</strong>")
+				i += end + 4
+				prev = '*'
+				continue
+			}
+		case (rest[0] == '*' || rest[0] == '_' && !isWordRune(prev)) && len(rest) > 1 && rest[1] != ' ':
+			if end := closing(rest[1:], rest[:1]); end > 0 {
+				after, _ := utf8.DecodeRuneInString(rest[end+2:])
+				if rest[0] == '*' || !isWordRune(after) {
+					b.WriteString("<em>")
+					renderInline(b, rest[1:end+1], links)
+					b.WriteString("</em>")
+					i += end + 2
+					prev = '*'
+					continue
+				}
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(html.EscapeString(rest[:size]))
+		i += size
+		prev = r
+	}
+}
+
+// parseLink reads [label](url) at the beginning of text, n is its length or 0 if it isn't allowed link
+func parseLink(text string) (label string, url string, n int) {
+	labelEnd := strings.Index(text, "](")
+	if labelEnd < 0 || strings.ContainsAny(text[1:labelEnd], "[]") {
+		return "", "", 0
+	}
+
+	urlEnd := strings.IndexByte(text[labelEnd:], ')')
+	if urlEnd < 0 {
+		return "", "", 0
+	}
+
+	url = text[labelEnd+2 : labelEnd+urlEnd]
+	if strings.ContainsAny(url, " \t<>\"") || !allowedUrl(url) || labelEnd == 1 {
+		return "", "", 0
+	}
+
+	return text[1:labelEnd], url, labelEnd + urlEnd + 1
+}
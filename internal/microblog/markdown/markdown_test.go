@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"blog/internal/microblog/composer"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const rel = `rel="nofollow noopener noreferrer" target="_blank"`
+
+func TestToHtml(t *testing.T) {
+	for source, expected := range map[string]string{
+		"plain text":          "<p>plain text</p>\n",
+		"two\nlines\n\nnext":  "<p>two<br>\nlines</p>\n<p>next</p>\n",
+		"**bold** and *it*":   "<p><strong>bold</strong> and <em>it</em></p>\n",
+		"_it_ snake_case_var": "<p><em>it</em> snake_case_var</p>\n",
+		"`a <b> *c*`":         "<p><code>a &lt;b&gt; *c*</code></p>\n",
+		"\\*not em\\*":        "<p>*not em*</p>\n",
+		"2 * 3 * 4":           "<p>2 * 3 * 4</p>\n",
+		"[site](https://example.com/a?b=1&c=2)": `<p><a href="https://example.com/a?b=1&amp;c=2" ` + rel +
+			`>site</a></p>` + "\n",
+		"see https://example.com/a_b_c.": `<p>see <a href="https://example.com/a_b_c" ` + rel +
+			`>https://example.com/a_b_c</a>.</p>` + "\n",
+		"- one\n- **two**\n\n1. first\n2) second": "<ul>\n<li>one</li>\n<li><strong>two</strong></li>\n</ul>\n" +
+			"<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n",
+		"> quoted\n> > nested\n\nafter": "<blockquote>\n<p>quoted</p>\n<blockquote>\n<p>nested</p>\n" +
+			"</blockquote>\n</blockquote>\n<p>after</p>\n",
+		"```go\nif a < b {\n\n}\n```\ntext": "<pre><code>if a &lt; b {\n\n}</code></pre>\n<p>text</p>\n",
+		"text\n- item":                      "<p>text</p>\n<ul>\n<li>item</li>\n</ul>\n",
+		"#hashtag is not heading":           "<p>#hashtag is not heading</p>\n",
+	} {
+		require.Equal(t, expected, ToHtml(source), "%q", source)
+	}
+}
+
+func TestToHtmlEscapesEverythingElse(t *testing.T) {
+	for source, expected := range map[string]string{
+		`<script>alert(1)</script>`:      "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		`<img src=x onerror="alert(1)">`: "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n",
+		"[x](javascript:alert(1))":       "<p>[x](javascript:alert(1))</p>\n",
+		"[x](data:text/html,hi)":         "<p>[x](data:text/html,hi)</p>\n",
+		`[x](https://a.io/"onclick="x)`: "<p>[x](<a href=\"https://a.io/\" " + rel +
+			">https://a.io/</a>&#34;onclick=&#34;x)</p>\n",
+		"[[x](https://a.io)](https://b.io)": "<p>[<a href=\"https://a.io\" " + rel + ">x</a>](<a href=\"https://b.io\" " + rel +
+			">https://b.io</a>)</p>\n",
+		"> > > > deep": "<blockquote>\n<blockquote>\n<blockquote>\n<p>&gt; deep</p>\n" +
+			"</blockquote>\n</blockquote>\n</blockquote>\n",
+	} {
+		require.Equal(t, expected, ToHtml(source), "%q", source)
+	}
+}
+
+func TestAutolinksMatchUrlEntities(t *testing.T) {
+	for _, source := range []string{"see https://a.io/x_y_.", "**https://a.io/b**", "(https://a.io/c), https://a.io/d(e)!?"} {
+		var urls []string
+		for _, entity := range composer.Entities(source) {
+			urls = append(urls, entity.Value)
+		}
+
+		rendered := ToHtml(source)
+		require.Equal(t, len(urls), strings.Count(rendered, "<a "), source)
+		for _, url := range urls {
+			require.Contains(t, rendered, `href="`+url+`"`, source)
+		}
+	}
+}
+
+func TestRendererCache(t *testing.T) {
+	renderer := NewRenderer(2)
+	renders := 0
+	renderer.render = func(source string) string {
+		renders++
+		return ToHtml(source)
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "<p><em>a</em></p>\n", renderer.Render("*a*"))
+	}
+	require.Equal(t, 1, renders)
+
+	for i := 0; i < 3; i++ {
+		renderer.Render(strconv.Itoa(i))
+	}
+	require.Equal(t, 2, renderer.Len())
+
+	renderer.Render("*a*")
+	require.Equal(t, 5, renders, "the least recently used rendering is evicted")
+}
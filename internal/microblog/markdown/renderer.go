@@ -0,0 +1,67 @@
+package markdown
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// Renderer keeps HTML of recently rendered sources, so pages of posts aren't rendered on every request
+type Renderer struct {
+	mu       sync.Mutex
+	capacity int
+	// Front is the most recently used
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+	render  func(string) string
+}
+
+type cacheEntry struct {
+	key  [sha256.Size]byte
+	html string
+}
+
+// NewRenderer caches at most capacity renderings, zero capacity disables cache
+func NewRenderer(capacity int) *Renderer {
+	return &Renderer{capacity: capacity, order: list.New(), entries: make(map[[sha256.Size]byte]*list.Element),
+		render: ToHtml}
+}
+
+func (r *Renderer) Render(source string) string {
+	key := sha256.Sum256([]byte(source))
+
+	r.mu.Lock()
+	if element, ok := r.entries[key]; ok {
+		r.order.MoveToFront(element)
+		r.mu.Unlock()
+		return element.Value.(*cacheEntry).html
+	}
+	r.mu.Unlock()
+
+	rendered := r.render(source)
+	if r.capacity <= 0 {
+		return rendered
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Another request may have rendered the same source meanwhile
+	if _, ok := r.entries[key]; !ok {
+		r.entries[key] = r.order.PushFront(&cacheEntry{key: key, html: rendered})
+		if r.order.Len() > r.capacity {
+			oldest := r.order.Remove(r.order.Back()).(*cacheEntry)
+			delete(r.entries, oldest.key)
+		}
+	}
+
+	return rendered
+}
+
+// Len is number of cached renderings
+func (r *Renderer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.order.Len()
+}
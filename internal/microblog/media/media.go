@@ -0,0 +1,272 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// Accepted content types, others are rejected whatever client claims
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	GIF  = "image/gif"
+)
+
+var (
+	ErrTooLarge      = errors.New("file is too large")
+	ErrUnsupported   = errors.New("only jpeg, png and gif images are supported")
+	ErrTooManyPix    = errors.New("image has too many pixels")
+	ErrTooManyFrames = errors.New("animation has too many frames")
+
+	errMalformedGif = errors.New("malformed gif")
+)
+
+type Limits struct {
+	// Size of uploaded file in bytes
+	MaxSize int64
+	// Width times height, checked before image is decoded. Every frame of animation counts
+	MaxPixels int
+	// Frames of animated GIF
+	MaxFrames int
+	// Thumbnail fits into square with this side
+	ThumbnailSize int
+}
+
+var DefaultLimits = Limits{MaxSize: 5 << 20, MaxPixels: 40_000_000, MaxFrames: 100, ThumbnailSize: 320}
+
+// Image is uploaded file decoded and encoded again, so metadata like EXIF location doesn't survive
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+	// Always JPEG or PNG, animation of GIF is not kept
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Process checks uploaded image against limits, strips its metadata and makes thumbnail
+func Process(r io.Reader, limits Limits) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxSize+1))
+
+	if err != nil {
+		return nil, fmt.Errorf("can't read image - %w", err)
+	}
+
+	if int64(len(data)) > limits.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if contentType != JPEG && contentType != PNG && contentType != GIF {
+		return nil, ErrUnsupported
+	}
+
+	// Small file may declare huge dimensions, so they are checked before decoding
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, fmt.Errorf("%w - %v", ErrUnsupported, err)
+	}
+
+	if config.Width*config.Height > limits.MaxPixels {
+		return nil, ErrTooManyPix
+	}
+
+	// Every frame is decoded into its own image, so highly compressed frames could take gigabytes.
+	// Frames fit into screen of config, otherwise decoding fails
+	if contentType == GIF {
+		frames, err := gifFrames(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w - %v", ErrUnsupported, err)
+		}
+
+		if frames > limits.MaxFrames {
+			return nil, ErrTooManyFrames
+		}
+
+		if frames*config.Width*config.Height > limits.MaxPixels {
+			return nil, ErrTooManyPix
+		}
+	}
+
+	img := &Image{ContentType: contentType}
+	var first image.Image
+	switch contentType {
+	case JPEG:
+		first, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			first = orient(first, jpegOrientation(data))
+			img.Data, err = encodeJpeg(first)
+		}
+	case PNG:
+		first, err = png.Decode(bytes.NewReader(data))
+		if err == nil {
+			img.Data, err = encodePng(first)
+		}
+	case GIF:
+		var g *gif.GIF
+		g, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil {
+			first = g.Image[0]
+			img.Data, err = encodeGif(g)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w - %v", ErrUnsupported, err)
+	}
+
+	bounds := first.Bounds()
+	img.Width, img.Height = bounds.Dx(), bounds.Dy()
+
+	thumbnail := resize(first, limits.ThumbnailSize)
+	if contentType == JPEG {
+		img.ThumbnailContentType = JPEG
+		img.Thumbnail, err = encodeJpeg(thumbnail)
+	} else {
+		img.ThumbnailContentType = PNG
+		img.Thumbnail, err = encodePng(thumbnail)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't encode thumbnail - %w", err)
+	}
+
+	return img, nil
+}
+
+func encodeJpeg(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 90})
+
+	return b.Bytes(), err
+}
+
+func encodePng(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+
+	return b.Bytes(), err
+}
+
+// gifFrames counts image descriptors of GIF walking its blocks, image data isn't decompressed
+func gifFrames(data []byte) (int, error) {
+	// Header and logical screen descriptor with optional global color table
+	if len(data) < 13 {
+		return 0, errMalformedGif
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	// Data sub-blocks end with zero length
+	skipSubBlocks := func() error {
+		for {
+			if i >= len(data) {
+				return errMalformedGif
+			}
+
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames := 0
+	for {
+		if i >= len(data) {
+			return 0, errMalformedGif
+		}
+
+		switch data[i] {
+		case 0x21:
+			// Extension introducer and label
+			i += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2c:
+			if i+10 > len(data) {
+				return 0, errMalformedGif
+			}
+
+			frames++
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+
+			// LZW minimum code size precedes image data
+			i++
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x3b:
+			return frames, nil
+		default:
+			return 0, errMalformedGif
+		}
+	}
+}
+
+// encodeGif keeps frames and timing, comments and application extensions other than looping are dropped
+func encodeGif(g *gif.GIF) ([]byte, error) {
+	var b bytes.Buffer
+	err := gif.EncodeAll(&b, g)
+
+	return b.Bytes(), err
+}
+
+// resize scales image down to fit into size x size averaging source pixels, smaller images are kept as is
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if w <= size && h <= size {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		return dst
+	}
+
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
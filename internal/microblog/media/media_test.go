@@ -0,0 +1,181 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+
+	return img
+}
+
+// jpegWithExif inserts EXIF segment with orientation and camera model right after SOI marker
+func jpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	var b bytes.Buffer
+	require.NoError(t, jpeg.Encode(&b, img, nil))
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	for _, entry := range [][3]uint32{{orientationTag, 3, uint32(orientation)}, {0x0110, 2, 0}} {
+		tiff = binary.LittleEndian.AppendUint16(tiff, uint16(entry[0]))
+		tiff = binary.LittleEndian.AppendUint16(tiff, uint16(entry[1]))
+		tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+		tiff = binary.LittleEndian.AppendUint32(tiff, entry[2])
+	}
+	tiff = append(binary.LittleEndian.AppendUint32(tiff, 0), "SecretCamera"...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(segment)+2))
+
+	data := b.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+func TestJpegMetadataIsStrippedAndOrientationApplied(t *testing.T) {
+	data := jpegWithExif(t, testImage(64, 32), 6)
+	require.Equal(t, 6, jpegOrientation(data))
+
+	img, err := Process(bytes.NewReader(data), DefaultLimits)
+	require.NoError(t, err)
+	require.Equal(t, JPEG, img.ContentType)
+	require.Equal(t, []int{32, 64}, []int{img.Width, img.Height}, "rotated by 90 degrees")
+	require.NotContains(t, string(img.Data), "Exif")
+	require.NotContains(t, string(img.Data), "SecretCamera")
+
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 32, 64), decoded.Bounds())
+	require.Equal(t, 1, jpegOrientation(img.Data))
+}
+
+func TestThumbnail(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, testImage(640, 480)))
+
+	img, err := Process(&b, DefaultLimits)
+	require.NoError(t, err)
+	require.Equal(t, PNG, img.ThumbnailContentType)
+
+	thumbnail, err := png.Decode(bytes.NewReader(img.Thumbnail))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 320, 240), thumbnail.Bounds())
+}
+
+func TestAnimatedGif(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Image: []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 10, 10), palette),
+		image.NewPaletted(image.Rect(0, 0, 10, 10), palette),
+	}, Delay: []int{10, 10}}
+	var b bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&b, g))
+
+	img, err := Process(&b, DefaultLimits)
+	require.NoError(t, err)
+	require.Equal(t, GIF, img.ContentType)
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	require.Len(t, decoded.Image, 2)
+}
+
+// gifBomb repeats the same highly compressible full-screen frame, encoding every frame would take too much memory
+func gifBomb(t *testing.T, side, frames int) []byte {
+	var b bytes.Buffer
+	frame := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.Black, color.White})
+	require.NoError(t, gif.EncodeAll(&b, &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0}}))
+	data := b.Bytes()
+
+	start := 13
+	if data[10]&0x80 != 0 {
+		start += 3 << (data[10]&0x07 + 1)
+	}
+	require.Equal(t, byte(0x2c), data[start])
+	require.Equal(t, byte(0x3b), data[len(data)-1])
+
+	bomb := append([]byte{}, data[:start]...)
+	bomb = append(bomb, bytes.Repeat(data[start:len(data)-1], frames)...)
+
+	return append(bomb, 0x3b)
+}
+
+func TestGifFrameLimits(t *testing.T) {
+	frames, err := gifFrames(gifBomb(t, 10, 7))
+	require.NoError(t, err)
+	require.Equal(t, 7, frames)
+
+	_, err = Process(bytes.NewReader(gifBomb(t, 1000, 500)), DefaultLimits)
+	require.ErrorIs(t, err, ErrTooManyFrames)
+
+	_, err = Process(bytes.NewReader(gifBomb(t, 1000, 50)), DefaultLimits)
+	require.ErrorIs(t, err, ErrTooManyPix, "every frame counts against pixel limit")
+
+	img, err := Process(bytes.NewReader(gifBomb(t, 100, 50)), DefaultLimits)
+	require.NoError(t, err)
+	require.Equal(t, GIF, img.ContentType)
+
+	_, err = Process(bytes.NewReader(gifBomb(t, 10, 2)[:40]), DefaultLimits)
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+// hugePng declares dimensions of image it doesn't contain
+func hugePng(t *testing.T) []byte {
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, testImage(1, 1)))
+	data := b.Bytes()
+
+	// Signature, length and type of IHDR are followed by width and height
+	binary.BigEndian.PutUint32(data[16:], 100_000)
+	binary.BigEndian.PutUint32(data[20:], 100_000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	return data
+}
+
+func TestRejected(t *testing.T) {
+	var small bytes.Buffer
+	require.NoError(t, png.Encode(&small, testImage(10, 10)))
+
+	for name, c := range map[string]struct {
+		data   []byte
+		limits Limits
+		err    error
+	}{
+		"tooLarge":   {small.Bytes(), Limits{MaxSize: 10, MaxPixels: 100, ThumbnailSize: 10}, ErrTooLarge},
+		"notImage":   {[]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), DefaultLimits, ErrUnsupported},
+		"truncated":  {small.Bytes()[:40], DefaultLimits, ErrUnsupported},
+		"tooManyPix": {hugePng(t), DefaultLimits, ErrTooManyPix},
+	} {
+		_, err := Process(strings.NewReader(string(c.data)), c.limits)
+		require.ErrorIs(t, err, c.err, name)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := testImage(3, 2)
+
+	for o, expected := range map[int]image.Point{
+		// Where pixel (0, 0) of stored image ends up
+		2: {2, 0}, 3: {2, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 2}, 8: {0, 2},
+	} {
+		dst := orient(src, o).(*image.RGBA)
+		require.Equal(t, src.RGBAAt(0, 0), dst.RGBAAt(expected.X, expected.Y), "orientation %d", o)
+	}
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"blog/internal/microblog/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "microblog"
+
+// Metrics of one server, every collector is registered on its own registry,
+// so several servers can live in one process
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	logins          *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route name, method and status code.",
+		}, []string{"route", "method", "code"}),
+
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route name and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_call_duration_seconds",
+			Help:      "Storage call latency by storage method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_errors_total",
+			Help:      "Failed storage calls by storage method.",
+		}, []string{"method"}),
+
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.storageDuration,
+		m.storageErrors,
+		m.logins,
+	)
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(m.registry, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware for mux router, requests are labeled with the name of matched route.
+// Requests which matched no route are labeled as unknown, see Instrument
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := utils.NewStatusRecorder(w)
+
+		next.ServeHTTP(recorder, req)
+
+		route := "unknown"
+		if r := mux.CurrentRoute(req); r != nil && r.GetName() != "" {
+			route = r.GetName()
+		}
+
+		m.httpRequests.WithLabelValues(route, req.Method, strconv.Itoa(recorder.Status)).Inc()
+		m.httpDuration.WithLabelValues(route, req.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Mux doesn't run middlewares when no route matched, so not found and
+// method not allowed handlers of the router are measured separately
+func (m *Metrics) Instrument(r *mux.Router) {
+	r.NotFoundHandler = m.Middleware(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+}
+
+func (m *Metrics) ObserveLogin(success bool) {
+	if success {
+		m.logins.WithLabelValues("success").Inc()
+	} else {
+		m.logins.WithLabelValues("failure").Inc()
+	}
+}
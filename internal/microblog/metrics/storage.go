@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const statsTimeout = 2 * time.Second
+
+// Storage decorator which measures latency and errors of every call
+type instrumentedStorage struct {
+	s storage.Storage
+	m *Metrics
+}
+
+func (m *Metrics) NewStorage(s storage.Storage) storage.Storage {
+	return &instrumentedStorage{s: s, m: m}
+}
+
+func (i *instrumentedStorage) observe(method string, start time.Time, err *error) {
+	i.m.storageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if *err != nil {
+		i.m.storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (i *instrumentedStorage) AddPost(ctx context.Context, post *storage.Post) (err error) {
+	defer i.observe("AddPost", time.Now(), &err)
+
+	return i.s.AddPost(ctx, post)
+}
+
+func (i *instrumentedStorage) AddUser(ctx context.Context, user *storage.User) (err error) {
+	defer i.observe("AddUser", time.Now(), &err)
+
+	return i.s.AddUser(ctx, user)
+}
+
+func (i *instrumentedStorage) GetPost(ctx context.Context, postId string) (_ *storage.Post, err error) {
+	defer i.observe("GetPost", time.Now(), &err)
+
+	return i.s.GetPost(ctx, postId)
+}
+
+func (i *instrumentedStorage) GetUserByLogin(ctx context.Context, login string) (_ *storage.User, err error) {
+	defer i.observe("GetUserByLogin", time.Now(), &err)
+
+	return i.s.GetUserByLogin(ctx, login)
+}
+
+func (i *instrumentedStorage) GetUserById(ctx context.Context, id string) (_ *storage.User, err error) {
+	defer i.observe("GetUserById", time.Now(), &err)
+
+	return i.s.GetUserById(ctx, id)
+}
+
+func (i *instrumentedStorage) ListUsers(ctx context.Context, afterId string, size int) (_ []storage.User, err error) {
+	defer i.observe("ListUsers", time.Now(), &err)
+
+	return i.s.ListUsers(ctx, afterId, size)
+}
+
+func (i *instrumentedStorage) SetUserRole(ctx context.Context, userId string, role string) (err error) {
+	defer i.observe("SetUserRole", time.Now(), &err)
+
+	return i.s.SetUserRole(ctx, userId, role)
+}
+
+func (i *instrumentedStorage) SearchUsers(ctx context.Context, prefix string, afterId string, size int) (_ []storage.User, err error) {
+	defer i.observe("SearchUsers", time.Now(), &err)
+
+	return i.s.SearchUsers(ctx, prefix, afterId, size)
+}
+
+func (i *instrumentedStorage) UpdateProfile(ctx context.Context, userId string, profile *storage.Profile) (err error) {
+	defer i.observe("UpdateProfile", time.Now(), &err)
+
+	return i.s.UpdateProfile(ctx, userId, profile)
+}
+
+func (i *instrumentedStorage) SetUserSuspended(ctx context.Context, userId string, suspended bool) (err error) {
+	defer i.observe("SetUserSuspended", time.Now(), &err)
+
+	return i.s.SetUserSuspended(ctx, userId, suspended)
+}
+
+func (i *instrumentedStorage) DeletePost(ctx context.Context, postId string) (_ *storage.Post, err error) {
+	defer i.observe("DeletePost", time.Now(), &err)
+
+	return i.s.DeletePost(ctx, postId)
+}
+
+func (i *instrumentedStorage) UpdatePasswordHash(ctx context.Context, userId string, hash []byte, version int) (err error) {
+	defer i.observe("UpdatePasswordHash", time.Now(), &err)
+
+	return i.s.UpdatePasswordHash(ctx, userId, hash, version)
+}
+
+func (i *instrumentedStorage) UpdateTotp(ctx context.Context, userId string, totp *storage.Totp) (err error) {
+	defer i.observe("UpdateTotp", time.Now(), &err)
+
+	return i.s.UpdateTotp(ctx, userId, totp)
+}
+
+func (i *instrumentedStorage) UseTotpStep(ctx context.Context, userId string, step int64) (err error) {
+	defer i.observe("UseTotpStep", time.Now(), &err)
+
+	return i.s.UseTotpStep(ctx, userId, step)
+}
+
+func (i *instrumentedStorage) UseRecoveryCode(ctx context.Context, userId string, codeHash []byte) (err error) {
+	defer i.observe("UseRecoveryCode", time.Now(), &err)
+
+	return i.s.UseRecoveryCode(ctx, userId, codeHash)
+}
+
+func (i *instrumentedStorage) GetPostsFrom(ctx context.Context, postId string, userId string, size int) (_ []storage.Post, _ string, err error) {
+	defer i.observe("GetPostsFrom", time.Now(), &err)
+
+	return i.s.GetPostsFrom(ctx, postId, userId, size)
+}
+
+func (i *instrumentedStorage) GetFirstPosts(ctx context.Context, userId string, size int) (_ []storage.Post, _ string, err error) {
+	defer i.observe("GetFirstPosts", time.Now(), &err)
+
+	return i.s.GetFirstPosts(ctx, userId, size)
+}
+
+func (i *instrumentedStorage) GetLoginAttempts(ctx context.Context, key string) (_ *storage.LoginAttempts, err error) {
+	defer i.observe("GetLoginAttempts", time.Now(), &err)
+
+	return i.s.GetLoginAttempts(ctx, key)
+}
+
+func (i *instrumentedStorage) AddLoginFailure(ctx context.Context, key string, at time.Time) (_ *storage.LoginAttempts, err error) {
+	defer i.observe("AddLoginFailure", time.Now(), &err)
+
+	return i.s.AddLoginFailure(ctx, key, at)
+}
+
+func (i *instrumentedStorage) BlockLogin(ctx context.Context, key string, until time.Time, lockedOut bool) (err error) {
+	defer i.observe("BlockLogin", time.Now(), &err)
+
+	return i.s.BlockLogin(ctx, key, until, lockedOut)
+}
+
+func (i *instrumentedStorage) ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	defer i.observe("ResetLoginAttempts", time.Now(), &err)
+
+	return i.s.ResetLoginAttempts(ctx, key)
+}
+
+func (i *instrumentedStorage) GetLockouts(ctx context.Context, now time.Time) (_ []storage.LoginAttempts, err error) {
+	defer i.observe("GetLockouts", time.Now(), &err)
+
+	return i.s.GetLockouts(ctx, now)
+}
+
+func (i *instrumentedStorage) AddSession(ctx context.Context, session *storage.Session) (err error) {
+	defer i.observe("AddSession", time.Now(), &err)
+
+	return i.s.AddSession(ctx, session)
+}
+
+func (i *instrumentedStorage) GetSession(ctx context.Context, id string) (_ *storage.Session, err error) {
+	defer i.observe("GetSession", time.Now(), &err)
+
+	return i.s.GetSession(ctx, id)
+}
+
+func (i *instrumentedStorage) RevokeSessions(ctx context.Context, userId string, exceptId string) (err error) {
+	defer i.observe("RevokeSessions", time.Now(), &err)
+
+	return i.s.RevokeSessions(ctx, userId, exceptId)
+}
+
+func (i *instrumentedStorage) AddPasswordReset(ctx context.Context, reset *storage.PasswordReset) (err error) {
+	defer i.observe("AddPasswordReset", time.Now(), &err)
+
+	return i.s.AddPasswordReset(ctx, reset)
+}
+
+func (i *instrumentedStorage) GetPasswordReset(ctx context.Context, tokenHash []byte, now time.Time) (_ *storage.PasswordReset, err error) {
+	defer i.observe("GetPasswordReset", time.Now(), &err)
+
+	return i.s.GetPasswordReset(ctx, tokenHash, now)
+}
+
+func (i *instrumentedStorage) UsePasswordReset(ctx context.Context, tokenHash []byte, now time.Time) (err error) {
+	defer i.observe("UsePasswordReset", time.Now(), &err)
+
+	return i.s.UsePasswordReset(ctx, tokenHash, now)
+}
+
+func (i *instrumentedStorage) AddApiToken(ctx context.Context, token *storage.ApiToken) (err error) {
+	defer i.observe("AddApiToken", time.Now(), &err)
+
+	return i.s.AddApiToken(ctx, token)
+}
+
+func (i *instrumentedStorage) GetApiTokenByHash(ctx context.Context, tokenHash []byte) (_ *storage.ApiToken, err error) {
+	defer i.observe("GetApiTokenByHash", time.Now(), &err)
+
+	return i.s.GetApiTokenByHash(ctx, tokenHash)
+}
+
+func (i *instrumentedStorage) ListApiTokens(ctx context.Context, userId string) (_ []storage.ApiToken, err error) {
+	defer i.observe("ListApiTokens", time.Now(), &err)
+
+	return i.s.ListApiTokens(ctx, userId)
+}
+
+func (i *instrumentedStorage) DeleteApiToken(ctx context.Context, userId string, id string) (err error) {
+	defer i.observe("DeleteApiToken", time.Now(), &err)
+
+	return i.s.DeleteApiToken(ctx, userId, id)
+}
+
+func (i *instrumentedStorage) TouchApiToken(ctx context.Context, id string, at time.Time) (err error) {
+	defer i.observe("TouchApiToken", time.Now(), &err)
+
+	return i.s.TouchApiToken(ctx, id, at)
+}
+
+func (i *instrumentedStorage) AddMedia(ctx context.Context, media *storage.Media) (err error) {
+	defer i.observe("AddMedia", time.Now(), &err)
+
+	return i.s.AddMedia(ctx, media)
+}
+
+func (i *instrumentedStorage) GetMedia(ctx context.Context, id string) (_ *storage.Media, err error) {
+	defer i.observe("GetMedia", time.Now(), &err)
+
+	return i.s.GetMedia(ctx, id)
+}
+
+func (i *instrumentedStorage) AddOAuthClient(ctx context.Context, client *storage.OAuthClient) (err error) {
+	defer i.observe("AddOAuthClient", time.Now(), &err)
+
+	return i.s.AddOAuthClient(ctx, client)
+}
+
+func (i *instrumentedStorage) GetOAuthClient(ctx context.Context, id string) (_ *storage.OAuthClient, err error) {
+	defer i.observe("GetOAuthClient", time.Now(), &err)
+
+	return i.s.GetOAuthClient(ctx, id)
+}
+
+func (i *instrumentedStorage) AddOAuthCode(ctx context.Context, code *storage.OAuthCode) (err error) {
+	defer i.observe("AddOAuthCode", time.Now(), &err)
+
+	return i.s.AddOAuthCode(ctx, code)
+}
+
+func (i *instrumentedStorage) UseOAuthCode(ctx context.Context, codeHash []byte, now time.Time) (_ *storage.OAuthCode, err error) {
+	defer i.observe("UseOAuthCode", time.Now(), &err)
+
+	return i.s.UseOAuthCode(ctx, codeHash, now)
+}
+
+func (i *instrumentedStorage) Stats(ctx context.Context) (_ *storage.Stats, err error) {
+	defer i.observe("Stats", time.Now(), &err)
+
+	return i.s.Stats(ctx)
+}
+
+func (i *instrumentedStorage) Ping(ctx context.Context) (err error) {
+	defer i.observe("Ping", time.Now(), &err)
+
+	return i.s.Ping(ctx)
+}
+
+func (i *instrumentedStorage) Close(ctx context.Context) (err error) {
+	defer i.observe("Close", time.Now(), &err)
+
+	return i.s.Close(ctx)
+}
+
+// Exposes number of registered users and posts, storage is asked on every scrape
+type statsCollector struct {
+	s     storage.Storage
+	users *prometheus.Desc
+	posts *prometheus.Desc
+}
+
+func (m *Metrics) RegisterStorageStats(s storage.Storage) error {
+	return m.registry.Register(&statsCollector{
+		s:     s,
+		users: prometheus.NewDesc(namespace+"_registered_users", "Number of registered users.", nil, nil),
+		posts: prometheus.NewDesc(namespace+"_posts", "Number of published posts.", nil, nil),
+	})
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.users
+	ch <- c.posts
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	stats, err := c.s.Stats(ctx)
+
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.users, err)
+		ch <- prometheus.NewInvalidMetric(c.posts, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.users, prometheus.GaugeValue, float64(stats.Users))
+	ch <- prometheus.MustNewConstMetric(c.posts, prometheus.GaugeValue, float64(stats.Posts))
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	NoneNotifier = "none"
+	LogNotifier  = "log"
+	FileNotifier = "file"
+)
+
+// Message to a user, users have no contacts yet so it is addressed by login
+type Message struct {
+	UserId  string `json:"userId"`
+	Login   string `json:"login"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users, like password reset links
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns notifier of kind, path is used only by FileNotifier
+func New(kind string, path string, logger *slog.Logger) (Notifier, error) {
+	switch kind {
+	case NoneNotifier:
+		return &noneNotifier{logger: logger}, nil
+	case LogNotifier:
+		return &logNotifier{logger: logger}, nil
+	case FileNotifier:
+		return &fileNotifier{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+}
+
+// Drops messages, only the fact of dropping is logged since messages contain secrets
+type noneNotifier struct {
+	logger *slog.Logger
+}
+
+func (n *noneNotifier) Notify(ctx context.Context, msg Message) error {
+	n.logger.WarnContext(ctx, "notification dropped, no notifier configured",
+		slog.String("userId", msg.UserId),
+		slog.String("subject", msg.Subject))
+
+	return nil
+}
+
+// Writes messages to log, only for local development since messages contain secrets
+type logNotifier struct {
+	logger *slog.Logger
+}
+
+func (n *logNotifier) Notify(ctx context.Context, msg Message) error {
+	n.logger.InfoContext(ctx, "notification",
+		slog.String("userId", msg.UserId),
+		slog.String("login", msg.Login),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body))
+
+	return nil
+}
+
+// Appends messages to file as JSON lines
+type fileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+type fileMessage struct {
+	Time time.Time `json:"time"`
+	Message
+}
+
+func (n *fileNotifier) Notify(_ context.Context, msg Message) error {
+	line, err := json.Marshal(fileMessage{Time: time.Now().UTC(), Message: msg})
+
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("can't open notifications file - %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("can't write notification - %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,372 @@
+package oauth
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/storage"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Error codes of RFC 6749
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrInvalidScope         = "invalid_scope"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrUnsupportedResponse  = "unsupported_response_type"
+	ErrAccessDenied         = "access_denied"
+)
+
+const (
+	codeTTL = 5 * time.Minute
+	// Only S256 is accepted, plain challenge doesn't protect intercepted codes
+	PkceMethod = "S256"
+)
+
+// Error is an OAuth error response
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// Parameters of authorization request of authorization code flow
+type AuthorizationRequest struct {
+	ClientId            string
+	RedirectUri         string
+	ResponseType        string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func ParseAuthorizationRequest(v url.Values) AuthorizationRequest {
+	return AuthorizationRequest{
+		ClientId:            v.Get("client_id"),
+		RedirectUri:         v.Get("redirect_uri"),
+		ResponseType:        v.Get("response_type"),
+		Scopes:              strings.Fields(v.Get("scope")),
+		State:               v.Get("state"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+// Parameters of authorization code grant of token request
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectUri  string
+	ClientId     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// Introspection response of RFC 7662, only Active is set for inactive tokens
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// Server implements authorization code flow with PKCE.
+// Access tokens are API tokens bound to client, so auth middleware accepts them as is.
+type Server struct {
+	s        *storage.Storage
+	auth     *auth.Authenticator
+	tokenTTL time.Duration
+	now      func() time.Time
+}
+
+func NewServer(s *storage.Storage, a *auth.Authenticator, tokenTTL time.Duration) *Server {
+	return &Server{s: s, auth: a, tokenTTL: tokenTTL, now: time.Now}
+}
+
+func validRedirectUri(uri string) bool {
+	u, err := url.Parse(uri)
+
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+
+	// Plain http is allowed only for native apps listening on loopback
+	return u.Scheme == "https" || (u.Scheme == "http" && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"))
+}
+
+// RegisterClient registers client of owner, secret is returned only for confidential clients
+func (o *Server) RegisterClient(ctx context.Context, ownerId, name string, redirectUris, scopes []string,
+	confidential bool) (*storage.OAuthClient, string, error) {
+	if name == "" || len(redirectUris) == 0 || len(scopes) == 0 {
+		return nil, "", oauthError(ErrInvalidRequest, "name, redirect uris and scopes are required")
+	}
+
+	for _, uri := range redirectUris {
+		if !validRedirectUri(uri) {
+			return nil, "", oauthError(ErrInvalidRequest, "redirect uri must be absolute https uri without fragment: "+uri)
+		}
+	}
+
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			return nil, "", oauthError(ErrInvalidScope, "unknown scope "+scope)
+		}
+	}
+
+	id, err := auth.NewId()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := &storage.OAuthClient{
+		Id:           id,
+		OwnerId:      ownerId,
+		Name:         name,
+		RedirectUris: redirectUris,
+		Scopes:       scopes,
+		CreatedAt:    o.now().UTC(),
+	}
+
+	var secret string
+	if confidential {
+		if secret, client.SecretHash, err = auth.NewOpaqueToken(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := (*o.s).AddOAuthClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (o *Server) client(ctx context.Context, id string) (*storage.OAuthClient, error) {
+	client, err := (*o.s).GetOAuthClient(ctx, id)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, oauthError(ErrInvalidClient, "unknown client")
+	}
+
+	return client, err
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateClient checks client and redirect uri of request, its errors must be shown to user, not sent to redirect uri
+func (o *Server) ValidateClient(ctx context.Context, r *AuthorizationRequest) (*storage.OAuthClient, error) {
+	client, err := o.client(ctx, r.ClientId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !contains(client.RedirectUris, r.RedirectUri) {
+		return nil, oauthError(ErrInvalidRequest, "redirect uri is not registered")
+	}
+
+	return client, nil
+}
+
+// ValidateParams checks the rest of request before consent is asked, empty scope means every scope of client
+func (o *Server) ValidateParams(client *storage.OAuthClient, r *AuthorizationRequest) error {
+	if r.ResponseType != "code" {
+		return oauthError(ErrUnsupportedResponse, "only code response type is supported")
+	}
+
+	if r.CodeChallenge == "" || r.CodeChallengeMethod != PkceMethod {
+		return oauthError(ErrInvalidRequest, "PKCE with S256 challenge is required")
+	}
+
+	if len(r.Scopes) == 0 {
+		r.Scopes = client.Scopes
+	}
+
+	for _, scope := range r.Scopes {
+		if !contains(client.Scopes, scope) {
+			return oauthError(ErrInvalidScope, "scope is not allowed for client: "+scope)
+		}
+	}
+
+	return nil
+}
+
+// Authorize issues code after user consented to validated request
+func (o *Server) Authorize(ctx context.Context, userId string, r *AuthorizationRequest) (string, error) {
+	code, codeHash, err := auth.NewOpaqueToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	err = (*o.s).AddOAuthCode(ctx, &storage.OAuthCode{
+		CodeHash:      codeHash,
+		ClientId:      r.ClientId,
+		UserId:        userId,
+		RedirectUri:   r.RedirectUri,
+		Scopes:        r.Scopes,
+		CodeChallenge: r.CodeChallenge,
+		ExpiresAt:     o.now().Add(codeTTL).UTC(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// RedirectUri returns redirect uri with params added to its query
+func RedirectUri(redirectUri string, params url.Values) string {
+	u, _ := url.Parse(redirectUri)
+	query := u.Query()
+
+	for k, v := range params {
+		query[k] = v
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// authenticateClient checks secret of confidential client, public clients are identified by id only
+func (o *Server) authenticateClient(ctx context.Context, id, secret string) (*storage.OAuthClient, error) {
+	client, err := o.client(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(client.SecretHash) != 0 && subtle.ConstantTimeCompare(client.SecretHash, auth.HashOpaqueToken(secret)) != 1 {
+		return nil, oauthError(ErrInvalidClient, "wrong client secret")
+	}
+
+	return client, nil
+}
+
+func verifyPkce(challenge, verifier string) bool {
+	// RFC 7636 verifier is 43 to 128 characters long
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// Exchange redeems authorization code for access token, issued token is returned for audit
+func (o *Server) Exchange(ctx context.Context, r *TokenRequest) (*TokenResponse, *storage.ApiToken, error) {
+	if r.GrantType != "authorization_code" {
+		return nil, nil, oauthError(ErrUnsupportedGrantType, "only authorization_code grant is supported")
+	}
+
+	client, err := o.authenticateClient(ctx, r.ClientId, r.ClientSecret)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Code is used even if checks below fail, so it can't be retried with other verifier
+	code, err := (*o.s).UseOAuthCode(ctx, auth.HashOpaqueToken(r.Code), o.now())
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, oauthError(ErrInvalidGrant, "code is invalid, expired or used")
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if code.ClientId != client.Id || code.RedirectUri != r.RedirectUri {
+		return nil, nil, oauthError(ErrInvalidGrant, "code was issued to other client or redirect uri")
+	}
+
+	if !verifyPkce(code.CodeChallenge, r.CodeVerifier) {
+		return nil, nil, oauthError(ErrInvalidGrant, "code verifier doesn't match challenge")
+	}
+
+	apiToken := &storage.ApiToken{UserId: code.UserId, Name: client.Name, Scopes: code.Scopes, ClientId: client.Id}
+	token, err := o.auth.NewApiToken(ctx, apiToken, o.tokenTTL)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(o.tokenTTL.Seconds()),
+		Scope:       strings.Join(code.Scopes, " "),
+	}, apiToken, nil
+}
+
+// Introspect describes token to client it was issued to, tokens of other clients are reported inactive
+func (o *Server) Introspect(ctx context.Context, clientId, clientSecret, token string) (*Introspection, error) {
+	client, err := o.authenticateClient(ctx, clientId, clientSecret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(client.SecretHash) == 0 {
+		return nil, oauthError(ErrInvalidClient, "only confidential clients may introspect tokens")
+	}
+
+	apiToken, err := o.auth.AuthenticateApiToken(ctx, token)
+
+	if errors.Is(err, auth.ErrUnauthenticated) || err == nil && apiToken.ClientId != client.Id {
+		return &Introspection{Active: false}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	introspection := &Introspection{
+		Active:    true,
+		Scope:     strings.Join(apiToken.Scopes, " "),
+		ClientId:  apiToken.ClientId,
+		Subject:   apiToken.UserId,
+		IssuedAt:  apiToken.CreatedAt.Unix(),
+		TokenType: "Bearer",
+	}
+
+	// Token without expiration has no exp at all, zero time is not Unix zero
+	if !apiToken.ExpiresAt.IsZero() {
+		introspection.ExpiresAt = apiToken.ExpiresAt.Unix()
+	}
+
+	return introspection, nil
+}
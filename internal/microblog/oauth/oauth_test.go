@@ -0,0 +1,60 @@
+package oauth
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/storage"
+	"blog/internal/microblog/storage/mapstorage"
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Example of RFC 7636 appendix B
+func TestVerifyPkce(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	require.True(t, verifyPkce(challenge, verifier))
+	require.False(t, verifyPkce(challenge, verifier[1:]+"a"))
+	require.False(t, verifyPkce(challenge, "short"))
+}
+
+func TestValidRedirectUri(t *testing.T) {
+	require.True(t, validRedirectUri("https://app.example/callback"))
+	require.True(t, validRedirectUri("http://127.0.0.1:8080/callback"))
+	require.False(t, validRedirectUri("http://app.example/callback"))
+	require.False(t, validRedirectUri("https://app.example/callback#fragment"))
+	require.False(t, validRedirectUri("/callback"))
+}
+
+func TestRedirectUri(t *testing.T) {
+	uri := RedirectUri("https://app.example/callback?app=1", url.Values{"code": {"abc"}, "state": {"x y"}})
+	require.Equal(t, "https://app.example/callback?app=1&code=abc&state=x+y", uri)
+}
+
+func TestIntrospectTokenWithoutExpiration(t *testing.T) {
+	ctx := context.Background()
+	s := mapstorage.NewMapStorage()
+	// API tokens are opaque, signing keys are not needed
+	a := auth.NewAuthenticator(&s, nil, time.Hour)
+	o := NewServer(&s, a, 0)
+
+	client, secret, err := o.RegisterClient(ctx, "owner", "app", []string{"https://app.example/callback"},
+		[]string{auth.ScopePostsRead}, true)
+	require.NoError(t, err)
+
+	token, err := a.NewApiToken(ctx, &storage.ApiToken{UserId: "user", ClientId: client.Id, Scopes: client.Scopes}, 0)
+	require.NoError(t, err)
+
+	introspection, err := o.Introspect(ctx, client.Id, secret, token)
+	require.NoError(t, err)
+	require.True(t, introspection.Active)
+
+	raw, err := json.Marshal(introspection)
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), `"exp"`)
+}
@@ -0,0 +1,195 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Versions of encoding stored with hash
+const (
+	// bcrypt of password with global salt appended, written before hasher existed
+	LegacyVersion = 0
+	// PHC string for argon2id, modular crypt format for bcrypt
+	EncodedVersion = 1
+)
+
+// bcrypt uses only first 72 bytes of password, while passwords may be longer and every
+// cyrillic letter takes two bytes. Password is prehashed with SHA-256 to fit into the limit,
+// such hashes are marked with prefix. Unmarked bcrypt hashes of EncodedVersion are of raw
+// password, they are still verified and replaced on login.
+const bcryptSha256Prefix = "$bcrypt-sha256"
+
+// Global salt of LegacyVersion hashes
+const legacySalt = "abcdefgh12345"
+
+var ErrMismatch = errors.New("password doesn't match")
+
+type Params struct {
+	Algorithm  string
+	BcryptCost int
+	// Memory in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// OWASP recommended minimum for argon2id
+var DefaultParams = Params{
+	Algorithm:         Argon2id,
+	BcryptCost:        10,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Algorithm {
+	case Argon2id:
+		if params.Argon2Memory == 0 || params.Argon2Iterations == 0 || params.Argon2Parallelism == 0 {
+			return nil, errors.New("argon2id parameters must be positive")
+		}
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be in [%d, %d]", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", params.Algorithm)
+	}
+
+	return &Hasher{params: params}, nil
+}
+
+// Hash returns password hash of EncodedVersion with current parameters
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	if h.params.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword(prehash(password), h.params.BcryptCost)
+
+		if err != nil {
+			return nil, fmt.Errorf("can't hash password - %w", err)
+		}
+
+		return append([]byte(bcryptSha256Prefix), hash...), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("can't generate salt - %w", err)
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Argon2Iterations, p.Argon2Memory, p.Argon2Parallelism, argon2KeyLength)
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Argon2Memory, p.Argon2Iterations, p.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// Verify returns ErrMismatch for wrong password. For right one it reports whether hash must be
+// replaced, because it was made by other algorithm, with other parameters or in old version.
+func (h *Hasher) Verify(password string, hash []byte, version int) (bool, error) {
+	switch {
+	case version == LegacyVersion:
+		return true, compareBcrypt(hash, []byte(password+legacySalt))
+	case version != EncodedVersion:
+		return false, fmt.Errorf("unknown password hash version %d", version)
+	case strings.HasPrefix(string(hash), "$argon2id$"):
+		params, err := h.verifyArgon2id(password, string(hash))
+
+		return params != h.params, err
+	case strings.HasPrefix(string(hash), bcryptSha256Prefix+"$"):
+		hash = hash[len(bcryptSha256Prefix):]
+
+		if err := compareBcrypt(hash, prehash(password)); err != nil {
+			return false, err
+		}
+
+		cost, err := bcrypt.Cost(hash)
+
+		return h.params.Algorithm != Bcrypt || cost != h.params.BcryptCost, err
+	default:
+		return true, compareBcrypt(hash, []byte(password))
+	}
+}
+
+// Base64 so digest has no zero bytes, 44 bytes of it fit into bcrypt limit
+func prehash(password string) []byte {
+	digest := sha256.Sum256([]byte(password))
+
+	return []byte(base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+func compareBcrypt(hash []byte, password []byte) error {
+	err := bcrypt.CompareHashAndPassword(hash, password)
+
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	} else if err != nil {
+		return fmt.Errorf("can't compare bcrypt hash - %w", err)
+	}
+
+	return nil
+}
+
+// Returns parameters of hash, other fields are the same as in h so they can be compared
+func (h *Hasher) verifyArgon2id(password, encoded string) (Params, error) {
+	params := h.params
+	params.Algorithm = Argon2id
+	parts := strings.Split(encoded, "$")
+
+	if len(parts) != 6 {
+		return params, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Iterations, &params.Argon2Parallelism)
+
+	if err != nil {
+		return params, fmt.Errorf("malformed argon2id parameters - %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if err != nil {
+		return params, fmt.Errorf("malformed argon2id salt - %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err != nil {
+		return params, fmt.Errorf("malformed argon2id key - %w", err)
+	}
+
+	got := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return params, ErrMismatch
+	}
+
+	return params, nil
+}
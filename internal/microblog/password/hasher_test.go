@@ -0,0 +1,111 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			params := DefaultParams
+			params.Algorithm = algorithm
+			params.BcryptCost = bcrypt.MinCost
+			h, err := NewHasher(params)
+			require.NoError(t, err)
+
+			hash, err := h.Hash("correct horse")
+			require.NoError(t, err)
+
+			rehash, err := h.Verify("correct horse", hash, EncodedVersion)
+			require.NoError(t, err)
+			require.False(t, rehash)
+
+			_, err = h.Verify("wrong horse", hash, EncodedVersion)
+			require.ErrorIs(t, err, ErrMismatch)
+		})
+	}
+}
+
+func TestLongMultibytePassword(t *testing.T) {
+	// 64 letters take 128 bytes, passwords differ only after bcrypt limit of 72 bytes
+	password := strings.Repeat("пароль", 10) + "конь"
+	other := strings.Repeat("пароль", 10) + "кони"
+
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			params := DefaultParams
+			params.Algorithm = algorithm
+			params.BcryptCost = bcrypt.MinCost
+			h, err := NewHasher(params)
+			require.NoError(t, err)
+
+			hash, err := h.Hash(password)
+			require.NoError(t, err)
+
+			rehash, err := h.Verify(password, hash, EncodedVersion)
+			require.NoError(t, err)
+			require.False(t, rehash)
+
+			_, err = h.Verify(other, hash, EncodedVersion)
+			require.ErrorIs(t, err, ErrMismatch)
+		})
+	}
+}
+
+func TestRawBcryptHash(t *testing.T) {
+	// Written before passwords were prehashed
+	raw, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	h, err := NewHasher(Params{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost})
+	require.NoError(t, err)
+
+	rehash, err := h.Verify("correct horse", raw, EncodedVersion)
+	require.NoError(t, err)
+	require.True(t, rehash)
+
+	_, err = h.Verify("wrong horse", raw, EncodedVersion)
+	require.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestRehash(t *testing.T) {
+	old, err := NewHasher(Params{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost})
+	require.NoError(t, err)
+	hash, err := old.Hash("correct horse")
+	require.NoError(t, err)
+
+	current, err := NewHasher(DefaultParams)
+	require.NoError(t, err)
+	rehash, err := current.Verify("correct horse", hash, EncodedVersion)
+	require.NoError(t, err)
+	require.True(t, rehash, "algorithm changed")
+
+	stronger := DefaultParams
+	stronger.Argon2Iterations++
+	strongerHasher, err := NewHasher(stronger)
+	require.NoError(t, err)
+	hash, err = current.Hash("correct horse")
+	require.NoError(t, err)
+	rehash, err = strongerHasher.Verify("correct horse", hash, EncodedVersion)
+	require.NoError(t, err)
+	require.True(t, rehash, "parameters changed")
+}
+
+func TestLegacyHash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"+legacySalt), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	h, err := NewHasher(DefaultParams)
+	require.NoError(t, err)
+
+	rehash, err := h.Verify("correct horse", legacy, LegacyVersion)
+	require.NoError(t, err)
+	require.True(t, rehash)
+
+	_, err = h.Verify("correct horse", legacy, EncodedVersion)
+	require.ErrorIs(t, err, ErrMismatch)
+}
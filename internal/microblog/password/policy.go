@@ -0,0 +1,176 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules reported in Violation
+const (
+	RuleMinLength   = "min_length"
+	RuleMaxLength   = "max_length"
+	RuleCharClasses = "char_classes"
+	RuleBreached    = "breached"
+	RuleLogin       = "login"
+)
+
+type Policy struct {
+	// Length in characters, MaxLength 0 means unlimited
+	MinLength int
+	MaxLength int
+	// Distinct classes out of lower, upper, digit and other characters
+	MinCharClasses int
+	// Password must not be equal to login ignoring case
+	DisallowLogin bool
+	// Nil disables breach check
+	Breached *BreachList
+}
+
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MaxLength:      64,
+	MinCharClasses: 2,
+	DisallowLogin:  true,
+}
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+	}
+
+	return "password violates policy: " + strings.Join(rules, ", ")
+}
+
+// Validate returns *PolicyError with all violated rules
+func (p Policy) Validate(login, password string) error {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("password must be at most %d characters long", p.MaxLength),
+		})
+	}
+
+	if charClasses(password) < p.MinCharClasses {
+		violations = append(violations, Violation{
+			Rule: RuleCharClasses,
+			Message: fmt.Sprintf("password must contain at least %d of lowercase letters, uppercase letters, digits and other characters",
+				p.MinCharClasses),
+		})
+	}
+
+	if p.DisallowLogin && strings.EqualFold(login, password) {
+		violations = append(violations, Violation{Rule: RuleLogin, Message: "password must differ from login"})
+	}
+
+	if p.Breached.Contains(password) {
+		violations = append(violations, Violation{Rule: RuleBreached, Message: "password is known to be compromised"})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// BreachList is a set of SHA-1 hashes of compromised passwords
+type BreachList struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// LoadBreachList reads file in Pwned Passwords format, one hex SHA-1 per line with optional ":count" suffix
+func LoadBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't open breach list - %w", err)
+	}
+	defer f.Close()
+
+	list := &BreachList{hashes: make(map[[sha1.Size]byte]struct{})}
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		text, _, _ = strings.Cut(text, ":")
+
+		var hash [sha1.Size]byte
+		if n, err := hex.Decode(hash[:], []byte(text)); err != nil || n != sha1.Size {
+			return nil, fmt.Errorf("can't parse breach list line %d - not a SHA-1 hash", line)
+		}
+
+		list.hashes[hash] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read breach list - %w", err)
+	}
+
+	return list, nil
+}
+
+func (l *BreachList) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+
+	_, ok := l.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
+
+func (l *BreachList) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	return len(l.hashes)
+}
@@ -0,0 +1,67 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func violatedRules(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	policyErr, ok := err.(*PolicyError)
+	require.True(t, ok, "unexpected error %v", err)
+
+	var rules []string
+	for _, v := range policyErr.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		login, password string
+		rules           []string
+	}{
+		{"alice", "correct-horse-42", nil},
+		{"alice", "short1", []string{RuleMinLength}},
+		{"alice", "onlylowercase", []string{RuleCharClasses}},
+		{"aliceinchains", "AliceInChains", []string{RuleLogin}},
+		{"alice", "Пароль-длинный", nil},
+	}
+
+	for _, c := range cases {
+		err := DefaultPolicy.Validate(c.login, c.password)
+		require.Equal(t, c.rules, violatedRules(t, err), c.password)
+	}
+
+	long := strings.Repeat("a1", DefaultPolicy.MaxLength)
+	require.Equal(t, []string{RuleMaxLength}, violatedRules(t, DefaultPolicy.Validate("alice", long)))
+}
+
+func TestBreachList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// SHA-1 of "Password1" with Pwned Passwords count, and of "qwerty123"
+	content := "# comment\n70CCD9007338D6D81DD3B6271621B9CF9A97EA00:1000\n5CEC175B165E3D5E62C9E13CE848EF6FEAC81BFF\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	list, err := LoadBreachList(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, list.Len())
+	require.True(t, list.Contains("Password1"))
+	require.False(t, list.Contains("correct-horse-42"))
+
+	policy := DefaultPolicy
+	policy.Breached = list
+	require.Equal(t, []string{RuleBreached}, violatedRules(t, policy.Validate("alice", "Password1")))
+
+	require.NoError(t, os.WriteFile(path, []byte("not a hash\n"), 0o600))
+	_, err = LoadBreachList(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,279 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	maxSiteNameLength    = 100
+	maxImageUrlLength    = 2048
+	userAgent            = "blog-link-preview/1.0"
+)
+
+// Policy limits what fetcher requests and reads
+type Policy struct {
+	// Whole fetch including redirects and reading body
+	Timeout time.Duration
+	// Only beginning of larger pages is parsed, metadata is in head anyway
+	MaxBodySize  int64
+	MaxRedirects int
+	// Allows loopback and private addresses, only for tests and development
+	AllowPrivate bool
+}
+
+var DefaultPolicy = Policy{Timeout: 5 * time.Second, MaxBodySize: 1 << 20, MaxRedirects: 3}
+
+// Ranges which aren't in net.IP predicates but aren't public either
+var reservedNetworks = mustParseCidrs("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "192.0.2.0/24",
+	"198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4", "64:ff9b::/96", "2001:db8::/32")
+
+func mustParseCidrs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// IsPublic tells if ip may be fetched, private, loopback, link-local, multicast and reserved addresses may not
+func IsPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type Fetcher struct {
+	policy Policy
+	client *http.Client
+}
+
+func NewFetcher(policy Policy) *Fetcher {
+	dialer := &net.Dialer{Timeout: policy.Timeout}
+	if !policy.AllowPrivate {
+		// Address is checked after name resolution, so DNS answers can't point fetcher to internal services
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+				return ErrForbiddenAddress
+			}
+
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		// Proxy would connect to the page instead of dialer
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   policy.Timeout,
+		ResponseHeaderTimeout: policy.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       time.Minute,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   policy.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > policy.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrBadUrl
+			}
+
+			return nil
+		},
+	}
+
+	return &Fetcher{policy: policy, client: client}
+}
+
+// Fetch downloads page and reads its metadata, returned preview isn't failed
+func (f *Fetcher) Fetch(ctx context.Context, pageUrl string) (*Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageUrl, nil)
+
+	if err != nil {
+		return nil, ErrBadUrl
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch page - %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch page - status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil ||
+		mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.policy.MaxBodySize), contentType)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't decode page - %w", err)
+	}
+
+	p, err := parse(body, resp.Request.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Url = pageUrl
+	return p, nil
+}
+
+// parse reads metadata from head of the page, base resolves relative image url
+func parse(r io.Reader, base *url.URL) (*Preview, error) {
+	meta := make(map[string]string)
+	var title strings.Builder
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(r)
+	tokenizer.SetMaxBuf(256 << 10)
+
+loop:
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// Truncated body ends with EOF as well
+			break loop
+		case html.TextToken:
+			if inTitle {
+				title.Write(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				break loop
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = title.Len() == 0
+			case "body":
+				break loop
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var attr, value []byte
+					attr, value, hasAttr = tokenizer.TagAttr()
+					switch string(attr) {
+					case "property", "name":
+						key = strings.ToLower(string(value))
+					case "content":
+						content = string(value)
+					}
+				}
+
+				// The first tag wins as browsers and crawlers do
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = content
+				}
+			}
+		}
+	}
+
+	p := &Preview{
+		Title:       clean(first(meta["og:title"], meta["twitter:title"], title.String()), maxTitleLength),
+		Description: clean(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLength),
+		Image:       imageUrl(first(meta["og:image"], meta["og:image:url"], meta["twitter:image"]), base),
+		SiteName:    clean(meta["og:site_name"], maxSiteNameLength),
+	}
+
+	if p.Title == "" && p.Description == "" {
+		return nil, ErrNoMetadata
+	}
+
+	return p, nil
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// clean collapses whitespace and truncates text to maxLength runes
+func clean(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(strings.ToValidUTF8(text, "")), " ")
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:maxLength-1])) + "…"
+}
+
+func imageUrl(raw string, base *url.URL) string {
+	ref, err := url.Parse(strings.TrimSpace(raw))
+
+	if err != nil || raw == "" {
+		return ""
+	}
+
+	image := base.ResolveReference(ref)
+	if image.Scheme != "http" && image.Scheme != "https" || len(image.String()) > maxImageUrlLength {
+		return ""
+	}
+
+	return image.String()
+}
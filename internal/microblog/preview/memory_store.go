@@ -0,0 +1,39 @@
+package preview
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps previews until restart, replicas fetch previews separately
+type MemoryStore struct {
+	mu       sync.RWMutex
+	previews map[string]Preview
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{previews: make(map[string]Preview)}
+}
+
+func (m *MemoryStore) Find(_ context.Context, urls []string) ([]Preview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	previews := make([]Preview, 0, len(urls))
+	for _, u := range urls {
+		if p, ok := m.previews[u]; ok {
+			previews = append(previews, p)
+		}
+	}
+
+	return previews, nil
+}
+
+func (m *MemoryStore) Save(_ context.Context, p *Preview) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.previews[p.Url] = *p
+
+	return nil
+}
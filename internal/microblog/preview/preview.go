@@ -0,0 +1,71 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadUrl            = errors.New("only absolute http and https urls have previews")
+	ErrForbiddenAddress  = errors.New("address is not public")
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrNoMetadata        = errors.New("page has no metadata")
+	ErrUnsupportedFormat = errors.New("page is not html")
+)
+
+// Preview is card of linked page made of its OpenGraph, Twitter card or plain html metadata
+type Preview struct {
+	// Normalized url of the page
+	Url         string `bson:"_id" json:"url"`
+	Title       string `bson:"title,omitempty" json:"title,omitempty"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	// Absolute url of image, it isn't fetched by server
+	Image     string    `bson:"image,omitempty" json:"image,omitempty"`
+	SiteName  string    `bson:"siteName,omitempty" json:"siteName,omitempty"`
+	FetchedAt time.Time `bson:"fetchedAt" json:"-"`
+	// Page couldn't be fetched or has no metadata, such previews are cached too and never shown
+	Failed bool `bson:"failed,omitempty" json:"-"`
+}
+
+type Store interface {
+	// Previews of those urls which have them in any order
+	Find(ctx context.Context, urls []string) ([]Preview, error)
+	// Replaces preview with the same Url
+	Save(ctx context.Context, p *Preview) error
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Normalize returns url in the form previews are cached by: lowercase scheme and host, without default port,
+// fragment and userinfo, with at least "/" path
+func Normalize(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", ErrBadUrl
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok || u.Hostname() == "" || u.Opaque != "" {
+		return "", ErrBadUrl
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	u.Host, u.User, u.Fragment, u.RawFragment = host, nil, "", ""
+	u.ForceQuery = false
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+
+	return u.String(), nil
+}
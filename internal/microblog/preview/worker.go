@@ -0,0 +1,143 @@
+package preview
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize   = 1000
+	concurrency = 4
+	// Broken pages aren't fetched again on every view
+	failureTTL = time.Hour
+)
+
+// Worker fetches previews in background, so posting and reading never wait for linked sites
+type Worker struct {
+	store   Store
+	fetcher *Fetcher
+	ttl     time.Duration
+	logger  *slog.Logger
+	queue   chan string
+	mu      sync.Mutex
+	// Queued or being fetched urls, each is fetched once at a time
+	pending map[string]struct{}
+	now     func() time.Time
+}
+
+// NewWorker refetches previews older than ttl
+func NewWorker(store Store, fetcher *Fetcher, ttl time.Duration, logger *slog.Logger) *Worker {
+	return &Worker{store: store, fetcher: fetcher, ttl: ttl, logger: logger, queue: make(chan string, queueSize),
+		pending: make(map[string]struct{}), now: time.Now}
+}
+
+// Enqueue schedules fetch of normalized url, it is dropped if queue is full
+func (w *Worker) Enqueue(url string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.pending[url]; ok {
+		return
+	}
+
+	select {
+	case w.queue <- url:
+		w.pending[url] = struct{}{}
+	default:
+		w.logger.Warn("link preview queue is full", slog.String("url", url))
+	}
+}
+
+// Previews returns shown previews of normalized urls by url, missing and stale ones are enqueued
+func (w *Worker) Previews(ctx context.Context, urls []string) (map[string]Preview, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	found, err := w.store.Find(ctx, urls)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := w.now()
+	previews := make(map[string]Preview, len(found))
+	for _, p := range found {
+		ttl := w.ttl
+		if p.Failed {
+			ttl = failureTTL
+		} else {
+			previews[p.Url] = p
+		}
+
+		if now.Sub(p.FetchedAt) > ttl {
+			w.Enqueue(p.Url)
+		}
+	}
+
+	for _, url := range urls {
+		if _, ok := previews[url]; !ok && !contains(found, url) {
+			w.Enqueue(url)
+		}
+	}
+
+	return previews, nil
+}
+
+func contains(previews []Preview, url string) bool {
+	for _, p := range previews {
+		if p.Url == url {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run fetches enqueued urls until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case url := <-w.queue:
+					w.fetch(ctx, url)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (w *Worker) fetch(ctx context.Context, url string) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.pending, url)
+		w.mu.Unlock()
+	}()
+
+	p, err := w.fetcher.Fetch(ctx, url)
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		w.logger.Info("can't fetch link preview", slog.String("url", url), slog.String("error", err.Error()))
+		p = &Preview{Url: url, Failed: true}
+	}
+
+	p.FetchedAt = w.now().UTC()
+	if err := w.store.Save(ctx, p); err != nil {
+		w.logger.Error("can't save link preview", slog.String("url", url), slog.String("error", err.Error()))
+	}
+}
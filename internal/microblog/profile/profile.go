@@ -0,0 +1,119 @@
+package profile
+
+import (
+	"blog/internal/microblog/storage"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fields reported in Violation
+const (
+	FieldDisplayName   = "displayName"
+	FieldBio           = "bio"
+	FieldLocation      = "location"
+	FieldWebsite       = "website"
+	FieldAvatarMediaId = "avatarMediaId"
+)
+
+// Limits in characters
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxWebsiteLength     = 100
+)
+
+var mediaIdRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		fields = append(fields, v.Field)
+	}
+
+	return "profile is invalid: " + strings.Join(fields, ", ")
+}
+
+// Patch changes fields which are not nil, empty string clears field
+type Patch struct {
+	DisplayName   *string `json:"displayName"`
+	Bio           *string `json:"bio"`
+	Location      *string `json:"location"`
+	Website       *string `json:"website"`
+	AvatarMediaId *string `json:"avatarMediaId"`
+}
+
+// Apply returns p with patched fields, surrounding spaces are trimmed
+func (patch *Patch) Apply(p storage.Profile) storage.Profile {
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = strings.TrimSpace(*value)
+		}
+	}
+
+	set(&p.DisplayName, patch.DisplayName)
+	set(&p.Bio, patch.Bio)
+	set(&p.Location, patch.Location)
+	set(&p.Website, patch.Website)
+	set(&p.AvatarMediaId, patch.AvatarMediaId)
+
+	return p
+}
+
+// Validate returns *ValidationError with all invalid fields
+func Validate(p *storage.Profile) error {
+	var violations []Violation
+	violate := func(field, format string, args ...any) {
+		violations = append(violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	checkText := func(field, value string, maxLength int, multiline bool) {
+		if utf8.RuneCountInString(value) > maxLength {
+			violate(field, "%s must be at most %d characters long", field, maxLength)
+		}
+
+		if !utf8.ValidString(value) || strings.IndexFunc(value, func(r rune) bool {
+			return unicode.IsControl(r) && !(multiline && r == '\n')
+		}) >= 0 {
+			violate(field, "%s must not contain control characters", field)
+		}
+	}
+
+	checkText(FieldDisplayName, p.DisplayName, MaxDisplayNameLength, false)
+	checkText(FieldBio, p.Bio, MaxBioLength, true)
+	checkText(FieldLocation, p.Location, MaxLocationLength, false)
+
+	if p.Website != "" {
+		if len(p.Website) > MaxWebsiteLength {
+			violate(FieldWebsite, "%s must be at most %d characters long", FieldWebsite, MaxWebsiteLength)
+		}
+
+		if u, err := url.Parse(p.Website); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.User != nil {
+			violate(FieldWebsite, "%s must be http or https url", FieldWebsite)
+		}
+	}
+
+	if p.AvatarMediaId != "" && !mediaIdRegex.MatchString(p.AvatarMediaId) {
+		violate(FieldAvatarMediaId, "%s is not media id", FieldAvatarMediaId)
+	}
+
+	if len(violations) != 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const cleanupInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// Time when bucket becomes full and can be forgotten
+	fullAt time.Time
+}
+
+// MemoryStore keeps buckets of a single replica
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *MemoryStore) Take(_ context.Context, key string, p Policy) (Result, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Requests), updatedAt: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(p.Requests), b.tokens+now.Sub(b.updatedAt).Seconds()*p.Rate())
+	b.updatedAt = now
+
+	result := Result{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	}
+
+	result.Remaining = b.tokens
+	b.fullAt = now.Add(result.Reset(p))
+
+	return result, nil
+}
+
+// RunCleanup forgets full buckets until ctx is done
+func (m *MemoryStore) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.cleanup()
+		}
+	}
+}
+
+func (m *MemoryStore) cleanup() {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.buckets {
+		if !b.fullAt.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/utils"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type Limiter struct {
+	store  Store
+	logger *slog.Logger
+}
+
+func NewLimiter(store Store, logger *slog.Logger) *Limiter {
+	return &Limiter{store: store, logger: logger}
+}
+
+// Limit rejects requests over policy p, authenticated users are limited by id and anonymous by ip.
+// Users are known only when Limit runs inside auth middleware
+func (l *Limiter) Limit(p Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		result, err := l.store.Take(req.Context(), p.Name+":"+l.key(req), p)
+
+		// Broken store must not take the whole service down
+		if err != nil {
+			logging.ForRequest(l.logger, req).Error("can't check rate limit", slog.String("error", err.Error()))
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(p.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Floor(result.Remaining))))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset(p)))
+		w.Header().Set("RateLimit-Policy", p.String())
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter(p)))
+			utils.NewErrorLogger(logging.ForRequest(l.logger, req), "RateLimit").
+				WriteError(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// User id header is set by client, so it can't choose a bucket, only authenticated principal can
+func (l *Limiter) key(req *http.Request) string {
+	if principal := auth.PrincipalFromContext(req.Context()); principal != nil {
+		return "user:" + principal.UserId
+	}
+
+	return "ip:" + utils.ClientIp(req)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a token bucket which holds up to Requests tokens and is refilled completely in Per
+type Policy struct {
+	Name     string
+	Requests int
+	Per      time.Duration
+}
+
+// ParsePolicy parses policy in "<requests>/<duration>" format, e.g. "5/1m"
+func ParsePolicy(name, s string) (Policy, error) {
+	parts := strings.SplitN(s, "/", 2)
+
+	if len(parts) != 2 {
+		return Policy{}, fmt.Errorf("bad rate limit policy %q, want <requests>/<duration>", s)
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+
+	if err != nil || requests <= 0 {
+		return Policy{}, fmt.Errorf("bad number of requests in rate limit policy %q", s)
+	}
+
+	per, err := time.ParseDuration(parts[1])
+
+	if err != nil || per <= 0 {
+		return Policy{}, fmt.Errorf("bad duration in rate limit policy %q", s)
+	}
+
+	return Policy{Name: name, Requests: requests, Per: per}, nil
+}
+
+// Tokens added to bucket per second
+func (p Policy) Rate() float64 {
+	return float64(p.Requests) / p.Per.Seconds()
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Requests, int(math.Ceil(p.Per.Seconds())))
+}
+
+type Result struct {
+	Allowed bool
+	// Tokens left in bucket after this request
+	Remaining float64
+}
+
+// Time until next request is allowed
+func (r Result) RetryAfter(p Policy) time.Duration {
+	if r.Remaining >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - r.Remaining) / p.Rate() * float64(time.Second))
+}
+
+// Time until bucket is full again
+func (r Result) Reset(p Policy) time.Duration {
+	return time.Duration((float64(p.Requests) - r.Remaining) / p.Rate() * float64(time.Second))
+}
+
+// Store keeps buckets, stores shared between replicas make limits global
+type Store interface {
+	// Take refills bucket with key and takes one token from it if there is one
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
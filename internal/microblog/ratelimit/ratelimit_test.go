@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/utils"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreRefill(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	policy := Policy{Name: "test", Requests: 2, Per: 2 * time.Second}
+
+	for i := 0; i < 2; i++ {
+		result, err := store.Take(context.Background(), "key", policy)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+	}
+
+	result, err := store.Take(context.Background(), "key", policy)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Second, result.RetryAfter(policy))
+
+	result, err = store.Take(context.Background(), "other", policy)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	now = now.Add(time.Second)
+	result, err = store.Take(context.Background(), "key", policy)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	now = now.Add(time.Hour)
+	store.cleanup()
+	require.Empty(t, store.buckets)
+}
+
+func TestLimiterHeaders(t *testing.T) {
+	policy, err := ParsePolicy("login", "1/1m")
+	require.NoError(t, err)
+
+	limiter := NewLimiter(NewMemoryStore(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := limiter.Limit(policy, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// User id header is sent by client, it must not give a fresh bucket
+	req.Header.Set("System-Design-User-Id", "62f0a0a0a0a0a0a0a0a0a0a0")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Authenticated users have their own buckets
+	authenticated := req.WithContext(auth.ContextWithPrincipal(req.Context(), &auth.Principal{UserId: "62f0a0a0a0a0a0a0a0a0a0a0"}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, authenticated)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Other clients have their own buckets
+	req.RemoteAddr = "192.0.2.2:1234"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLimiterBehindProxy(t *testing.T) {
+	policy, err := ParsePolicy("login", "1/1m")
+	require.NoError(t, err)
+
+	limiter := NewLimiter(NewMemoryStore(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := utils.ClientIpMiddleware(1)(limiter.Limit(policy, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})))
+
+	// Proxy appends address of the client to whatever client has sent
+	send := func(spoofed string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-For", spoofed+"198.51.100.7")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, send(""))
+	require.Equal(t, http.StatusTooManyRequests, send("203.0.113.1, "))
+	require.Equal(t, http.StatusTooManyRequests, send("203.0.113.2,203.0.113.3, "))
+}
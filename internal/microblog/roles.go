@@ -0,0 +1,55 @@
+package microblog
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/storage"
+	"context"
+	"fmt"
+)
+
+// SetRole gives role to user with login, used by the set-role command to appoint the first admin
+func SetRole(ctx context.Context, cfg Config, login, role string) error {
+	s, err := newStorage(cfg)
+
+	if err != nil {
+		return err
+	}
+	defer s.Close(ctx)
+
+	auditStore, err := newAuditStore(cfg, s)
+
+	if err != nil {
+		return err
+	}
+
+	return setRole(ctx, s, audit.NewLog(auditStore), login, role)
+}
+
+// SetRole gives role to user with login bypassing admin api
+func (srv *MicroblogServer) SetRole(ctx context.Context, login, role string) error {
+	return setRole(ctx, *srv.storage, srv.audit, login, role)
+}
+
+func setRole(ctx context.Context, s storage.Storage, a audit.Recorder, login, role string) error {
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	user, err := s.GetUserByLogin(ctx, login)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetUserRole(ctx, user.Id, role); err != nil {
+		return err
+	}
+
+	return a.Record(ctx, &audit.Entry{
+		Actor:   "cli",
+		Action:  "user.role",
+		Target:  user.Id,
+		Details: map[string]string{"from": auth.RoleOf(user.Role), "to": role},
+	})
+}
@@ -1,51 +1,504 @@
 package microblog
 
 import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/auth"
+	"blog/internal/microblog/blobstore"
 	"blog/internal/microblog/handler"
+	"blog/internal/microblog/keys"
+	"blog/internal/microblog/lockout"
+	"blog/internal/microblog/logging"
+	"blog/internal/microblog/markdown"
+	"blog/internal/microblog/metrics"
+	"blog/internal/microblog/notify"
+	"blog/internal/microblog/oauth"
+	"blog/internal/microblog/password"
+	"blog/internal/microblog/preview"
+	"blog/internal/microblog/ratelimit"
+	"blog/internal/microblog/search"
 	"blog/internal/microblog/storage"
+	"blog/internal/microblog/storage/mapstorage"
 	"blog/internal/microblog/storage/mongostorage"
+	"blog/internal/microblog/tracing"
+	"blog/internal/microblog/utils"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 type MicroblogServer struct {
 	r       *mux.Router
 	storage *storage.Storage
+	handler *handler.Handler
+	auth    *auth.Authenticator
+	audit   *audit.Log
+	health  *handler.HealthHandler
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+	cfg     Config
+
+	// Nil when rate limiting is disabled
+	limiter      *ratelimit.Limiter
+	authLimit    ratelimit.Policy
+	postingLimit ratelimit.Policy
+
+	shutdownTracing func(context.Context) error
+
+	// Background workers are stopped by cancelling workersCtx
+	workersCtx  context.Context
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
 }
 
-func NewRouter(s *storage.Storage) *mux.Router {
+func (srv *MicroblogServer) newRouter() *mux.Router {
 	r := mux.NewRouter()
-	h := handler.NewHandler(s)
+	h := srv.handler
+
+	srv.metrics.Instrument(r)
+	r.Use(
+		otelmux.Middleware(tracing.ServiceName),
+		utils.ClientIpMiddleware(srv.trustedProxyHops()),
+		logging.Middleware(srv.logger),
+		srv.metrics.Middleware,
+	)
+
+	r.HandleFunc("/healthz", srv.health.Liveness).Methods(http.MethodGet).Name("healthz")
+	r.HandleFunc("/readyz", srv.health.Readiness).Methods(http.MethodGet).Name("readyz")
+	r.Handle("/metrics", srv.metrics.Handler()).Methods(http.MethodGet).Name("metrics")
+	r.HandleFunc("/.well-known/jwks.json", h.Jwks).Methods(http.MethodGet).Name("jwks")
 
-	r.HandleFunc("/api/v1/register", h.RegisterNewUser).Methods(http.MethodPost)
-	r.HandleFunc("/api/v1/login", h.Login)
-	r.HandleFunc("/api/v1/posts", h.AddPost).Methods(http.MethodPost)
-	r.HandleFunc("/api/v1/posts/{postId}", h.GetPost).Methods(http.MethodGet)
-	r.HandleFunc("/api/v1/users/{userId}/posts", h.GetUserPosts).Methods(http.MethodGet)
+	r.Handle("/api/v1/register", srv.limit(srv.authLimit, h.RegisterNewUser)).Methods(http.MethodPost).Name("register")
+	r.Handle("/api/v1/login", srv.limit(srv.authLimit, h.Login)).Name("login")
+	r.Handle("/api/v1/login/totp", srv.limit(srv.authLimit, h.LoginTotp)).Methods(http.MethodPost).Name("loginTotp")
+	r.Handle("/api/v1/password-reset", srv.limit(srv.authLimit, h.RequestPasswordReset)).
+		Methods(http.MethodPost).Name("requestPasswordReset")
+	r.Handle("/api/v1/password-reset/confirm", srv.limit(srv.authLimit, h.ConfirmPasswordReset)).
+		Methods(http.MethodPost).Name("confirmPasswordReset")
+	r.Handle("/api/v1/users/me", srv.authenticated(http.HandlerFunc(h.UpdateProfile))).
+		Methods(http.MethodPatch).Name("updateProfile")
+	r.Handle("/api/v1/users/me/password", srv.authenticated(srv.limit(srv.authLimit, h.ChangePassword))).
+		Methods(http.MethodPut).Name("changePassword")
+	r.Handle("/api/v1/users/me/totp", srv.authenticated(srv.limit(srv.authLimit, h.EnrollTotp))).
+		Methods(http.MethodPost).Name("enrollTotp")
+	r.Handle("/api/v1/users/me/totp/confirm", srv.authenticated(srv.limit(srv.authLimit, h.ConfirmTotp))).
+		Methods(http.MethodPost).Name("confirmTotp")
+	r.Handle("/api/v1/users/me/tokens", srv.authenticated(http.HandlerFunc(h.ListApiTokens))).
+		Methods(http.MethodGet).Name("listApiTokens")
+	r.Handle("/api/v1/users/me/tokens", srv.authenticated(srv.limit(srv.authLimit, h.CreateApiToken))).
+		Methods(http.MethodPost).Name("createApiToken")
+	r.Handle("/api/v1/users/me/tokens/{tokenId}", srv.authenticated(http.HandlerFunc(h.DeleteApiToken))).
+		Methods(http.MethodDelete).Name("deleteApiToken")
+	r.Handle("/api/v1/oauth/clients", srv.authenticated(srv.limit(srv.authLimit, h.RegisterOAuthClient))).
+		Methods(http.MethodPost).Name("registerOAuthClient")
+	r.Handle("/api/v1/oauth/authorize", srv.authenticated(http.HandlerFunc(h.AuthorizationConsent))).
+		Methods(http.MethodGet).Name("authorizationConsent")
+	r.Handle("/api/v1/oauth/authorize", srv.authenticated(http.HandlerFunc(h.AuthorizationDecision))).
+		Methods(http.MethodPost).Name("authorizationDecision")
+	r.Handle("/api/v1/oauth/token", srv.limit(srv.authLimit, h.OAuthToken)).Methods(http.MethodPost).Name("oauthToken")
+	r.Handle("/api/v1/oauth/introspect", srv.limit(srv.authLimit, h.OAuthIntrospect)).
+		Methods(http.MethodPost).Name("oauthIntrospect")
+	r.Handle("/api/v1/posts", srv.auth.Required(auth.ScopePostsWrite, srv.limit(srv.postingLimit, h.AddPost).ServeHTTP)).
+		Methods(http.MethodPost).Name("addPost")
+	r.Handle("/api/v1/media", srv.auth.Required(auth.ScopePostsWrite, srv.limit(srv.postingLimit, h.UploadMedia).ServeHTTP)).
+		Methods(http.MethodPost).Name("uploadMedia")
+	r.HandleFunc("/api/v1/media/{mediaId}", h.GetMedia).Methods(http.MethodGet).Name("getMedia")
+	r.HandleFunc("/api/v1/media/{mediaId}/thumbnail", h.GetMediaThumbnail).Methods(http.MethodGet).Name("getMediaThumbnail")
+	r.Handle("/api/v1/posts/{postId}", srv.auth.Optional(auth.ScopePostsRead, h.GetPost)).Methods(http.MethodGet).Name("getPost")
+	r.HandleFunc("/api/v1/users/by-login/{login}", h.GetProfileByLogin).Methods(http.MethodGet).Name("getProfileByLogin")
+	r.HandleFunc("/api/v1/users/search", h.SearchUsers).Methods(http.MethodGet).Name("searchUsers")
+	r.HandleFunc("/api/v1/users/{userId}", h.GetProfile).Methods(http.MethodGet).Name("getProfile")
+	r.Handle("/api/v1/search/posts", srv.auth.Optional(auth.ScopePostsRead, h.SearchPosts)).
+		Methods(http.MethodGet).Name("searchPosts")
+	r.Handle("/api/v1/users/{userId}/posts", srv.auth.Optional(auth.ScopePostsRead, h.GetUserPosts)).
+		Methods(http.MethodGet).Name("getUserPosts")
+
+	r.Handle("/api/v1/admin/users", srv.admin(auth.PermissionUsersRead, h.ListUsers)).Methods(http.MethodGet).Name("listUsers")
+	r.Handle("/api/v1/admin/users/{userId}/suspension", srv.admin(auth.PermissionUsersSuspend, h.SuspendUser)).
+		Methods(http.MethodPut).Name("suspendUser")
+	r.Handle("/api/v1/admin/users/{userId}/suspension", srv.admin(auth.PermissionUsersSuspend, h.UnsuspendUser)).
+		Methods(http.MethodDelete).Name("unsuspendUser")
+	r.Handle("/api/v1/admin/users/{userId}/role", srv.admin(auth.PermissionRolesAssign, h.SetUserRole)).
+		Methods(http.MethodPut).Name("setUserRole")
+	r.Handle("/api/v1/admin/posts/{postId}", srv.admin(auth.PermissionPostsDelete, h.ForceDeletePost)).
+		Methods(http.MethodDelete).Name("forceDeletePost")
+	r.Handle("/api/v1/admin/audit", srv.admin(auth.PermissionAuditRead, h.ListAuditEntries)).
+		Methods(http.MethodGet).Name("listAuditEntries")
+	r.Handle("/api/v1/admin/audit/verify", srv.admin(auth.PermissionAuditRead, h.VerifyAuditLog)).
+		Methods(http.MethodGet).Name("verifyAuditLog")
+	r.Handle("/api/v1/admin/lockouts", srv.admin(auth.PermissionLockoutsAdmin, h.ListLockouts)).
+		Methods(http.MethodGet).Name("listLockouts")
+	r.Handle("/api/v1/admin/lockouts/{kind}/{value}", srv.admin(auth.PermissionLockoutsAdmin, h.Unlock)).
+		Methods(http.MethodDelete).Name("unlock")
 
 	return r
 }
 
-func NewMicroblogServer(mongoUrl string) *MicroblogServer {
-	s, err := mongostorage.NewMongoStorage(mongoUrl)
+func (srv *MicroblogServer) limit(p ratelimit.Policy, h http.HandlerFunc) http.Handler {
+	if srv.limiter == nil {
+		return h
+	}
+
+	return srv.limiter.Limit(p, h)
+}
+
+// Account management is available only with access token of login session
+func (srv *MicroblogServer) authenticated(h http.Handler) http.Handler {
+	return srv.auth.Required(auth.ScopeAccount, h.ServeHTTP)
+}
+
+// Admin api is available with access token of login session of user whose role has permission
+func (srv *MicroblogServer) admin(permission string, h http.HandlerFunc) http.Handler {
+	return srv.auth.Authorized(permission, h)
+}
+
+func newStorage(cfg Config) (storage.Storage, error) {
+	switch cfg.Storage {
+	case MongoStorage:
+		s, err := mongostorage.NewMongoStorage(cfg.MongoUrl, cfg.MigrateOnStart)
+
+		if err != nil {
+			return nil, fmt.Errorf("can't create mongo storage - %w", err)
+		}
+
+		return s, nil
+	case MemoryStorage:
+		return mapstorage.NewMapStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
+
+// Audit log is kept by the same storage as everything else, s must not be decorated yet
+func newAuditStore(cfg Config, s storage.Storage) (audit.Store, error) {
+	if cfg.Storage == MongoStorage {
+		return mongostorage.NewAuditStore(s)
+	}
+
+	return audit.NewMemoryStore(), nil
+}
+
+// Mongo posts are searched with text index, in-memory posts are indexed as they are added
+func newSearcher(cfg Config, s storage.Storage) (search.Searcher, error) {
+	if cfg.Storage == MongoStorage {
+		return mongostorage.NewPostSearch(s)
+	}
+
+	return search.NewMemoryIndex(), nil
+}
+
+func newPreviewStore(cfg Config, s storage.Storage) (preview.Store, error) {
+	if cfg.Storage == MongoStorage {
+		return mongostorage.NewPreviewStore(s)
+	}
+
+	return preview.NewMemoryStore(), nil
+}
+
+func newBlobStore(cfg Config) (blobstore.Store, error) {
+	switch cfg.MediaStore {
+	case blobstore.FileStore:
+		return blobstore.NewFileStore(cfg.MediaDir)
+	case blobstore.S3Store:
+		return blobstore.NewS3Store(cfg.MediaS3)
+	default:
+		return nil, fmt.Errorf("unknown media store %q", cfg.MediaStore)
+	}
+}
+
+// s must not be decorated yet, mongo store shares its connections
+func (srv *MicroblogServer) setupRateLimits(s storage.Storage) error {
+	if !srv.cfg.RateLimitEnabled {
+		return nil
+	}
+
+	var err error
+	if srv.authLimit, err = ratelimit.ParsePolicy("auth", srv.cfg.RateLimitAuth); err != nil {
+		return err
+	}
+
+	if srv.postingLimit, err = ratelimit.ParsePolicy("posting", srv.cfg.RateLimitPosting); err != nil {
+		return err
+	}
+
+	var store ratelimit.Store
+	switch srv.cfg.RateLimitStore {
+	case MongoStorage:
+		if store, err = mongostorage.NewRateLimitStore(s); err != nil {
+			return err
+		}
+	case MemoryStorage:
+		memoryStore := ratelimit.NewMemoryStore()
+		srv.runWorker(memoryStore.RunCleanup)
+		store = memoryStore
+	default:
+		return fmt.Errorf("unknown rate limit store %q", srv.cfg.RateLimitStore)
+	}
+
+	srv.limiter = ratelimit.NewLimiter(store, srv.logger)
+
+	return nil
+}
+
+// X-Forwarded-For is ignored unless deployment is known to be behind proxies
+func (srv *MicroblogServer) trustedProxyHops() int {
+	if !srv.cfg.TrustForwardedFor {
+		return 0
+	}
+
+	return srv.cfg.TrustedProxyHops
+}
+
+func NewMicroblogServer(cfg Config) (*MicroblogServer, error) {
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages of log package from libraries and storages go to the same output
+	slog.SetDefault(logger)
+
+	srv := &MicroblogServer{logger: logger, cfg: cfg}
+	srv.workersCtx, srv.stopWorkers = context.WithCancel(context.Background())
+
+	// Tracing goes first, storage instruments its client with global tracer provider
+	srv.shutdownTracing, err = tracing.Setup(context.Background(), cfg.TracingExporter)
+
+	if err != nil {
+		srv.stopWorkers()
+		return nil, err
+	}
+
+	s, err := newStorage(cfg)
+
+	if err != nil {
+		srv.release(nil)
+		return nil, err
+	}
+
+	srv.metrics = metrics.New()
+	if err := srv.metrics.RegisterStorageStats(s); err != nil {
+		srv.release(s)
+		return nil, fmt.Errorf("can't register storage metrics - %w", err)
+	}
+
+	if err := srv.setupRateLimits(s); err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	auditStore, err := newAuditStore(cfg, s)
 
 	if err != nil {
-		panic(fmt.Errorf("can't create mongo storage - %w", err))
+		srv.release(s)
+		return nil, err
 	}
 
-	return &MicroblogServer{r: NewRouter(&s), storage: &s}
+	srv.audit = audit.NewLog(auditStore)
+	searcher, err := newSearcher(cfg, s)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	previewStore, err := newPreviewStore(cfg, s)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	s = srv.metrics.NewStorage(s)
+
+	srv.storage = &s
+	loginPolicy, ipPolicy := lockout.DefaultLoginPolicy, lockout.DefaultIpPolicy
+	loginPolicy.Threshold, ipPolicy.Threshold = cfg.LoginLockoutThreshold, cfg.IpLockoutThreshold
+	loginPolicy.LockoutDuration, ipPolicy.LockoutDuration = cfg.LoginLockoutDuration, cfg.IpLockoutDuration
+
+	hasher, err := password.NewHasher(cfg.PasswordHash)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	policy := cfg.PasswordPolicy
+	if cfg.PasswordBreachList != "" {
+		if policy.Breached, err = password.LoadBreachList(cfg.PasswordBreachList); err != nil {
+			srv.release(s)
+			return nil, err
+		}
+		logger.Info("breach list loaded", slog.Int("hashes", policy.Breached.Len()))
+	}
+
+	notifier, err := notify.New(cfg.Notifier, cfg.NotifierFile, logger)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	keyManager, err := keys.NewManager(cfg.SigningKeys, logger)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	blobs, err := newBlobStore(cfg)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	var renderer *markdown.Renderer
+	if cfg.MarkdownEnabled {
+		renderer = markdown.NewRenderer(cfg.MarkdownCacheSize)
+	}
+
+	var previews *preview.Worker
+	if cfg.LinkPreviewEnabled {
+		previews = preview.NewWorker(previewStore, preview.NewFetcher(cfg.LinkPreview), cfg.LinkPreviewTTL, logger)
+		srv.runWorker(previews.Run)
+	}
+
+	srv.runWorker(keyManager.Run)
+	srv.auth = auth.NewAuthenticator(&s, keyManager, cfg.SessionTTL)
+	srv.handler, err = handler.NewHandler(&s, handler.Components{
+		Guard:            lockout.NewGuard(&s, srv.audit, loginPolicy, ipPolicy),
+		Hasher:           hasher,
+		Policy:           policy,
+		Auth:             srv.auth,
+		Audit:            srv.audit,
+		Keys:             keyManager,
+		Notifier:         notifier,
+		Metrics:          srv.metrics,
+		OAuth:            oauth.NewServer(&s, srv.auth, cfg.OAuthTokenTTL),
+		Search:           searcher,
+		Blobs:            blobs,
+		MediaLimits:      cfg.MediaLimits,
+		PostMaxLength:    cfg.PostMaxLength,
+		Markdown:         renderer,
+		Previews:         previews,
+		PasswordResetTTL: cfg.PasswordResetTTL,
+		TotpIssuer:       cfg.TotpIssuer,
+	}, logger)
+
+	if err != nil {
+		srv.release(s)
+		return nil, err
+	}
+
+	srv.health = handler.NewHealthHandler(&s, logger)
+	srv.r = srv.newRouter()
+
+	return srv, nil
 }
 
-func (srv *MicroblogServer) StartNewMicrobologServer(port int) {
+// Releases what was created by NewMicroblogServer before it failed
+func (srv *MicroblogServer) release(s storage.Storage) {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.cfg.ShutdownTimeout)
+	defer cancel()
+
+	srv.waitWorkers(ctx)
+
+	if s != nil {
+		s.Close(ctx)
+	}
+
+	srv.shutdownTracing(ctx)
+}
+
+// Serves until SIGINT or SIGTERM, then shuts down gracefully
+func (srv *MicroblogServer) StartNewMicrobologServer(port int) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return srv.Run(ctx, port)
+}
+
+// Serves until ctx is done, then drains in-flight requests, stops background workers and closes storage
+func (srv *MicroblogServer) Run(ctx context.Context, port int) error {
 	server := &http.Server{
 		Handler:      srv.r,
 		Addr:         "0.0.0.0:" + strconv.Itoa(port),
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
-	server.ListenAndServe()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("server stopped - %w", err)
+	case <-ctx.Done():
+		srv.logger.Info("shutting down")
+		srv.health.SetShuttingDown()
+		// Give balancers time to notice failing readiness before listener is closed
+		time.Sleep(srv.cfg.ShutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = fmt.Errorf("can't drain in-flight requests - %w", shutdownErr)
+	}
+
+	if workersErr := srv.waitWorkers(shutdownCtx); workersErr != nil && err == nil {
+		err = workersErr
+	}
+
+	if closeErr := (*srv.storage).Close(shutdownCtx); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	if tracingErr := srv.shutdownTracing(shutdownCtx); tracingErr != nil && err == nil {
+		err = fmt.Errorf("can't flush spans - %w", tracingErr)
+	}
+
+	return err
+}
+
+// Starts background worker, it must return when ctx is done
+func (srv *MicroblogServer) runWorker(worker func(ctx context.Context)) {
+	srv.workers.Add(1)
+	go func() {
+		defer srv.workers.Done()
+		worker(srv.workersCtx)
+	}()
+}
+
+func (srv *MicroblogServer) waitWorkers(ctx context.Context) error {
+	srv.stopWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		srv.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("background workers didn't stop in time")
+	}
 }
@@ -0,0 +1,211 @@
+package search
+
+import (
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/storage"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type indexedPost struct {
+	post storage.Post
+	// Words of text in order, phrases are matched against them
+	words    []string
+	hashtags map[string]bool
+	time     time.Time
+}
+
+// MemoryIndex is inverted index of posts of in-memory storage, it is lost on restart together with posts
+type MemoryIndex struct {
+	mu    sync.RWMutex
+	posts map[string]*indexedPost
+	// Word to number of its occurrences in each post containing it
+	postings map[string]map[string]int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{posts: make(map[string]*indexedPost), postings: make(map[string]map[string]int)}
+}
+
+func (m *MemoryIndex) Index(_ context.Context, post *storage.Post) error {
+	id, err := primitive.ObjectIDFromHex(post.Id)
+
+	if err != nil {
+		return err
+	}
+
+	indexed := &indexedPost{post: *post, words: Tokenize(post.Text), hashtags: map[string]bool{},
+		time: id.Timestamp().UTC()}
+	for _, tag := range composer.Hashtags(post.Text) {
+		indexed.hashtags[tag] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(post.Id)
+	m.posts[post.Id] = indexed
+	for _, word := range indexed.words {
+		if m.postings[word] == nil {
+			m.postings[word] = make(map[string]int)
+		}
+		m.postings[word][post.Id]++
+	}
+
+	return nil
+}
+
+func (m *MemoryIndex) Remove(_ context.Context, postId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(postId)
+
+	return nil
+}
+
+func (m *MemoryIndex) remove(postId string) {
+	indexed, ok := m.posts[postId]
+
+	if !ok {
+		return
+	}
+
+	for _, word := range indexed.words {
+		delete(m.postings[word], postId)
+		if len(m.postings[word]) == 0 {
+			delete(m.postings, word)
+		}
+	}
+	delete(m.posts, postId)
+}
+
+type scoredPost struct {
+	*indexedPost
+	score float64
+}
+
+func (m *MemoryIndex) Search(_ context.Context, q *Query, size int) (*Result, error) {
+	page, err := DecodePage(q.Page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	words := q.Words
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+
+	found := make([]scoredPost, 0)
+	for id, indexed := range m.candidates(words) {
+		if !m.matches(indexed, q, page) {
+			continue
+		}
+
+		found = append(found, scoredPost{indexedPost: indexed, score: m.score(id, words)})
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if q.Ranked() && found[i].score != found[j].score {
+			return found[i].score > found[j].score
+		}
+		return found[i].post.Id > found[j].post.Id
+	})
+
+	if q.Ranked() {
+		found = found[min(page.Offset, len(found)):]
+	}
+
+	posts := make([]storage.Post, 0, size)
+	for i := 0; i < len(found) && i < size; i++ {
+		posts = append(posts, found[i].post)
+	}
+
+	return &Result{Posts: posts, NextPage: NextPage(q, page, posts, size)}, nil
+}
+
+// candidates are posts containing the rarest word, all posts if there are no words
+func (m *MemoryIndex) candidates(words []string) map[string]*indexedPost {
+	if len(words) == 0 {
+		return m.posts
+	}
+
+	rarest := m.postings[words[0]]
+	for _, word := range words[1:] {
+		if len(m.postings[word]) < len(rarest) {
+			rarest = m.postings[word]
+		}
+	}
+
+	candidates := make(map[string]*indexedPost, len(rarest))
+	for id := range rarest {
+		candidates[id] = m.posts[id]
+	}
+
+	return candidates
+}
+
+func (m *MemoryIndex) matches(indexed *indexedPost, q *Query, page Page) bool {
+	id := indexed.post.Id
+
+	for _, word := range q.Words {
+		if m.postings[word][id] == 0 {
+			return false
+		}
+	}
+
+	for _, phrase := range q.Phrases {
+		if !containsPhrase(indexed.words, phrase) {
+			return false
+		}
+	}
+
+	for _, tag := range q.Hashtags {
+		if !indexed.hashtags[tag] {
+			return false
+		}
+	}
+
+	return (q.AuthorId == "" || indexed.post.AuthorId == q.AuthorId) &&
+		(q.From.IsZero() || !indexed.time.Before(q.From.Truncate(time.Second))) &&
+		(q.To.IsZero() || !indexed.time.After(q.To)) &&
+		(q.Ranked() || page.BeforeId == "" || id < page.BeforeId)
+}
+
+func containsPhrase(words, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j := range phrase {
+			if words[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// score is tf-idf, so rare words weigh more than common ones
+func (m *MemoryIndex) score(id string, words []string) float64 {
+	var score float64
+	for _, word := range words {
+		postings := m.postings[word]
+		score += float64(postings[id]) * math.Log(1+float64(len(m.posts))/float64(len(postings)))
+	}
+
+	return score
+}
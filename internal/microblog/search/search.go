@@ -0,0 +1,163 @@
+package search
+
+import (
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/storage"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Orders of results
+const (
+	SortRelevance = "relevance"
+	SortRecent    = "recent"
+)
+
+const (
+	// Ranked results are paginated by offset, deep pages are too expensive
+	MaxOffset = 1000
+	// Words and phrases of one query
+	maxTerms = 10
+)
+
+var (
+	ErrEmptyQuery   = errors.New("query has neither words nor filters")
+	ErrTooManyTerms = errors.New("query has too many words")
+	ErrManyAuthors  = errors.New("only one from: is supported")
+	ErrBadPage      = errors.New("page token is invalid")
+)
+
+type Query struct {
+	// Lowercase words each of which post must contain
+	Words []string
+	// Sequences of lowercase words which post must contain one after another
+	Phrases [][]string
+	// Login of from:login, caller resolves it to AuthorId
+	AuthorLogin string
+	AuthorId    string
+	// Lowercase hashtags without #, post must have all of them
+	Hashtags []string
+	// Inclusive bounds of post time, zero bounds are open
+	From time.Time
+	To   time.Time
+	Sort string
+	// NextPage of previous Result with the same query
+	Page string
+}
+
+// Ranked reports whether results are ordered by relevance, queries without words are ordered by recency anyway
+func (q *Query) Ranked() bool {
+	return q.Sort != SortRecent && len(q.Words)+len(q.Phrases) != 0
+}
+
+type Result struct {
+	Posts []storage.Post
+	// Empty if there are no more posts
+	NextPage string
+}
+
+// Searcher finds posts, implementations which search storage of posts directly may ignore Index and Remove
+type Searcher interface {
+	// Index makes new post findable
+	Index(ctx context.Context, post *storage.Post) error
+	Remove(ctx context.Context, postId string) error
+	// ErrBadPage if page token of query is invalid
+	Search(ctx context.Context, q *Query, size int) (*Result, error)
+}
+
+// Tokenize splits text into lowercase words of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Parse reads words, "quoted phrases", from:login and #hashtags of q
+func Parse(q string) (*Query, error) {
+	query := &Query{Sort: SortRelevance}
+
+	for i, part := range strings.Split(q, `"`) {
+		// Odd parts are inside quotes, unterminated quote lasts until the end
+		if i%2 == 1 {
+			if words := Tokenize(part); len(words) == 1 {
+				query.Words = append(query.Words, words[0])
+			} else if len(words) > 1 {
+				query.Phrases = append(query.Phrases, words)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			if login, ok := strings.CutPrefix(field, "from:"); ok && login != "" {
+				if query.AuthorLogin != "" {
+					return nil, ErrManyAuthors
+				}
+				query.AuthorLogin = login
+			} else if strings.HasPrefix(field, "#") && len(composer.Hashtags(field)) != 0 {
+				query.Hashtags = append(query.Hashtags, composer.Hashtags(field)...)
+			} else {
+				query.Words = append(query.Words, Tokenize(field)...)
+			}
+		}
+	}
+
+	if len(query.Words)+len(query.Phrases) > maxTerms {
+		return nil, ErrTooManyTerms
+	}
+
+	if len(query.Words)+len(query.Phrases)+len(query.Hashtags) == 0 && query.AuthorLogin == "" {
+		return nil, ErrEmptyQuery
+	}
+
+	return query, nil
+}
+
+// Page is position after the last post of result, ranked results use Offset and others BeforeId
+type Page struct {
+	Offset int `json:"o,omitempty"`
+	// Hex id of the last post
+	BeforeId string `json:"b,omitempty"`
+}
+
+func (p Page) Encode() string {
+	b, _ := json.Marshal(p)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodePage returns zero Page for empty token
+func DecodePage(token string) (Page, error) {
+	var p Page
+	if token == "" {
+		return p, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+
+	if err != nil || json.Unmarshal(b, &p) != nil || p.Offset < 0 || p.Offset > MaxOffset {
+		return p, ErrBadPage
+	}
+
+	return p, nil
+}
+
+// NextPage of result with size posts at page p, empty if it was the last one
+func NextPage(q *Query, p Page, posts []storage.Post, size int) string {
+	if len(posts) < size {
+		return ""
+	}
+
+	if q.Ranked() {
+		if p.Offset+size >= MaxOffset {
+			return ""
+		}
+		return Page{Offset: p.Offset + size}.Encode()
+	}
+
+	return Page{BeforeId: posts[len(posts)-1].Id}.Encode()
+}
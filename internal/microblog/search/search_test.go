@@ -0,0 +1,142 @@
+package search
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`Hello "big  World" from:alice #Go #go_lang "single" unterminated "quote here`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello", "single", "unterminated"}, q.Words)
+	require.Equal(t, [][]string{{"big", "world"}, {"quote", "here"}}, q.Phrases)
+	require.Equal(t, "alice", q.AuthorLogin)
+	require.Equal(t, []string{"go", "go_lang"}, q.Hashtags)
+	require.True(t, q.Ranked())
+
+	q, err = Parse("from:alice")
+	require.NoError(t, err)
+	require.False(t, q.Ranked(), "filters only are ordered by recency")
+
+	for query, expected := range map[string]error{
+		"":                      ErrEmptyQuery,
+		`"" ... #`:              ErrEmptyQuery,
+		"from:alice from:bob":   ErrManyAuthors,
+		"a b c d e f g h i j k": ErrTooManyTerms,
+	} {
+		_, err := Parse(query)
+		require.ErrorIs(t, err, expected, "query %q", query)
+	}
+}
+
+func TestDecodePage(t *testing.T) {
+	p, err := DecodePage(Page{Offset: 20}.Encode())
+	require.NoError(t, err)
+	require.Equal(t, Page{Offset: 20}, p)
+
+	for _, token := range []string{"!!!", "bm90IGpzb24", Page{Offset: MaxOffset + 1}.Encode()} {
+		_, err := DecodePage(token)
+		require.ErrorIs(t, err, ErrBadPage, "token %q", token)
+	}
+}
+
+type fixture struct {
+	index *MemoryIndex
+	start time.Time
+	ids   map[string]string
+}
+
+// newFixture indexes posts named by their text, each one minute after previous
+func newFixture(t *testing.T, posts ...storage.Post) *fixture {
+	f := &fixture{index: NewMemoryIndex(), start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ids: map[string]string{}}
+
+	for i, post := range posts {
+		post.Id = primitive.NewObjectIDFromTimestamp(f.start.Add(time.Duration(i) * time.Minute)).Hex()
+		require.NoError(t, f.index.Index(context.Background(), &post))
+		f.ids[post.Text] = post.Id
+	}
+
+	return f
+}
+
+func (f *fixture) search(t *testing.T, q string, modify func(q *Query)) []string {
+	query, err := Parse(q)
+	require.NoError(t, err)
+	if modify != nil {
+		modify(query)
+	}
+
+	result, err := f.index.Search(context.Background(), query, 10)
+	require.NoError(t, err)
+
+	texts := make([]string, 0)
+	for _, post := range result.Posts {
+		texts = append(texts, post.Text)
+	}
+	return texts
+}
+
+func TestMemoryIndex(t *testing.T) {
+	f := newFixture(t,
+		storage.Post{Text: "go is fun", AuthorId: "a"},
+		storage.Post{Text: "fun with go and more go #golang", AuthorId: "b"},
+		storage.Post{Text: "is go fun? #golang #news", AuthorId: "a"},
+		storage.Post{Text: "nothing here", AuthorId: "b"},
+	)
+
+	require.Equal(t, []string{"fun with go and more go #golang", "is go fun? #golang #news", "go is fun"},
+		f.search(t, "go fun", nil), "more occurrences rank higher, ties are broken by recency")
+	require.Equal(t, []string{"is go fun? #golang #news", "fun with go and more go #golang", "go is fun"},
+		f.search(t, "go fun", func(q *Query) { q.Sort = SortRecent }))
+	require.Equal(t, []string{"is go fun? #golang #news"}, f.search(t, `"go fun"`, nil))
+	require.Equal(t, []string{"go is fun"}, f.search(t, `"is fun"`, nil))
+	require.Equal(t, []string{"is go fun? #golang #news", "fun with go and more go #golang"}, f.search(t, "#GoLang", nil))
+	require.Equal(t, []string{"is go fun? #golang #news"}, f.search(t, "#golang #news", nil))
+	require.Equal(t, []string{"is go fun? #golang #news", "go is fun"},
+		f.search(t, "go", func(q *Query) { q.AuthorId = "a"; q.Sort = SortRecent }))
+	require.Equal(t, []string{"is go fun? #golang #news", "fun with go and more go #golang"},
+		f.search(t, "go", func(q *Query) {
+			q.From, q.To, q.Sort = f.start.Add(time.Minute), f.start.Add(2*time.Minute), SortRecent
+		}))
+	require.Empty(t, f.search(t, "go missing", nil))
+
+	require.NoError(t, f.index.Remove(context.Background(), f.ids["go is fun"]))
+	require.Empty(t, f.search(t, `"is fun"`, nil))
+	require.Len(t, f.index.postings["is"], 1, "postings of removed post are removed")
+}
+
+func TestMemoryIndexPagination(t *testing.T) {
+	var posts []storage.Post
+	for i := 0; i < 5; i++ {
+		posts = append(posts, storage.Post{Text: "word"})
+	}
+	f := newFixture(t, posts...)
+
+	for _, sort := range []string{SortRelevance, SortRecent} {
+		seen := map[string]bool{}
+		q := &Query{Words: []string{"word"}, Sort: sort}
+
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+			result, err := f.index.Search(context.Background(), q, 2)
+			require.NoError(t, err)
+
+			for _, post := range result.Posts {
+				require.False(t, seen[post.Id], "pages of %s don't overlap", sort)
+				seen[post.Id] = true
+			}
+
+			if result.NextPage == "" {
+				break
+			}
+			q.Page = result.NextPage
+		}
+
+		require.Len(t, seen, 5)
+	}
+}
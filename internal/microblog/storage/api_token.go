@@ -0,0 +1,22 @@
+package storage
+
+import "time"
+
+// Personal API token, token itself is shown to user only once
+type ApiToken struct {
+	Id     string   `bson:"_id,omitempty"`
+	UserId string   `bson:"userId"`
+	Name   string   `bson:"name"`
+	Scopes []string `bson:"scopes"`
+	// Beginning of token to let user recognize it
+	Prefix string `bson:"prefix"`
+	// SHA-256 of token
+	TokenHash []byte    `bson:"tokenHash"`
+	CreatedAt time.Time `bson:"createdAt"`
+	// Zero if token was never used
+	LastUsedAt time.Time `bson:"lastUsedAt"`
+	// Zero if token doesn't expire
+	ExpiresAt time.Time `bson:"expiresAt"`
+	// OAuth client token was issued to, empty for personal tokens
+	ClientId string `bson:"clientId,omitempty"`
+}
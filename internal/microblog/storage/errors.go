@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when requested entity doesn't exist or is no longer usable
+var ErrNotFound = errors.New("not found")
+
+// ConflictError is returned when a new entity violates a uniqueness constraint
+type ConflictError struct {
+	Entity string
+	Field  string
+	Value  string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s with %s %q already exists", e.Entity, e.Field, e.Value)
+}
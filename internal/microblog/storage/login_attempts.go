@@ -0,0 +1,14 @@
+package storage
+
+import "time"
+
+// Failed login attempts of a single key, which is either requested login or client ip
+type LoginAttempts struct {
+	Key           string    `bson:"_id"`
+	Failures      int       `bson:"failures"`
+	LastFailureAt time.Time `bson:"lastFailureAt"`
+	// Attempts before this moment are rejected without checking password
+	BlockedUntil time.Time `bson:"blockedUntil"`
+	// Failures reached lockout threshold, not just a progressive delay
+	LockedOut bool `bson:"lockedOut"`
+}
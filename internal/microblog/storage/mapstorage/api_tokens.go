@@ -0,0 +1,75 @@
+package mapstorage
+
+import (
+	"blog/internal/microblog/storage"
+	"bytes"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *mapStorage) AddApiToken(_ context.Context, token *storage.ApiToken) error {
+	token.Id = primitive.NewObjectID().Hex()
+
+	m.apiTokensMu.Lock()
+	defer m.apiTokensMu.Unlock()
+
+	m.apiTokens = append(m.apiTokens, *token)
+
+	return nil
+}
+
+func (m *mapStorage) GetApiTokenByHash(_ context.Context, tokenHash []byte) (*storage.ApiToken, error) {
+	m.apiTokensMu.RLock()
+	defer m.apiTokensMu.RUnlock()
+
+	for _, token := range m.apiTokens {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			return &token, nil
+		}
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+func (m *mapStorage) ListApiTokens(_ context.Context, userId string) ([]storage.ApiToken, error) {
+	m.apiTokensMu.RLock()
+	defer m.apiTokensMu.RUnlock()
+
+	tokens := make([]storage.ApiToken, 0)
+	for _, token := range m.apiTokens {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (m *mapStorage) DeleteApiToken(_ context.Context, userId string, id string) error {
+	m.apiTokensMu.Lock()
+	defer m.apiTokensMu.Unlock()
+
+	for i, token := range m.apiTokens {
+		if token.Id == id && token.UserId == userId {
+			m.apiTokens = append(m.apiTokens[:i], m.apiTokens[i+1:]...)
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+func (m *mapStorage) TouchApiToken(_ context.Context, id string, at time.Time) error {
+	m.apiTokensMu.Lock()
+	defer m.apiTokensMu.Unlock()
+
+	for i := range m.apiTokens {
+		if m.apiTokens[i].Id == id {
+			m.apiTokens[i].LastUsedAt = at
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package mapstorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"sort"
+	"time"
+)
+
+func (m *mapStorage) GetLoginAttempts(_ context.Context, key string) (*storage.LoginAttempts, error) {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok {
+		attempts.Key = key
+	}
+
+	return &attempts, nil
+}
+
+func (m *mapStorage) AddLoginFailure(_ context.Context, key string, at time.Time) (*storage.LoginAttempts, error) {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+
+	attempts := m.attempts[key]
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	m.attempts[key] = attempts
+
+	return &attempts, nil
+}
+
+func (m *mapStorage) BlockLogin(_ context.Context, key string, until time.Time, lockedOut bool) error {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+
+	if attempts, ok := m.attempts[key]; ok {
+		attempts.BlockedUntil = until
+		attempts.LockedOut = lockedOut
+		m.attempts[key] = attempts
+	}
+
+	return nil
+}
+
+func (m *mapStorage) ResetLoginAttempts(_ context.Context, key string) error {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+
+	delete(m.attempts, key)
+
+	return nil
+}
+
+func (m *mapStorage) GetLockouts(_ context.Context, now time.Time) ([]storage.LoginAttempts, error) {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+
+	lockouts := make([]storage.LoginAttempts, 0)
+	for _, attempts := range m.attempts {
+		if attempts.LockedOut && attempts.BlockedUntil.After(now) {
+			lockouts = append(lockouts, attempts)
+		}
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].BlockedUntil.After(lockouts[j].BlockedUntil)
+	})
+
+	return lockouts, nil
+}
@@ -2,121 +2,379 @@ package mapstorage
 
 import (
 	"blog/internal/microblog/storage"
-	"errors"
-	"strconv"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// In-memory storage, ids are generated the same way as in mongo so clients can't tell the difference
 type mapStorage struct {
 	usersMu sync.RWMutex
-	usersId int
 	users   []storage.User
 	postsMu sync.RWMutex
-	postsId int
-	posts   []storage.Post
+	// Sorted by id, new posts are appended to the end
+	posts      []storage.Post
+	attemptsMu sync.Mutex
+	attempts   map[string]storage.LoginAttempts
+	// Guards password resets too
+	sessionsMu     sync.RWMutex
+	sessions       map[string]storage.Session
+	passwordResets map[string]storage.PasswordReset
+	apiTokensMu    sync.RWMutex
+	apiTokens      []storage.ApiToken
+	oauthMu        sync.RWMutex
+	oauthClients   map[string]storage.OAuthClient
+	oauthCodes     map[string]storage.OAuthCode
+	mediaMu        sync.RWMutex
+	media          map[string]storage.Media
 }
 
-func (m *mapStorage) AddPost(post *storage.Post) error {
+func NewMapStorage() storage.Storage {
+	return &mapStorage{
+		users:    make([]storage.User, 0),
+		posts:    make([]storage.Post, 0),
+		attempts: make(map[string]storage.LoginAttempts),
+
+		sessions:       make(map[string]storage.Session),
+		passwordResets: make(map[string]storage.PasswordReset),
+		apiTokens:      make([]storage.ApiToken, 0),
+		oauthClients:   make(map[string]storage.OAuthClient),
+		oauthCodes:     make(map[string]storage.OAuthCode),
+		media:          make(map[string]storage.Media),
+	}
+}
+
+func (m *mapStorage) AddPost(_ context.Context, post *storage.Post) error {
+	id := primitive.NewObjectID()
+	post.Id = id.Hex()
+	post.Time = id.Timestamp().UTC().Format(time.RFC3339)
+
 	m.postsMu.Lock()
-	post.Id = strconv.Itoa(m.postsId)
+	defer m.postsMu.Unlock()
+
 	m.posts = append(m.posts, *post)
-	m.postsId++
-	m.postsMu.Unlock()
 
 	return nil
 }
 
-func (m *mapStorage) AddUser(newUser *storage.User) error {
-	m.usersMu.Lock() // TODO: lock guard? Normal usage of mutex
+func (m *mapStorage) AddUser(_ context.Context, newUser *storage.User) error {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
 
 	for _, user := range m.users {
-		if user.Login == newUser.Login {
-			m.usersMu.Unlock()
-			return errors.New("user with this login already exist")
+		if strings.EqualFold(user.Login, newUser.Login) {
+			return &storage.ConflictError{Entity: "user", Field: "login", Value: newUser.Login}
 		}
 	}
-	newUser.Id = strconv.Itoa(m.usersId) // TODO: id not string
-	m.usersId++
+
+	newUser.Id = primitive.NewObjectID().Hex()
 	m.users = append(m.users, *newUser)
-	m.usersMu.Unlock()
 
 	return nil
 }
 
-func (m *mapStorage) GetPost(id string) (storage.Post, error) {
+func (m *mapStorage) GetPost(_ context.Context, postIdBase64 string) (*storage.Post, error) {
+	postId, err := base64.URLEncoding.DecodeString(postIdBase64)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't decode this id, id: %s - %w", postIdBase64, err)
+	}
+
 	m.postsMu.RLock()
+	defer m.postsMu.RUnlock()
+
 	for _, p := range m.posts {
-		if p.Id == id {
-			m.postsMu.RUnlock()
-			return p, nil
+		if p.Id == string(postId) {
+			return &p, nil
 		}
 	}
-	m.postsMu.RUnlock()
 
-	return *storage.NewPost(), errors.New("not found")
+	return nil, storage.ErrNotFound
 }
 
-func (m *mapStorage) GetUser(id string) error {
+func (m *mapStorage) DeletePost(_ context.Context, postIdBase64 string) (*storage.Post, error) {
+	postId, err := base64.URLEncoding.DecodeString(postIdBase64)
+
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	m.postsMu.Lock()
+	defer m.postsMu.Unlock()
+
+	for i, p := range m.posts {
+		if p.Id == string(postId) {
+			m.posts = append(m.posts[:i], m.posts[i+1:]...)
+			return &p, nil
+		}
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+func (m *mapStorage) GetUserByLogin(_ context.Context, login string) (*storage.User, error) {
 	m.usersMu.RLock()
+	defer m.usersMu.RUnlock()
+
 	for _, user := range m.users {
-		if user.Id == id {
-			m.usersMu.RUnlock()
+		if strings.EqualFold(user.Login, login) {
+			return &user, nil
 		}
 	}
-	m.usersMu.RUnlock()
-	return nil
-}
 
-func (m *mapStorage) ValidateUser(string, string) error {
-	return nil
+	return nil, storage.ErrNotFound
 }
 
-func NewMapStorage() *mapStorage {
-	return &mapStorage{
-		sync.RWMutex{},
-		0,
-		make([]storage.User, 0),
-		sync.RWMutex{},
-		0,
-		make([]storage.Post, 0)}
+func (m *mapStorage) GetUserById(_ context.Context, id string) (*storage.User, error) {
+	m.usersMu.RLock()
+	defer m.usersMu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Id == id {
+			return &user, nil
+		}
+	}
+
+	return nil, storage.ErrNotFound
 }
 
-func (m *mapStorage) GetUserByLogin(targetLogin string) (storage.User, error) {
-	m.postsMu.RLock()
+func (m *mapStorage) ListUsers(_ context.Context, afterId string, size int) ([]storage.User, error) {
+	m.usersMu.RLock()
+	defer m.usersMu.RUnlock()
+
+	// Ids are increasing, so users are already ordered by id
+	users := make([]storage.User, 0)
 	for _, user := range m.users {
-		if user.Login == targetLogin {
-			return user, nil
+		if user.Id > afterId && (size <= 0 || len(users) < size) {
+			users = append(users, user)
 		}
 	}
-	m.postsMu.RUnlock()
 
-	return storage.User{"", "", make([]byte, 0)}, nil // return pointer?
+	return users, nil
 }
 
-// TODO: normal interface what is GetUser?
-func (m *mapStorage) GetUserById(targetId string) (storage.User, error) {
+func (m *mapStorage) SearchUsers(_ context.Context, prefix string, afterId string, size int) ([]storage.User, error) {
 	m.usersMu.RLock()
+	defer m.usersMu.RUnlock()
+
+	users := make([]storage.User, 0)
 	for _, user := range m.users {
-		if string(user.Id) == targetId {
-			m.usersMu.RUnlock()
-			return user, nil
+		if len(users) == size {
+			break
+		}
+
+		if user.Id <= afterId {
+			continue
+		}
+
+		for _, name := range storage.SearchNames(user.Login, &user.Profile) {
+			if strings.HasPrefix(name, prefix) {
+				users = append(users, user)
+				break
+			}
 		}
 	}
-	m.usersMu.RUnlock()
 
-	return storage.User{"", "", make([]byte, 0)}, nil // return pointer?
+	return users, nil
+}
+
+func (m *mapStorage) updateUser(userId string, update func(*storage.User)) error {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].Id == userId {
+			update(&m.users[i])
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+func (m *mapStorage) SetUserRole(_ context.Context, userId string, role string) error {
+	return m.updateUser(userId, func(user *storage.User) { user.Role = role })
+}
+
+func (m *mapStorage) SetUserSuspended(_ context.Context, userId string, suspended bool) error {
+	return m.updateUser(userId, func(user *storage.User) { user.Suspended = suspended })
+}
+
+func (m *mapStorage) UpdateProfile(_ context.Context, userId string, profile *storage.Profile) error {
+	return m.updateUser(userId, func(user *storage.User) { user.Profile = *profile })
 }
 
-func (m *mapStorage) GetAllPostsOfUser(id string) ([]storage.Post, error) {
+func (m *mapStorage) UpdatePasswordHash(_ context.Context, userId string, hash []byte, version int) error {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].Id == userId {
+			m.users[i].PasswordHash = hash
+			m.users[i].PasswordHashVersion = version
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+// Totp of stored users is replaced, never changed in place, since returned users share it
+func (m *mapStorage) updateTotp(userId string, update func(*storage.Totp) error) error {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].Id != userId {
+			continue
+		}
+
+		if m.users[i].Totp == nil {
+			return storage.ErrNotFound
+		}
+
+		totp := *m.users[i].Totp
+		if err := update(&totp); err != nil {
+			return err
+		}
+
+		m.users[i].Totp = &totp
+		return nil
+	}
+
+	return storage.ErrNotFound
+}
+
+func (m *mapStorage) UpdateTotp(_ context.Context, userId string, totp *storage.Totp) error {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].Id == userId {
+			if totp != nil {
+				copied := *totp
+				totp = &copied
+			}
+
+			m.users[i].Totp = totp
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+func (m *mapStorage) UseTotpStep(_ context.Context, userId string, step int64) error {
+	return m.updateTotp(userId, func(totp *storage.Totp) error {
+		if !totp.Enabled || totp.LastStep >= step {
+			return storage.ErrNotFound
+		}
+
+		totp.LastStep = step
+		return nil
+	})
+}
+
+func (m *mapStorage) UseRecoveryCode(_ context.Context, userId string, codeHash []byte) error {
+	return m.updateTotp(userId, func(totp *storage.Totp) error {
+		codes := make([][]byte, 0, len(totp.RecoveryCodes))
+		found := false
+
+		for _, code := range totp.RecoveryCodes {
+			if !found && bytes.Equal(code, codeHash) {
+				found = true
+				continue
+			}
+			codes = append(codes, code)
+		}
+
+		if !found {
+			return storage.ErrNotFound
+		}
+
+		totp.RecoveryCodes = codes
+		return nil
+	})
+}
+
+func (m *mapStorage) GetPostsFrom(_ context.Context, postIdBase64 string, authorId string, size int) ([]storage.Post, string, error) {
+	postIdHex, err := base64.URLEncoding.DecodeString(postIdBase64)
+
+	if err != nil {
+		return make([]storage.Post, 0), "", fmt.Errorf("can't decode postId: %w", err)
+	}
+
+	postId, err := primitive.ObjectIDFromHex(string(postIdHex))
+
+	if err != nil {
+		return make([]storage.Post, 0), "", fmt.Errorf("can't decode postId: %w", err)
+	}
+
+	return m.getPosts(authorId, &postId, size)
+}
+
+func (m *mapStorage) GetFirstPosts(_ context.Context, userId string, size int) ([]storage.Post, string, error) {
+	return m.getPosts(userId, nil, size)
+}
+
+// Newest posts of author with id <= from, and token of the page after them
+func (m *mapStorage) getPosts(authorId string, from *primitive.ObjectID, size int) ([]storage.Post, string, error) {
+	if _, err := primitive.ObjectIDFromHex(authorId); err != nil {
+		return make([]storage.Post, 0), "", fmt.Errorf("can't decode authorId: %w", err)
+	}
+
 	m.postsMu.RLock()
-	posts := make([]storage.Post, 0)
+	defer m.postsMu.RUnlock()
 
+	posts := make([]storage.Post, 0)
 	for i := len(m.posts) - 1; i >= 0; i-- {
-		if m.posts[i].AuthorId == id { //TODO: id from string
-			posts = append(posts, m.posts[i])
+		p := m.posts[i]
+
+		if p.AuthorId != authorId {
+			continue
+		}
+
+		if from != nil {
+			id, _ := primitive.ObjectIDFromHex(p.Id)
+
+			if bytes.Compare(id[:], from[:]) > 0 {
+				continue
+			}
 		}
+
+		// Zero size means no limit like in mongo
+		if size > 0 && len(posts) == size {
+			return posts, base64.URLEncoding.EncodeToString([]byte(p.Id)), nil
+		}
+
+		posts = append(posts, p)
 	}
+
+	return posts, "", nil
+}
+
+func (m *mapStorage) Stats(context.Context) (*storage.Stats, error) {
+	m.usersMu.RLock()
+	users := len(m.users)
+	m.usersMu.RUnlock()
+
+	m.postsMu.RLock()
+	posts := len(m.posts)
 	m.postsMu.RUnlock()
 
-	return posts, nil
+	return &storage.Stats{Users: int64(users), Posts: int64(posts)}, nil
+}
+
+func (m *mapStorage) Ping(context.Context) error {
+	return nil
+}
+
+func (m *mapStorage) Close(context.Context) error {
+	return nil
 }
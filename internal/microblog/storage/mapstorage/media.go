@@ -0,0 +1,27 @@
+package mapstorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+)
+
+func (m *mapStorage) AddMedia(_ context.Context, media *storage.Media) error {
+	m.mediaMu.Lock()
+	defer m.mediaMu.Unlock()
+
+	m.media[media.Id] = *media
+
+	return nil
+}
+
+func (m *mapStorage) GetMedia(_ context.Context, id string) (*storage.Media, error) {
+	m.mediaMu.RLock()
+	defer m.mediaMu.RUnlock()
+
+	media, ok := m.media[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+
+	return &media, nil
+}
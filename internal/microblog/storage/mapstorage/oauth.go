@@ -0,0 +1,52 @@
+package mapstorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"time"
+)
+
+func (m *mapStorage) AddOAuthClient(_ context.Context, client *storage.OAuthClient) error {
+	m.oauthMu.Lock()
+	defer m.oauthMu.Unlock()
+
+	m.oauthClients[client.Id] = *client
+
+	return nil
+}
+
+func (m *mapStorage) GetOAuthClient(_ context.Context, id string) (*storage.OAuthClient, error) {
+	m.oauthMu.RLock()
+	defer m.oauthMu.RUnlock()
+
+	client, ok := m.oauthClients[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+
+	return &client, nil
+}
+
+func (m *mapStorage) AddOAuthCode(_ context.Context, code *storage.OAuthCode) error {
+	m.oauthMu.Lock()
+	defer m.oauthMu.Unlock()
+
+	m.oauthCodes[string(code.CodeHash)] = *code
+
+	return nil
+}
+
+func (m *mapStorage) UseOAuthCode(_ context.Context, codeHash []byte, now time.Time) (*storage.OAuthCode, error) {
+	m.oauthMu.Lock()
+	defer m.oauthMu.Unlock()
+
+	code, ok := m.oauthCodes[string(codeHash)]
+	if !ok || code.Used || !code.ExpiresAt.After(now) {
+		return nil, storage.ErrNotFound
+	}
+
+	code.Used = true
+	m.oauthCodes[string(codeHash)] = code
+
+	return &code, nil
+}
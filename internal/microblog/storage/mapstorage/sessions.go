@@ -0,0 +1,78 @@
+package mapstorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"time"
+)
+
+func (m *mapStorage) AddSession(_ context.Context, session *storage.Session) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	m.sessions[session.Id] = *session
+
+	return nil
+}
+
+func (m *mapStorage) GetSession(_ context.Context, id string) (*storage.Session, error) {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+
+	return &session, nil
+}
+
+func (m *mapStorage) RevokeSessions(_ context.Context, userId string, exceptId string) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserId == userId && id != exceptId {
+			session.Revoked = true
+			m.sessions[id] = session
+		}
+	}
+
+	return nil
+}
+
+func (m *mapStorage) AddPasswordReset(_ context.Context, reset *storage.PasswordReset) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	m.passwordResets[string(reset.TokenHash)] = *reset
+
+	return nil
+}
+
+func (m *mapStorage) GetPasswordReset(_ context.Context, tokenHash []byte, now time.Time) (*storage.PasswordReset, error) {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+
+	reset, ok := m.passwordResets[string(tokenHash)]
+	if !ok || reset.Used || !reset.ExpiresAt.After(now) {
+		return nil, storage.ErrNotFound
+	}
+
+	return &reset, nil
+}
+
+func (m *mapStorage) UsePasswordReset(_ context.Context, tokenHash []byte, now time.Time) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	reset, ok := m.passwordResets[string(tokenHash)]
+	if !ok || reset.Used || !reset.ExpiresAt.After(now) {
+		return storage.ErrNotFound
+	}
+
+	reset.Used = true
+	m.passwordResets[string(tokenHash)] = reset
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package storage
+
+// Uploaded image, its bytes are kept in blob store under Key and ThumbnailKey
+type Media struct {
+	Id          string `bson:"_id,omitempty"`
+	OwnerId     string `bson:"ownerId"`
+	ContentType string `bson:"contentType"`
+	// Size of stored image in bytes, it differs from uploaded one since metadata is stripped
+	Size                 int    `bson:"size"`
+	Width                int    `bson:"width"`
+	Height               int    `bson:"height"`
+	Key                  string `bson:"key"`
+	ThumbnailKey         string `bson:"thumbnailKey"`
+	ThumbnailContentType string `bson:"thumbnailContentType"`
+}
@@ -0,0 +1,90 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiTokensCollection = "api_tokens"
+
+func (s *mongoStorage) AddApiToken(ctx context.Context, token *storage.ApiToken) error {
+	id, err := s.apiTokens.InsertOne(ctx, token)
+
+	if err != nil {
+		return fmt.Errorf("can't insert api token - %w", err)
+	}
+
+	token.Id = id.InsertedID.(primitive.ObjectID).Hex()
+
+	return nil
+}
+
+func (s *mongoStorage) GetApiTokenByHash(ctx context.Context, tokenHash []byte) (*storage.ApiToken, error) {
+	var token storage.ApiToken
+	err := s.apiTokens.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&token)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find api token - %w", err)
+	}
+
+	return &token, nil
+}
+
+func (s *mongoStorage) ListApiTokens(ctx context.Context, userId string) ([]storage.ApiToken, error) {
+	cur, err := s.apiTokens.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.M{"_id": 1}))
+
+	if err != nil {
+		return nil, fmt.Errorf("can't find api tokens of user %s - %w", userId, err)
+	}
+
+	tokens := make([]storage.ApiToken, 0)
+	if err := cur.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (s *mongoStorage) DeleteApiToken(ctx context.Context, userId string, id string) error {
+	objId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	res, err := s.apiTokens.DeleteOne(ctx, bson.M{"_id": objId, "userId": userId})
+
+	if err != nil {
+		return fmt.Errorf("can't delete api token %s - %w", id, err)
+	}
+
+	if res.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) TouchApiToken(ctx context.Context, id string, at time.Time) error {
+	objId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return fmt.Errorf("bad api token id - %w", err)
+	}
+
+	if _, err := s.apiTokens.UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"lastUsedAt": at}}); err != nil {
+		return fmt.Errorf("can't touch api token %s - %w", id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const auditCollection = "audit_log"
+
+// Unique index on seq makes concurrent appends of replicas after the same entry fail, so chain stays linear
+type auditStore struct {
+	entries *mongo.Collection
+}
+
+// NewAuditStore shares connection pool of s, which must be created by NewMongoStorage
+func NewAuditStore(s storage.Storage) (audit.Store, error) {
+	ms, ok := s.(*mongoStorage)
+
+	if !ok {
+		return nil, errors.New("audit store requires mongo storage")
+	}
+
+	return &auditStore{entries: ms.client.Database(dbName).Collection(auditCollection)}, nil
+}
+
+func (a *auditStore) Last(ctx context.Context) (*audit.Entry, error) {
+	var last audit.Entry
+	err := a.entries.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"seq": -1})).Decode(&last)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find last audit entry - %w", err)
+	}
+
+	return &last, nil
+}
+
+func (a *auditStore) Append(ctx context.Context, e *audit.Entry) error {
+	_, err := a.entries.InsertOne(ctx, e)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return audit.ErrSeqTaken
+	} else if err != nil {
+		return fmt.Errorf("can't insert audit entry - %w", err)
+	}
+
+	return nil
+}
+
+func (a *auditStore) Find(ctx context.Context, f audit.Filter, size int) ([]audit.Entry, error) {
+	filter := bson.M{}
+	if f.Actor != "" {
+		filter["actor"] = f.Actor
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+
+	timeRange := bson.M{}
+	if !f.From.IsZero() {
+		timeRange["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timeRange["$lte"] = f.To
+	}
+	if len(timeRange) != 0 {
+		filter["time"] = timeRange
+	}
+
+	if f.BeforeSeq != 0 {
+		filter["seq"] = bson.M{"$lt": f.BeforeSeq}
+	}
+
+	return a.find(ctx, filter, options.Find().SetSort(bson.M{"seq": -1}).SetLimit(int64(size)))
+}
+
+func (a *auditStore) Scan(ctx context.Context, afterSeq int64, size int) ([]audit.Entry, error) {
+	opts := options.Find().SetSort(bson.M{"seq": 1}).SetLimit(int64(size))
+
+	return a.find(ctx, bson.M{"seq": bson.M{"$gt": afterSeq}}, opts)
+}
+
+func (a *auditStore) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]audit.Entry, error) {
+	cur, err := a.entries.Find(ctx, filter, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't find audit entries - %w", err)
+	}
+
+	entries := make([]audit.Entry, 0)
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor - %w", err)
+	}
+
+	return entries, nil
+}
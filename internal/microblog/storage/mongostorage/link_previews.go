@@ -0,0 +1,56 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/preview"
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const linkPreviewsCollection = "link_previews"
+
+// Previews are keyed by normalized url, so replicas share fetched pages
+type previewStore struct {
+	previews *mongo.Collection
+}
+
+// NewPreviewStore shares connection pool of s, which must be created by NewMongoStorage
+func NewPreviewStore(s storage.Storage) (preview.Store, error) {
+	ms, ok := s.(*mongoStorage)
+
+	if !ok {
+		return nil, errors.New("preview store requires mongo storage")
+	}
+
+	return &previewStore{previews: ms.client.Database(dbName).Collection(linkPreviewsCollection)}, nil
+}
+
+func (p *previewStore) Find(ctx context.Context, urls []string) ([]preview.Preview, error) {
+	cur, err := p.previews.Find(ctx, bson.M{"_id": bson.M{"$in": urls}})
+
+	if err != nil {
+		return nil, fmt.Errorf("can't find link previews - %w", err)
+	}
+
+	previews := make([]preview.Preview, 0, len(urls))
+	if err := cur.All(ctx, &previews); err != nil {
+		return nil, fmt.Errorf("can't decode link previews - %w", err)
+	}
+
+	return previews, nil
+}
+
+func (p *previewStore) Save(ctx context.Context, pr *preview.Preview) error {
+	_, err := p.previews.ReplaceOne(ctx, bson.M{"_id": pr.Url}, pr, options.Replace().SetUpsert(true))
+
+	if err != nil {
+		return fmt.Errorf("can't save link preview - %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	loginAttemptsCollection = "login_attempts"
+	// Forgotten attempts expire, lockouts must be shorter than this
+	loginAttemptsTTL = 24 * time.Hour
+)
+
+func (s *mongoStorage) GetLoginAttempts(ctx context.Context, key string) (*storage.LoginAttempts, error) {
+	var attempts storage.LoginAttempts
+	err := s.loginAttempts.FindOne(ctx, bson.M{"_id": key}).Decode(&attempts)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &storage.LoginAttempts{Key: key}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find login attempts of %s - %w", key, err)
+	}
+
+	return &attempts, nil
+}
+
+func (s *mongoStorage) AddLoginFailure(ctx context.Context, key string, at time.Time) (*storage.LoginAttempts, error) {
+	var attempts storage.LoginAttempts
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.loginAttempts.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"lastFailureAt": at}},
+		opts).Decode(&attempts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't add login failure of %s - %w", key, err)
+	}
+
+	return &attempts, nil
+}
+
+func (s *mongoStorage) BlockLogin(ctx context.Context, key string, until time.Time, lockedOut bool) error {
+	_, err := s.loginAttempts.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"blockedUntil": until, "lockedOut": lockedOut}})
+
+	if err != nil {
+		return fmt.Errorf("can't block login attempts of %s - %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	if _, err := s.loginAttempts.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("can't reset login attempts of %s - %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) GetLockouts(ctx context.Context, now time.Time) ([]storage.LoginAttempts, error) {
+	opts := options.Find().SetSort(bson.M{"blockedUntil": -1})
+	cur, err := s.loginAttempts.Find(ctx, bson.M{"lockedOut": true, "blockedUntil": bson.M{"$gt": now}}, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't find lockouts - %w", err)
+	}
+
+	lockouts := make([]storage.LoginAttempts, 0)
+	if err := cur.All(ctx, &lockouts); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor: %w", err)
+	}
+
+	return lockouts, nil
+}
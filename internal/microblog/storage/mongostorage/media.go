@@ -0,0 +1,34 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const mediaCollection = "media"
+
+func (s *mongoStorage) AddMedia(ctx context.Context, media *storage.Media) error {
+	if _, err := s.media.InsertOne(ctx, media); err != nil {
+		return fmt.Errorf("can't insert media - %w", err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) GetMedia(ctx context.Context, id string) (*storage.Media, error) {
+	var media storage.Media
+	err := s.media.FindOne(ctx, bson.M{"_id": id}).Decode(&media)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find media %s - %w", id, err)
+	}
+
+	return &media, nil
+}
@@ -0,0 +1,520 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/audit"
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection     = "schema_migrations"
+	migrationsLockCollection = "schema_migrations_lock"
+	migrationsLockId         = "lock"
+
+	// Lock expires by itself if replica died in the middle of migration
+	migrationsLockTTL = 5 * time.Minute
+	lockRetryInterval = time.Second
+	// Lock is extended while migrations run, so long index builds don't lose it
+	lockRenewInterval = migrationsLockTTL / 5
+
+	indexNotFoundCode     = 27
+	namespaceNotFoundCode = 26
+)
+
+var errLockLost = errors.New("migrations lock was taken by another owner")
+
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// Append only. Applied migrations must never be changed or reordered
+var migrations = []migration{
+	{version: 1, description: "index posts by author", up: createPostsAuthorIndex},
+	{version: 2, description: "unique case-insensitive login index", up: createUniqueLoginIndex},
+	{version: 3, description: "expire rate limit buckets", up: createRateLimitsTTLIndex},
+	{version: 4, description: "expire login attempts, index audit log by time", up: createLoginAttemptsAndAuditIndexes},
+	{version: 5, description: "index sessions by user, expire sessions and password resets", up: createSessionIndexes},
+	{version: 6, description: "index api tokens by hash and user", up: createApiTokenIndexes},
+	{version: 7, description: "expire oauth authorization codes", up: createOAuthCodesTTLIndex},
+	{version: 8, description: "chain audit log entries by hash, index them by actor and action", up: chainAuditLog},
+	{version: 9, description: "index users by search names", up: createUserSearchIndex},
+	{version: 10, description: "full-text index of posts, index posts by hashtags", up: createPostSearchIndexes},
+}
+
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type Migrator struct {
+	db         *mongo.Database
+	owner      string
+	migrations []migration
+}
+
+func NewMigrator(db *mongo.Database) *Migrator {
+	host, _ := os.Hostname()
+
+	return &Migrator{
+		db:         db,
+		owner:      fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		migrations: migrations,
+	}
+}
+
+// Status of every known migration in order of versions
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	cur, err := m.db.Collection(migrationsCollection).Find(ctx, bson.M{})
+
+	if err != nil {
+		return nil, fmt.Errorf("can't read applied migrations - %w", err)
+	}
+
+	var applied []appliedMigration
+	if err := cur.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor: %w", err)
+	}
+
+	appliedAt := make(map[int]time.Time, len(applied))
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status := MigrationStatus{Version: mig.version, Description: mig.description}
+
+		if t, ok := appliedAt[mig.version]; ok {
+			status.AppliedAt = &t
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Pending migrations in the order they will be applied
+func (m *Migrator) Pending(ctx context.Context) ([]MigrationStatus, error) {
+	statuses, err := m.Status(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]MigrationStatus, 0)
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies pending migrations under the cluster wide lock and returns them.
+// With dryRun nothing is changed, the migrations which would be applied are returned.
+func (m *Migrator) Migrate(ctx context.Context, dryRun bool) ([]MigrationStatus, error) {
+	if dryRun {
+		return m.Pending(ctx)
+	}
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.unlock()
+
+	ctx, abort := context.WithCancelCause(ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		m.heartbeat(ctx, abort)
+	}()
+	defer func() {
+		abort(nil)
+		<-heartbeatDone
+	}()
+
+	// Other replica could have applied everything while we were waiting for the lock
+	pending, err := m.Pending(ctx)
+
+	if err != nil {
+		return nil, lockLostOr(ctx, err)
+	}
+
+	applied := make([]MigrationStatus, 0, len(pending))
+	for _, status := range pending {
+		mig := m.findMigration(status.Version)
+
+		if err := mig.up(ctx, m.db); err != nil {
+			return applied, fmt.Errorf("can't apply migration %d (%s) - %w", mig.version, mig.description, lockLostOr(ctx, err))
+		}
+
+		// Don't record migration if the lock was lost in the middle of it
+		if err := context.Cause(ctx); err != nil {
+			return applied, fmt.Errorf("can't apply migration %d (%s) - %w", mig.version, mig.description, err)
+		}
+
+		record := appliedMigration{Version: mig.version, Description: mig.description, AppliedAt: time.Now().UTC()}
+		if _, err := m.db.Collection(migrationsCollection).InsertOne(ctx, record); err != nil {
+			return applied, fmt.Errorf("can't record migration %d - %w", mig.version, lockLostOr(ctx, err))
+		}
+
+		status.AppliedAt = &record.AppliedAt
+		applied = append(applied, status)
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) lock(ctx context.Context) error {
+	locks := m.db.Collection(migrationsLockCollection)
+
+	for {
+		now := time.Now().UTC()
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": migrationsLockId, "expiresAt": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": m.owner, "expiresAt": now.Add(migrationsLockTTL)}},
+			options.Update().SetUpsert(true))
+
+		if err == nil {
+			return nil
+		} else if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("can't acquire migrations lock - %w", err)
+		}
+
+		// Lock is held by someone else and not expired yet
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("can't acquire migrations lock - %w", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Extends the lock until ctx is done, aborts migration if the lock isn't ours anymore
+func (m *Migrator) heartbeat(ctx context.Context, abort context.CancelCauseFunc) {
+	locks := m.db.Collection(migrationsLockCollection)
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res, err := locks.UpdateOne(ctx,
+			bson.M{"_id": migrationsLockId, "owner": m.owner},
+			bson.M{"$set": bson.M{"expiresAt": time.Now().UTC().Add(migrationsLockTTL)}})
+
+		if err != nil {
+			// Lock is still valid until it expires, try again on the next tick
+			continue
+		}
+
+		if res.MatchedCount == 0 {
+			abort(errLockLost)
+			return
+		}
+	}
+}
+
+// Error caused by lost lock is reported instead of the context cancellation it led to
+func lockLostOr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), errLockLost) {
+		return errLockLost
+	}
+
+	return err
+}
+
+func (m *Migrator) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m.db.Collection(migrationsLockCollection).DeleteOne(ctx, bson.M{"_id": migrationsLockId, "owner": m.owner})
+}
+
+func (m *Migrator) findMigration(version int) migration {
+	for _, mig := range m.migrations {
+		if mig.version == version {
+			return mig
+		}
+	}
+
+	panic(fmt.Sprintf("unknown migration %d", version))
+}
+
+func createPostsAuthorIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(postsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "authorId", Value: 1}}})
+
+	return err
+}
+
+func createUniqueLoginIndex(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection(usersCollection)
+	duplicates, err := findDuplicateLogins(ctx, users)
+
+	if err != nil {
+		return err
+	}
+
+	if len(duplicates) != 0 {
+		return errors.New("duplicated logins must be resolved by hand: " + strings.Join(duplicates, ", "))
+	}
+
+	// Old non-unique index from times before migrations, fresh databases have neither index nor collection
+	if _, err := users.Indexes().DropOne(ctx, "login_1"); err != nil && !isNotFound(err) {
+		return fmt.Errorf("can't drop old login index - %w", err)
+	}
+
+	_, err = users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "login", Value: 1}},
+		Options: options.Index().SetName(loginIndexName).SetUnique(true).SetCollation(loginCollation),
+	})
+
+	return err
+}
+
+func isNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+
+	return errors.As(err, &cmdErr) && (cmdErr.HasErrorCode(indexNotFoundCode) || cmdErr.HasErrorCode(namespaceNotFoundCode))
+}
+
+func createRateLimitsTTLIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(rateLimitsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func createLoginAttemptsAndAuditIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(loginAttemptsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "lastFailureAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(loginAttemptsTTL.Seconds())),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(auditCollection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "time", Value: -1}}})
+
+	return err
+}
+
+func createSessionIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(sessionsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(passwordResetsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func createApiTokenIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(apiTokensCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+
+	return err
+}
+
+func createOAuthCodesTTLIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(oauthCodesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+// Entries written before chaining are chained in order of time
+func chainAuditLog(ctx context.Context, db *mongo.Database) error {
+	entries := db.Collection(auditCollection)
+	cur, err := entries.Find(ctx, bson.M{"seq": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}))
+
+	if err != nil {
+		return fmt.Errorf("can't find audit entries - %w", err)
+	}
+	defer cur.Close(ctx)
+
+	var prev *audit.Entry
+	for cur.Next(ctx) {
+		var doc struct {
+			Id          primitive.ObjectID `bson:"_id"`
+			audit.Entry `bson:",inline"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return fmt.Errorf("can't decode audit entry - %w", err)
+		}
+
+		audit.Link(prev, &doc.Entry)
+		_, err := entries.UpdateOne(ctx, bson.M{"_id": doc.Id},
+			bson.M{"$set": bson.M{"seq": doc.Seq, "prevHash": doc.PrevHash, "hash": doc.Hash}})
+
+		if err != nil {
+			return fmt.Errorf("can't chain audit entry - %w", err)
+		}
+
+		prev = &doc.Entry
+	}
+
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("can't read audit entries - %w", err)
+	}
+
+	// Replicas of previous version may still write entries without seq during rollout
+	_, err = entries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"seq": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "seq", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "seq", Value: -1}}},
+	})
+
+	return err
+}
+
+// Users registered before search have only login and profile, their search names are computed here
+func createUserSearchIndex(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection(usersCollection)
+	cur, err := users.Find(ctx, bson.M{"searchNames": bson.M{"$exists": false}})
+
+	if err != nil {
+		return fmt.Errorf("can't find users - %w", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var user storage.User
+		if err := cur.Decode(&user); err != nil {
+			return fmt.Errorf("can't decode user - %w", err)
+		}
+
+		objId, _ := primitive.ObjectIDFromHex(user.Id)
+		_, err := users.UpdateOne(ctx, bson.M{"_id": objId},
+			bson.M{"$set": bson.M{"searchNames": storage.SearchNames(user.Login, &user.Profile)}})
+
+		if err != nil {
+			return fmt.Errorf("can't set search names of user %s - %w", user.Id, err)
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("can't read users - %w", err)
+	}
+
+	_, err = users.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "searchNames", Value: 1}}})
+
+	return err
+}
+
+// Posts written before search get hashtags here, text index is language neutral as posts are in any language
+func createPostSearchIndexes(ctx context.Context, db *mongo.Database) error {
+	posts := db.Collection(postsCollection)
+	cur, err := posts.Find(ctx, bson.M{"text": bson.M{"$regex": "#"}, "hashtags": bson.M{"$exists": false}})
+
+	if err != nil {
+		return fmt.Errorf("can't find posts - %w", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var post struct {
+			Id   primitive.ObjectID `bson:"_id"`
+			Text string             `bson:"text"`
+		}
+		if err := cur.Decode(&post); err != nil {
+			return fmt.Errorf("can't decode post - %w", err)
+		}
+
+		hashtags := composer.Hashtags(post.Text)
+		if len(hashtags) == 0 {
+			continue
+		}
+
+		if _, err := posts.UpdateOne(ctx, bson.M{"_id": post.Id}, bson.M{"$set": bson.M{"hashtags": hashtags}}); err != nil {
+			return fmt.Errorf("can't set hashtags of post %s - %w", post.Id.Hex(), err)
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("can't read posts - %w", err)
+	}
+
+	_, err = posts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "text", Value: "text"}},
+			Options: options.Index().SetName(postsTextIndexName).SetDefaultLanguage("none"),
+		},
+		{Keys: bson.D{{Key: "hashtags", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+
+	return err
+}
+
+// Logins which are equal ignoring case, they must be resolved by hand before unique index can be built
+func findDuplicateLogins(ctx context.Context, users *mongo.Collection) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"$toLower": "$login"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cur, err := users.Aggregate(ctx, pipeline)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't search duplicated logins - %w", err)
+	}
+
+	var groups []struct {
+		Login string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor: %w", err)
+	}
+
+	duplicates := make([]string, 0, len(groups))
+	for _, g := range groups {
+		duplicates = append(duplicates, g.Login)
+	}
+
+	return duplicates, nil
+}
@@ -0,0 +1,158 @@
+package mongostorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tests of mongo need a real one, they are skipped without MONGO_URL
+func testDatabase(t *testing.T) *mongo.Database {
+	mongoUrl := os.Getenv("MONGO_URL")
+	if mongoUrl == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := connect(ctx, mongoUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database(fmt.Sprintf("blog_migrations_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	return db
+}
+
+// Migrator over fake migrations which record the order they were applied in
+func testMigrator(db *mongo.Database, calls *[]int, versions ...int) *Migrator {
+	m := NewMigrator(db)
+	m.migrations = nil
+
+	for _, v := range versions {
+		version := v
+		m.migrations = append(m.migrations, migration{
+			version:     version,
+			description: fmt.Sprintf("migration %d", version),
+			up: func(ctx context.Context, db *mongo.Database) error {
+				*calls = append(*calls, version)
+				return nil
+			},
+		})
+	}
+
+	return m
+}
+
+func versionsOf(statuses []MigrationStatus) []int {
+	versions := make([]int, 0, len(statuses))
+	for _, s := range statuses {
+		versions = append(versions, s.Version)
+	}
+
+	return versions
+}
+
+func assertVersions(t *testing.T, expected, actual []int) {
+	t.Helper()
+
+	if fmt.Sprint(expected) != fmt.Sprint(actual) {
+		t.Fatalf("expected versions %v, got %v", expected, actual)
+	}
+}
+
+func TestMigrateAppliesInOrder(t *testing.T) {
+	db := testDatabase(t)
+	var calls []int
+
+	applied, err := testMigrator(db, &calls, 1, 2, 3).Migrate(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersions(t, []int{1, 2, 3}, calls)
+	assertVersions(t, []int{1, 2, 3}, versionsOf(applied))
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	db := testDatabase(t)
+	var calls []int
+	m := testMigrator(db, &calls, 1, 2)
+
+	pending, err := m.Migrate(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersions(t, []int{1, 2}, versionsOf(pending))
+	assertVersions(t, nil, calls)
+
+	// Nothing was recorded, so both are still pending
+	pending, err = m.Pending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVersions(t, []int{1, 2}, versionsOf(pending))
+}
+
+func TestMigrateSkipsApplied(t *testing.T) {
+	db := testDatabase(t)
+	var calls []int
+
+	if _, err := testMigrator(db, &calls, 1).Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// New release adds migration 2, migration 1 must not run again
+	applied, err := testMigrator(db, &calls, 1, 2).Migrate(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersions(t, []int{1, 2}, calls)
+	assertVersions(t, []int{2}, versionsOf(applied))
+
+	applied, err = testMigrator(db, &calls, 1, 2).Migrate(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersions(t, []int{1, 2}, calls)
+	assertVersions(t, []int{}, versionsOf(applied))
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	db := testDatabase(t)
+	var calls []int
+
+	holder := testMigrator(db, &calls)
+	if err := holder.lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+
+	_, err := testMigrator(db, &calls, 1).Migrate(ctx, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected lock wait to time out, got %v", err)
+	}
+	assertVersions(t, nil, calls)
+
+	// Lock is free again after the holder releases it
+	holder.unlock()
+
+	if _, err := testMigrator(db, &calls, 1).Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	assertVersions(t, []int{1}, calls)
+}
@@ -0,0 +1,63 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	oauthClientsCollection = "oauth_clients"
+	oauthCodesCollection   = "oauth_codes"
+)
+
+func (s *mongoStorage) AddOAuthClient(ctx context.Context, client *storage.OAuthClient) error {
+	if _, err := s.oauthClients.InsertOne(ctx, client); err != nil {
+		return fmt.Errorf("can't insert oauth client - %w", err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) GetOAuthClient(ctx context.Context, id string) (*storage.OAuthClient, error) {
+	var client storage.OAuthClient
+	err := s.oauthClients.FindOne(ctx, bson.M{"_id": id}).Decode(&client)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find oauth client %s - %w", id, err)
+	}
+
+	return &client, nil
+}
+
+func (s *mongoStorage) AddOAuthCode(ctx context.Context, code *storage.OAuthCode) error {
+	if _, err := s.oauthCodes.InsertOne(ctx, code); err != nil {
+		return fmt.Errorf("can't insert oauth code - %w", err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) UseOAuthCode(ctx context.Context, codeHash []byte, now time.Time) (*storage.OAuthCode, error) {
+	var code storage.OAuthCode
+	err := s.oauthCodes.FindOneAndUpdate(ctx,
+		bson.M{"_id": codeHash, "used": false, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&code)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't use oauth code - %w", err)
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,71 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/ratelimit"
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const rateLimitsCollection = "rate_limits"
+
+// Buckets are shared by all replicas, refill is computed by mongo with its own clock
+type rateLimitStore struct {
+	buckets *mongo.Collection
+}
+
+// NewRateLimitStore shares connection pool of s, which must be created by NewMongoStorage
+func NewRateLimitStore(s storage.Storage) (ratelimit.Store, error) {
+	ms, ok := s.(*mongoStorage)
+
+	if !ok {
+		return nil, errors.New("rate limit store requires mongo storage")
+	}
+
+	return &rateLimitStore{buckets: ms.client.Database(dbName).Collection(rateLimitsCollection)}, nil
+}
+
+func (r *rateLimitStore) Take(ctx context.Context, key string, p ratelimit.Policy) (ratelimit.Result, error) {
+	burst := float64(p.Requests)
+	elapsedSeconds := bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updatedAt", "$$NOW"}}}},
+		1000,
+	}}
+	refilled := bson.M{"$min": bson.A{
+		burst,
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", burst}}, bson.M{"$multiply": bson.A{elapsedSeconds, p.Rate()}}}},
+	}}
+
+	// Stages see the result of previous ones, so refill happens before take
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updatedAt": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$tokens", 1}},
+				bson.M{"$subtract": bson.A{"$tokens", 1}},
+				"$tokens",
+			}},
+			// Full bucket is the same as absent one, TTL index removes it
+			"expiresAt": bson.M{"$add": bson.A{"$$NOW", p.Per.Milliseconds()}},
+		}}},
+	}
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := r.buckets.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("can't take rate limit token - %w", err)
+	}
+
+	return ratelimit.Result{Allowed: bucket.Allowed, Remaining: bucket.Tokens}, nil
+}
@@ -0,0 +1,155 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/search"
+	"blog/internal/microblog/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const postsTextIndexName = "posts_text"
+
+// postSearch queries posts collection with its text index, so posts are findable as soon as they are stored
+type postSearch struct {
+	posts *mongo.Collection
+}
+
+// NewPostSearch shares connection pool of s, which must be created by NewMongoStorage
+func NewPostSearch(s storage.Storage) (search.Searcher, error) {
+	ms, ok := s.(*mongoStorage)
+
+	if !ok {
+		return nil, errors.New("post search requires mongo storage")
+	}
+
+	return &postSearch{posts: ms.posts}, nil
+}
+
+func (p *postSearch) Index(context.Context, *storage.Post) error {
+	return nil
+}
+
+func (p *postSearch) Remove(context.Context, string) error {
+	return nil
+}
+
+// textSearch passes words as terms, so they are looked up in index and ranked, and quotes phrases only.
+// $text matches posts containing any of the terms, so every word is required by wordsFilter.
+func textSearch(q *search.Query) string {
+	terms := make([]string, 0, len(q.Words)+len(q.Phrases))
+	terms = append(terms, q.Words...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, `"`+strings.Join(phrase, " ")+`"`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// wordsFilter requires each word and phrase as whole words, like search.Tokenize splits them.
+// Words consist of letters and digits only, so they need no escaping.
+func wordsFilter(q *search.Query) []bson.M {
+	sequences := make([][]string, 0, len(q.Words)+len(q.Phrases))
+	for _, word := range q.Words {
+		sequences = append(sequences, []string{word})
+	}
+	sequences = append(sequences, q.Phrases...)
+
+	filter := make([]bson.M, 0, len(sequences))
+	for _, words := range sequences {
+		pattern := `(^|[^\p{L}\p{N}])` + strings.Join(words, `[^\p{L}\p{N}]+`) + `($|[^\p{L}\p{N}])`
+		filter = append(filter, bson.M{"text": primitive.Regex{Pattern: pattern, Options: "i"}})
+	}
+
+	return filter
+}
+
+func (p *postSearch) Search(ctx context.Context, q *search.Query, size int) (*search.Result, error) {
+	page, err := search.DecodePage(q.Page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if text := textSearch(q); text != "" {
+		filter["$text"] = bson.M{"$search": text}
+		filter["$and"] = wordsFilter(q)
+	}
+
+	if q.AuthorId != "" {
+		authorId, err := primitive.ObjectIDFromHex(q.AuthorId)
+
+		if err != nil {
+			return &search.Result{Posts: make([]storage.Post, 0)}, nil
+		}
+
+		filter["authorId"] = authorId
+	}
+
+	if len(q.Hashtags) != 0 {
+		filter["hashtags"] = bson.M{"$all": q.Hashtags}
+	}
+
+	// Time of post is kept in its id
+	idRange := bson.M{}
+	if !q.From.IsZero() {
+		idRange["$gte"] = primitive.NewObjectIDFromTimestamp(q.From)
+	}
+
+	var before *primitive.ObjectID
+	if !q.To.IsZero() {
+		to := primitive.NewObjectIDFromTimestamp(q.To.Truncate(time.Second).Add(time.Second))
+		before = &to
+	}
+
+	if !q.Ranked() && page.BeforeId != "" {
+		lastId, err := primitive.ObjectIDFromHex(page.BeforeId)
+
+		if err != nil {
+			return nil, search.ErrBadPage
+		}
+
+		if before == nil || bytes.Compare(lastId[:], before[:]) < 0 {
+			before = &lastId
+		}
+	}
+
+	if before != nil {
+		idRange["$lt"] = *before
+	}
+	if len(idRange) != 0 {
+		filter["_id"] = idRange
+	}
+
+	opts := options.Find().SetLimit(int64(size))
+	if q.Ranked() {
+		score := bson.M{"$meta": "textScore"}
+		opts.SetProjection(bson.M{"score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+			SetSkip(int64(page.Offset))
+	} else {
+		opts.SetSort(bson.M{"_id": -1})
+	}
+
+	cur, err := p.posts.Find(ctx, filter, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't search posts - %w", err)
+	}
+
+	posts := make([]storage.Post, 0)
+	if err := cur.All(ctx, &posts); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor - %w", err)
+	}
+
+	return &search.Result{Posts: posts, NextPage: search.NextPage(q, page, posts, size)}, nil
+}
@@ -0,0 +1,113 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/search"
+	"blog/internal/microblog/storage"
+	"context"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTextSearch(t *testing.T) {
+	q, err := search.Parse(`go "is fun" #golang fun`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text := textSearch(q); text != `go fun "is fun"` {
+		t.Fatalf("unexpected $search %q", text)
+	}
+}
+
+var searchPosts = []string{
+	"go is fun",
+	"fun with go and more go #golang",
+	"is go fun? #golang #news",
+	"golang gopher",
+}
+
+// Both backends must find the same posts, so queries are checked against each of them
+var searchCases = []struct {
+	query    string
+	expected []string
+}{
+	{"go", []string{"is go fun? #golang #news", "fun with go and more go #golang", "go is fun"}},
+	{"go fun", []string{"is go fun? #golang #news", "fun with go and more go #golang", "go is fun"}},
+	{"GOPHER", []string{"golang gopher"}},
+	{"gopher missing", nil},
+	{"gop", nil},
+	{`"go fun"`, []string{"is go fun? #golang #news"}},
+	{`"is fun"`, []string{"go is fun"}},
+	{`fun "with go"`, []string{"fun with go and more go #golang"}},
+	{"go #golang", []string{"is go fun? #golang #news", "fun with go and more go #golang"}},
+	{"#golang #news", []string{"is go fun? #golang #news"}},
+}
+
+type searchBackend struct {
+	searcher search.Searcher
+	add      func(ctx context.Context, post *storage.Post) error
+}
+
+func memoryBackend(*testing.T) searchBackend {
+	index := search.NewMemoryIndex()
+
+	return searchBackend{searcher: index, add: func(ctx context.Context, post *storage.Post) error {
+		post.Id = primitive.NewObjectID().Hex()
+		return index.Index(ctx, post)
+	}}
+}
+
+func mongoBackend(t *testing.T) searchBackend {
+	db := testDatabase(t)
+	if _, err := NewMigrator(db).Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &mongoStorage{posts: db.Collection("posts")}
+
+	return searchBackend{searcher: &postSearch{posts: s.posts}, add: s.AddPost}
+}
+
+func TestSearchBackendsAgree(t *testing.T) {
+	for name, newBackend := range map[string]func(*testing.T) searchBackend{"memory": memoryBackend, "mongo": mongoBackend} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := newBackend(t)
+			authorId := primitive.NewObjectID().Hex()
+
+			texts := map[string]string{}
+			for _, text := range searchPosts {
+				post := &storage.Post{Text: text, AuthorId: authorId}
+				if err := backend.add(ctx, post); err != nil {
+					t.Fatal(err)
+				}
+				texts[post.Id] = text
+			}
+
+			for _, c := range searchCases {
+				q, err := search.Parse(c.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Relevance scores differ between backends, time order doesn't
+				q.Sort = search.SortRecent
+
+				result, err := backend.searcher.Search(ctx, q, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var found []string
+				for _, post := range result.Posts {
+					found = append(found, texts[post.Id])
+				}
+
+				if fmt.Sprint(found) != fmt.Sprint(c.expected) {
+					t.Errorf("query %q: expected %q, got %q", c.query, c.expected, found)
+				}
+			}
+		})
+	}
+}
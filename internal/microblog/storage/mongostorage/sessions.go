@@ -0,0 +1,89 @@
+package mongostorage
+
+import (
+	"blog/internal/microblog/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	sessionsCollection       = "sessions"
+	passwordResetsCollection = "password_resets"
+)
+
+func (s *mongoStorage) AddSession(ctx context.Context, session *storage.Session) error {
+	if _, err := s.sessions.InsertOne(ctx, session); err != nil {
+		return fmt.Errorf("can't insert session - %w", err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) GetSession(ctx context.Context, id string) (*storage.Session, error) {
+	var session storage.Session
+	err := s.sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find session %s - %w", id, err)
+	}
+
+	return &session, nil
+}
+
+func (s *mongoStorage) RevokeSessions(ctx context.Context, userId string, exceptId string) error {
+	_, err := s.sessions.UpdateMany(ctx,
+		bson.M{"userId": userId, "_id": bson.M{"$ne": exceptId}},
+		bson.M{"$set": bson.M{"revoked": true}})
+
+	if err != nil {
+		return fmt.Errorf("can't revoke sessions of user %s - %w", userId, err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) AddPasswordReset(ctx context.Context, reset *storage.PasswordReset) error {
+	if _, err := s.passwordResets.InsertOne(ctx, reset); err != nil {
+		return fmt.Errorf("can't insert password reset - %w", err)
+	}
+
+	return nil
+}
+
+func usablePasswordReset(tokenHash []byte, now time.Time) bson.M {
+	return bson.M{"_id": tokenHash, "used": false, "expiresAt": bson.M{"$gt": now}}
+}
+
+func (s *mongoStorage) GetPasswordReset(ctx context.Context, tokenHash []byte, now time.Time) (*storage.PasswordReset, error) {
+	var reset storage.PasswordReset
+	err := s.passwordResets.FindOne(ctx, usablePasswordReset(tokenHash, now)).Decode(&reset)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't find password reset - %w", err)
+	}
+
+	return &reset, nil
+}
+
+func (s *mongoStorage) UsePasswordReset(ctx context.Context, tokenHash []byte, now time.Time) error {
+	res, err := s.passwordResets.UpdateOne(ctx, usablePasswordReset(tokenHash, now), bson.M{"$set": bson.M{"used": true}})
+
+	if err != nil {
+		return fmt.Errorf("can't use password reset - %w", err)
+	}
+
+	if res.ModifiedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
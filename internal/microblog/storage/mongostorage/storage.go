@@ -6,41 +6,174 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
-const dbName = "blog"
+const (
+	dbName          = "blog"
+	postsCollection = "posts"
+	usersCollection = "users"
+	loginIndexName  = "login_ci_unique"
+
+	// Covers waiting for migrations lock held by another replica and applying migrations
+	startTimeout = 2 * migrationsLockTTL
+)
+
+// Logins are compared case-insensitively
+var loginCollation = &options.Collation{Locale: "en", Strength: 2}
 
 type mongoStorage struct {
-	posts *mongo.Collection
-	users *mongo.Collection
+	client         *mongo.Client
+	migrator       *Migrator
+	posts          *mongo.Collection
+	users          *mongo.Collection
+	loginAttempts  *mongo.Collection
+	sessions       *mongo.Collection
+	passwordResets *mongo.Collection
+	apiTokens      *mongo.Collection
+	oauthClients   *mongo.Collection
+	oauthCodes     *mongo.Collection
+	media          *mongo.Collection
 }
 
-func NewMongoStorage(mongoUrl string) (storage.Storage, error) {
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoUrl))
+func connect(ctx context.Context, mongoUrl string) (*mongo.Client, error) {
+	// Every mongo command becomes a child span of the span from ctx
+	opts := options.Client().ApplyURI(mongoUrl).SetMonitor(otelmongo.NewMonitor())
+	client, err := mongo.Connect(ctx, opts)
 
 	if err != nil {
 		return nil, fmt.Errorf("can't connect to mongo - %w", err)
 	}
 
-	posts := client.Database(dbName).Collection("posts")
-	posts.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.D{{"authorId", 1}}})
+	return client, nil
+}
+
+// With migrateOnStart pending migrations are applied before storage is returned,
+// otherwise they are only reported and must be applied with the migrate command
+func NewMongoStorage(mongoUrl string, migrateOnStart bool) (storage.Storage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	client, err := connect(ctx, mongoUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db := client.Database(dbName)
+	migrator := NewMigrator(db)
 
-	users := client.Database(dbName).Collection("users")
-	users.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.D{{"login", 1}}})
+	if err := checkMigrations(ctx, migrator, migrateOnStart); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
 
 	return &mongoStorage{
-		posts: posts,
-		users: users,
+		client:         client,
+		migrator:       migrator,
+		posts:          db.Collection(postsCollection),
+		users:          db.Collection(usersCollection),
+		loginAttempts:  db.Collection(loginAttemptsCollection),
+		sessions:       db.Collection(sessionsCollection),
+		passwordResets: db.Collection(passwordResetsCollection),
+		apiTokens:      db.Collection(apiTokensCollection),
+		oauthClients:   db.Collection(oauthClientsCollection),
+		oauthCodes:     db.Collection(oauthCodesCollection),
+		media:          db.Collection(mediaCollection),
 	}, nil
 }
 
+func checkMigrations(ctx context.Context, migrator *Migrator, migrateOnStart bool) error {
+	if migrateOnStart {
+		applied, err := migrator.Migrate(ctx, false)
+
+		for _, m := range applied {
+			slog.Info("applied migration", slog.Int("version", m.Version), slog.String("description", m.Description))
+		}
+
+		if err != nil {
+			return fmt.Errorf("can't migrate database - %w", err)
+		}
+
+		return nil
+	}
+
+	pending, err := migrator.Pending(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if len(pending) != 0 {
+		slog.Warn("pending migrations, run migrate command", slog.Int("count", len(pending)))
+	}
+
+	return nil
+}
+
+// Migrate connects to mongo only for migration, used by the migrate command
+func Migrate(ctx context.Context, mongoUrl string, dryRun bool) ([]MigrationStatus, error) {
+	client, err := connect(ctx, mongoUrl)
+
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+
+	return NewMigrator(client.Database(dbName)).Migrate(ctx, dryRun)
+}
+
+func (s *mongoStorage) Stats(ctx context.Context) (*storage.Stats, error) {
+	users, err := s.users.EstimatedDocumentCount(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't count users - %w", err)
+	}
+
+	posts, err := s.posts.EstimatedDocumentCount(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't count posts - %w", err)
+	}
+
+	return &storage.Stats{Users: users, Posts: posts}, nil
+}
+
+// Storage is ready when mongo primary is reachable and all migrations are applied
+func (s *mongoStorage) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("can't ping mongo - %w", err)
+	}
+
+	pending, err := s.migrator.Pending(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if len(pending) != 0 {
+		return fmt.Errorf("%d migrations are not applied", len(pending))
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) Close(ctx context.Context) error {
+	if err := s.client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("can't disconnect from mongo - %w", err)
+	}
+
+	return nil
+}
+
 func (s *mongoStorage) AddPost(ctx context.Context, post *storage.Post) error {
 	id, err := s.posts.InsertOne(ctx, *post)
 
@@ -56,9 +189,12 @@ func (s *mongoStorage) AddPost(ctx context.Context, post *storage.Post) error {
 }
 
 func (s *mongoStorage) AddUser(ctx context.Context, user *storage.User) error {
+	user.SearchNames = storage.SearchNames(user.Login, &user.Profile)
 	id, err := s.users.InsertOne(ctx, user)
 
-	if err != nil {
+	if mongo.IsDuplicateKeyError(err) {
+		return &storage.ConflictError{Entity: "user", Field: "login", Value: user.Login}
+	} else if err != nil {
 		return fmt.Errorf("can't insert user - %w", err)
 	}
 
@@ -77,18 +213,42 @@ func (s *mongoStorage) GetPost(ctx context.Context, postIdBase64 string) (*stora
 
 	err = s.posts.FindOne(ctx, bson.M{"_id": postId}).Decode(&findResult)
 
-	if err != nil {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
 		return nil, fmt.Errorf("can't find post with id %s - %w", postIdBase64, err)
 	}
 
 	return &findResult, nil
 }
 
+func (s *mongoStorage) DeletePost(ctx context.Context, postIdBase64 string) (*storage.Post, error) {
+	postId, err := decodeBase64PostId(postIdBase64)
+
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	var post storage.Post
+	err = s.posts.FindOneAndDelete(ctx, bson.M{"_id": postId}).Decode(&post)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("can't delete post %s - %w", postIdBase64, err)
+	}
+
+	return &post, nil
+}
+
 func (s *mongoStorage) GetUserByLogin(ctx context.Context, login string) (*storage.User, error) {
 	var findResult storage.User
-	err := s.posts.FindOne(ctx, bson.M{"Login": login}).Decode(&findResult)
+	opts := options.FindOne().SetCollation(loginCollation)
+	err := s.users.FindOne(ctx, bson.M{"login": login}, opts).Decode(&findResult)
 
-	if err != nil {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
 		return nil, fmt.Errorf("can't find user with login %s - %w", login, err)
 	}
 
@@ -105,13 +265,191 @@ func (s *mongoStorage) GetUserById(ctx context.Context, idHex string) (*storage.
 
 	err = s.users.FindOne(ctx, bson.M{"_id": objId}).Decode(&findResult)
 
-	if err != nil {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
 		return nil, fmt.Errorf("can't find user with id %s - %w", idHex, err)
 	}
 
 	return &findResult, nil
 }
 
+func (s *mongoStorage) ListUsers(ctx context.Context, afterId string, size int) ([]storage.User, error) {
+	filter := bson.M{}
+	if afterId != "" {
+		objId, err := primitive.ObjectIDFromHex(afterId)
+
+		if err != nil {
+			return nil, fmt.Errorf("bad user id - %w", err)
+		}
+
+		filter["_id"] = bson.M{"$gt": objId}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(size))
+	cur, err := s.users.Find(ctx, filter, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't find users - %w", err)
+	}
+
+	users := make([]storage.User, 0)
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor - %w", err)
+	}
+
+	return users, nil
+}
+
+func (s *mongoStorage) SearchUsers(ctx context.Context, prefix string, afterId string, size int) ([]storage.User, error) {
+	filter := bson.M{"searchNames": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+	if afterId != "" {
+		objId, err := primitive.ObjectIDFromHex(afterId)
+
+		if err != nil {
+			return nil, fmt.Errorf("bad user id - %w", err)
+		}
+
+		filter["_id"] = bson.M{"$gt": objId}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(size))
+	cur, err := s.users.Find(ctx, filter, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("can't search users - %w", err)
+	}
+
+	users := make([]storage.User, 0)
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("can't get data from cursor - %w", err)
+	}
+
+	return users, nil
+}
+
+func (s *mongoStorage) updateUser(ctx context.Context, userId string, set bson.M) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	res, err := s.users.UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": set})
+
+	if err != nil {
+		return fmt.Errorf("can't update user %s - %w", userId, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) SetUserRole(ctx context.Context, userId string, role string) error {
+	return s.updateUser(ctx, userId, bson.M{"role": role})
+}
+
+func (s *mongoStorage) SetUserSuspended(ctx context.Context, userId string, suspended bool) error {
+	return s.updateUser(ctx, userId, bson.M{"suspended": suspended})
+}
+
+// Login never changes, so it is enough to know user id to recompute search names
+func (s *mongoStorage) UpdateProfile(ctx context.Context, userId string, profile *storage.Profile) error {
+	user, err := s.GetUserById(ctx, userId)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return storage.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return s.updateUser(ctx, userId, bson.M{"profile": profile, "searchNames": storage.SearchNames(user.Login, profile)})
+}
+
+func (s *mongoStorage) UpdatePasswordHash(ctx context.Context, userId string, hash []byte, version int) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return fmt.Errorf("bad user id - %w", err)
+	}
+
+	_, err = s.users.UpdateOne(ctx,
+		bson.M{"_id": objId},
+		bson.M{"$set": bson.M{"passwordhash": hash, "passwordHashVersion": version}})
+
+	if err != nil {
+		return fmt.Errorf("can't update password of user %s - %w", userId, err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) UpdateTotp(ctx context.Context, userId string, totp *storage.Totp) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return fmt.Errorf("bad user id - %w", err)
+	}
+
+	update := bson.M{"$set": bson.M{"totp": totp}}
+	if totp == nil {
+		update = bson.M{"$unset": bson.M{"totp": ""}}
+	}
+
+	if _, err := s.users.UpdateOne(ctx, bson.M{"_id": objId}, update); err != nil {
+		return fmt.Errorf("can't update totp of user %s - %w", userId, err)
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) UseTotpStep(ctx context.Context, userId string, step int64) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return fmt.Errorf("bad user id - %w", err)
+	}
+
+	res, err := s.users.UpdateOne(ctx,
+		bson.M{"_id": objId, "totp.enabled": true, "totp.lastStep": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"totp.lastStep": step}})
+
+	if err != nil {
+		return fmt.Errorf("can't use totp step of user %s - %w", userId, err)
+	}
+
+	if res.ModifiedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *mongoStorage) UseRecoveryCode(ctx context.Context, userId string, codeHash []byte) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return fmt.Errorf("bad user id - %w", err)
+	}
+
+	res, err := s.users.UpdateOne(ctx,
+		bson.M{"_id": objId, "totp.recoveryCodes": codeHash},
+		bson.M{"$pull": bson.M{"totp.recoveryCodes": codeHash}})
+
+	if err != nil {
+		return fmt.Errorf("can't use recovery code of user %s - %w", userId, err)
+	}
+
+	if res.ModifiedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
 // TODO: если больше постов нет?
 func (s *mongoStorage) GetPostsFrom(ctx context.Context, postId string, authorId string, size int) ([]storage.Post, string, error) {
 	postIdObj, err := decodeBase64PostId(postId)
@@ -214,7 +552,6 @@ func decodeBase64PostId(id string) (*primitive.ObjectID, error) {
 	objectIdBytes, err := base64.URLEncoding.DecodeString(id)
 
 	if err != nil {
-		log.Print("can't decode base64 id")
 		return nil, err
 	}
 
@@ -0,0 +1,31 @@
+package storage
+
+import "time"
+
+// Third-party application registered by a user
+type OAuthClient struct {
+	Id      string `bson:"_id"`
+	OwnerId string `bson:"ownerId"`
+	Name    string `bson:"name"`
+	// Redirect uri of authorization request must be equal to one of them
+	RedirectUris []string `bson:"redirectUris"`
+	// Scopes client may ask users for
+	Scopes []string `bson:"scopes"`
+	// SHA-256 of secret of confidential clients, empty for public ones
+	SecretHash []byte    `bson:"secretHash,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt"`
+}
+
+// Single-use authorization code issued after user consent
+type OAuthCode struct {
+	// SHA-256 of code
+	CodeHash    []byte   `bson:"_id"`
+	ClientId    string   `bson:"clientId"`
+	UserId      string   `bson:"userId"`
+	RedirectUri string   `bson:"redirectUri"`
+	Scopes      []string `bson:"scopes"`
+	// PKCE S256 challenge
+	CodeChallenge string    `bson:"codeChallenge"`
+	ExpiresAt     time.Time `bson:"expiresAt"`
+	Used          bool      `bson:"used"`
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"blog/internal/microblog/composer"
+	"blog/internal/microblog/preview"
 	"encoding/base64"
 	"encoding/json"
 	"time"
@@ -16,8 +18,22 @@ type Post struct {
 	// Hex value of user _id in mongo
 	AuthorId string
 	Time     string
+	// Ids of attached media in order they are shown
+	MediaIds []string
+	// PlainFormat or MarkdownFormat
+	Format string
+	// Rendering of markdown text, it is never stored
+	Html string
+	// Card of the first linked page once it is fetched, it is never stored with post
+	Preview *preview.Preview
 }
 
+// Formats of post text, empty format is plain
+const (
+	PlainFormat    = "plain"
+	MarkdownFormat = "markdown"
+)
+
 func NewPost() *Post {
 	return &Post{}
 }
@@ -26,13 +42,23 @@ type storageDbTranferObject struct {
 	Id       primitive.ObjectID `bson:"_id,omitempty"`
 	Text     string             `bson:"text"`
 	AuthorId primitive.ObjectID `bson:"authorId"`
+	// Derived from text for search, never read back
+	Hashtags []string `bson:"hashtags,omitempty"`
+	MediaIds []string `bson:"mediaIds,omitempty"`
+	Format   string   `bson:"format,omitempty"`
 }
 
 type FrontendHandlerTransferObject struct {
-	Id       string `json:"id"`
-	Text     string `json:"text"`
-	AuthorId string `json:"authorId"`
-	Time     string `json:"createdAt"`
+	Id       string   `json:"id"`
+	Text     string   `json:"text"`
+	AuthorId string   `json:"authorId"`
+	Time     string   `json:"createdAt"`
+	MediaIds []string `json:"mediaIds,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	// Derived from text, ignored in requests
+	Entities []composer.Entity `json:"entities,omitempty"`
+	Html     string            `json:"html,omitempty"`
+	Preview  *preview.Preview  `json:"preview,omitempty"`
 }
 
 func NewFrontendDto() *FrontendHandlerTransferObject {
@@ -47,7 +73,8 @@ func (p Post) MarshalBSON() ([]byte, error) {
 		return make([]byte, 0), err
 	}
 
-	return bson.Marshal(storageDbTranferObject{Text: p.Text, AuthorId: authorId})
+	return bson.Marshal(storageDbTranferObject{Text: p.Text, AuthorId: authorId, Hashtags: composer.Hashtags(p.Text),
+		MediaIds: p.MediaIds, Format: p.Format})
 }
 
 func (p Post) MarshalJSON() ([]byte, error) {
@@ -56,6 +83,11 @@ func (p Post) MarshalJSON() ([]byte, error) {
 		Text:     p.Text,
 		AuthorId: p.AuthorId,
 		Time:     p.Time,
+		MediaIds: p.MediaIds,
+		Format:   p.Format,
+		Entities: composer.Entities(p.Text),
+		Html:     p.Html,
+		Preview:  p.Preview,
 	})
 }
 
@@ -77,6 +109,8 @@ func (p *Post) UnmarshalJSON(data []byte) error {
 	p.Text = tmp.Text
 	p.AuthorId = tmp.AuthorId
 	p.Time = tmp.Time
+	p.MediaIds = tmp.MediaIds
+	p.Format = tmp.Format
 
 	return nil
 }
@@ -91,6 +125,8 @@ func (p *Post) UnmarshalBSON(data []byte) error {
 	p.AuthorId = tmp.AuthorId.Hex()
 	p.Text = tmp.Text
 	p.Time = tmp.Id.Timestamp().UTC().Format(time.RFC3339)
+	p.MediaIds = tmp.MediaIds
+	p.Format = tmp.Format
 
 	return nil
 }
@@ -0,0 +1,21 @@
+package storage
+
+import "time"
+
+// Login session, tokens issued for it are valid until it expires or is revoked
+type Session struct {
+	Id        string    `bson:"_id"`
+	UserId    string    `bson:"userId"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	Revoked   bool      `bson:"revoked"`
+}
+
+// Single-use password reset token
+type PasswordReset struct {
+	// SHA-256 of token, token itself is known only to its receiver
+	TokenHash []byte    `bson:"_id"`
+	UserId    string    `bson:"userId"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	Used      bool      `bson:"used"`
+}
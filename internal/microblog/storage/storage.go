@@ -1,6 +1,14 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+type Stats struct {
+	Users int64
+	Posts int64
+}
 
 type Storage interface {
 	AddPost(context.Context, *Post) error
@@ -8,6 +16,67 @@ type Storage interface {
 	GetPost(context.Context, string) (*Post, error)
 	GetUserByLogin(context.Context, string) (*User, error)
 	GetUserById(context.Context, string) (*User, error)
+	// Users with id greater than afterId ordered by id, empty afterId means from the first one
+	ListUsers(ctx context.Context, afterId string, size int) ([]User, error)
+	// Users one of SearchNames of which starts with lowercase prefix, paginated like ListUsers
+	SearchUsers(ctx context.Context, prefix string, afterId string, size int) ([]User, error)
+	// ErrNotFound if there is no user with id
+	SetUserRole(ctx context.Context, userId string, role string) error
+	// ErrNotFound if there is no user with id
+	SetUserSuspended(ctx context.Context, userId string, suspended bool) error
+	// Replaces profile of user, ErrNotFound if there is no user with id
+	UpdateProfile(ctx context.Context, userId string, profile *Profile) error
+	UpdatePasswordHash(ctx context.Context, userId string, hash []byte, version int) error
+	// Replaces TOTP of user, nil removes it
+	UpdateTotp(ctx context.Context, userId string, totp *Totp) error
+	// Atomically records step of accepted code, ErrNotFound if TOTP is disabled or step is not newer than the last one
+	UseTotpStep(ctx context.Context, userId string, step int64) error
+	// Atomically removes recovery code, ErrNotFound if user doesn't have it
+	UseRecoveryCode(ctx context.Context, userId string, codeHash []byte) error
+	// Returns deleted post, ErrNotFound if there is no post with id
+	DeletePost(ctx context.Context, postIdBase64 string) (*Post, error)
 	GetPostsFrom(ctx context.Context, postId string, userId string, size int) ([]Post, string, error)
 	GetFirstPosts(ctx context.Context, userId string, size int) ([]Post, string, error)
+	// Returns attempts without failures if key has none
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	// Atomically increments failures of key
+	AddLoginFailure(ctx context.Context, key string, at time.Time) (*LoginAttempts, error)
+	BlockLogin(ctx context.Context, key string, until time.Time, lockedOut bool) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	// Keys which are locked out at moment now
+	GetLockouts(ctx context.Context, now time.Time) ([]LoginAttempts, error)
+	AddSession(context.Context, *Session) error
+	// ErrNotFound if session doesn't exist, revoked sessions are returned
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// Revokes every session of user except exceptId, which may be empty
+	RevokeSessions(ctx context.Context, userId string, exceptId string) error
+	AddPasswordReset(context.Context, *PasswordReset) error
+	// ErrNotFound unless reset exists, is unused and not expired at now
+	GetPasswordReset(ctx context.Context, tokenHash []byte, now time.Time) (*PasswordReset, error)
+	// Atomically marks reset used, ErrNotFound if it is not usable anymore
+	UsePasswordReset(ctx context.Context, tokenHash []byte, now time.Time) error
+	AddApiToken(context.Context, *ApiToken) error
+	// ErrNotFound if there is no token with hash
+	GetApiTokenByHash(ctx context.Context, tokenHash []byte) (*ApiToken, error)
+	// Tokens of user ordered by creation time
+	ListApiTokens(ctx context.Context, userId string) ([]ApiToken, error)
+	// ErrNotFound if user has no token with id
+	DeleteApiToken(ctx context.Context, userId string, id string) error
+	TouchApiToken(ctx context.Context, id string, at time.Time) error
+	// Id of media is generated by caller since blobs are stored before media is added
+	AddMedia(context.Context, *Media) error
+	// ErrNotFound if there is no media with id
+	GetMedia(ctx context.Context, id string) (*Media, error)
+	AddOAuthClient(context.Context, *OAuthClient) error
+	// ErrNotFound if there is no client with id
+	GetOAuthClient(ctx context.Context, id string) (*OAuthClient, error)
+	AddOAuthCode(context.Context, *OAuthCode) error
+	// Atomically marks code used and returns it, ErrNotFound unless it exists, is unused and not expired at now
+	UseOAuthCode(ctx context.Context, codeHash []byte, now time.Time) (*OAuthCode, error)
+	// Counts may be estimated, they are used only for monitoring
+	Stats(context.Context) (*Stats, error)
+	// Reports whether storage is reachable and ready to serve requests
+	Ping(context.Context) error
+	// Releases connections, storage must not be used after Close
+	Close(context.Context) error
 }
@@ -1,8 +1,78 @@
 package storage
 
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User is never written to responses as is, secrets are hidden from json anyway
 type User struct {
-	Login        string `validate:"login"`
+	Login        string `validate:"login" bson:"login"`
 	Id           string `bson:"_id,omitempty"`
-	PasswordHash []byte
+	PasswordHash []byte `bson:"passwordhash" json:"-"`
+	// Encoding version of PasswordHash, absent in documents written before versions appeared
+	PasswordHashVersion int `bson:"passwordHashVersion" json:"-"`
+	// Nil until user starts TOTP enrollment
+	Totp *Totp `bson:"totp,omitempty" json:"-"`
+	// One of auth roles, empty for users created before roles appeared
+	Role string `bson:"role,omitempty"`
+	// Suspended users can't log in or use their tokens
+	Suspended bool `bson:"suspended,omitempty"`
+	// Empty for users who haven't filled it
+	Profile Profile `bson:"profile,omitempty"`
+	// Kept by storage, see SearchNames
+	SearchNames []string `bson:"searchNames,omitempty" json:"-"`
+}
+
+// Public information user tells about themselves, empty fields are not set
+type Profile struct {
+	DisplayName string `bson:"displayName,omitempty"`
+	Bio         string `bson:"bio,omitempty"`
+	Location    string `bson:"location,omitempty"`
+	Website     string `bson:"website,omitempty"`
+	// Id of uploaded media
+	AvatarMediaId string `bson:"avatarMediaId,omitempty"`
+}
+
+// SearchNames are lowercase login, display name and its words, user is found by prefix of any of them
+func SearchNames(login string, p *Profile) []string {
+	names := []string{strings.ToLower(login)}
+	add := func(name string) {
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
+	}
+
+	if displayName := strings.ToLower(p.DisplayName); displayName != "" {
+		add(displayName)
+		for _, word := range strings.Fields(displayName) {
+			add(word)
+		}
+	}
+
+	return names
+}
+
+// CreatedAt is time of registration, it is kept in id like time of posts
+func (u *User) CreatedAt() time.Time {
+	id, _ := primitive.ObjectIDFromHex(u.Id)
+
+	return id.Timestamp().UTC()
 }
 
+// Second factor of login
+type Totp struct {
+	// Base32 secret shared with authenticator app
+	Secret string `bson:"secret"`
+	// Secret is required on login only after enrollment is confirmed with a valid code
+	Enabled bool `bson:"enabled"`
+	// Codes of this and earlier steps are rejected as replays
+	LastStep int64 `bson:"lastStep"`
+	// SHA-256 hashes of unused one-time recovery codes
+	RecoveryCodes [][]byte `bson:"recoveryCodes"`
+}
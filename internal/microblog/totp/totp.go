@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters understood by every authenticator app
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Codes of neighbour steps are accepted to tolerate clock drift
+	Skew = 1
+
+	secretLength = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns random base32 secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("can't generate totp secret - %w", err)
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// ProvisioningURI is shown to user as QR code to add secret to authenticator app
+func ProvisioningURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns code of secret at step as in RFC 6238
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil {
+		return "", fmt.Errorf("can't decode totp secret - %w", err)
+	}
+
+	return hotp(key, step, Digits), nil
+}
+
+func hotp(key []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Validate returns step of code if it matches at t within Skew, callers must reject steps used before
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+	now := Step(t)
+
+	for step := now - Skew; step <= now+Skew; step++ {
+		expected, err := Code(secret, step)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors of RFC 6238 appendix B for SHA1
+func TestRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, code := range vectors {
+		require.Equal(t, code, hotp(key, Step(time.Unix(unix, 0)), 8), unix)
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	code, err := Code(secret, Step(time.Unix(59, 0)))
+	require.NoError(t, err)
+	require.Equal(t, "287082", code)
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := Code(secret, Step(now.Add(-Period)))
+	require.NoError(t, err)
+
+	step, ok, err := Validate(secret, code, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Step(now)-1, step)
+
+	_, ok, err = Validate(secret, code, now.Add(2*Period))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("microblog", "alice", "JBSWY3DPEHPK3PXP")
+	require.Equal(t, "otpauth://totp/microblog:alice?algorithm=SHA1&digits=6&issuer=microblog&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const (
+	ServiceName = "microblog"
+
+	NoneExporter   = "none"
+	StdoutExporter = "stdout"
+	// Endpoint and headers are configured with standard OTEL_EXPORTER_OTLP_* variables
+	OtlpExporter = "otlp"
+)
+
+// Setup installs global tracer provider and W3C trace context propagator.
+// Returned function flushes spans which are not exported yet, it must be called on shutdown.
+func Setup(ctx context.Context, exporterName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch exporterName {
+	case NoneExporter, "":
+		return func(context.Context) error { return nil }, nil
+	case StdoutExporter:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case OtlpExporter:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporterName)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't create %s trace exporter - %w", exporterName, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIpKey struct{}
+
+// ClientIpMiddleware resolves client ip once per request.
+// X-Forwarded-For is used only behind trustedProxyHops proxies which append to it, zero ignores it.
+func ClientIpMiddleware(trustedProxyHops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ip := remoteIp(req)
+
+			if trustedProxyHops > 0 {
+				if forwarded := forwardedIp(req.Header.Values("X-Forwarded-For"), trustedProxyHops); forwarded != "" {
+					ip = forwarded
+				}
+			}
+
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientIpKey{}, ip)))
+		})
+	}
+}
+
+// ClientIp resolved by ClientIpMiddleware, remote address if there was no middleware
+func ClientIp(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIpKey{}).(string); ok {
+		return ip
+	}
+
+	return remoteIp(req)
+}
+
+func remoteIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// Every proxy appends the address it got request from, so entries on the left are sent by client
+// and can be forged. Client is the entry added by the outermost of trusted proxies.
+func forwardedIp(headers []string, trustedProxyHops int) string {
+	entries := make([]string, 0)
+	for _, header := range headers {
+		for _, entry := range strings.Split(header, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return ""
+	}
+
+	return entries[max(len(entries)-trustedProxyHops, 0)]
+}
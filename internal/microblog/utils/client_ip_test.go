@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedIp(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		hops    int
+		ip      string
+	}{
+		{name: "noHeader", headers: nil, hops: 1, ip: ""},
+		{name: "singleProxy", headers: []string{"198.51.100.7"}, hops: 1, ip: "198.51.100.7"},
+		{name: "spoofedBySingleProxy", headers: []string{"203.0.113.1, 198.51.100.7"}, hops: 1, ip: "198.51.100.7"},
+		{name: "twoProxies", headers: []string{"203.0.113.1, 198.51.100.7, 10.0.0.2"}, hops: 2, ip: "198.51.100.7"},
+		{name: "severalHeaders", headers: []string{"203.0.113.1", "198.51.100.7, 10.0.0.2"}, hops: 2, ip: "198.51.100.7"},
+		{name: "fewerEntriesThanHops", headers: []string{"198.51.100.7"}, hops: 3, ip: "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.ip, forwardedIp(tt.headers, tt.hops))
+		})
+	}
+}
@@ -1,23 +1,42 @@
 package utils
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 )
 
 type ErrorLogger struct {
-	functionName string
+	logger *slog.Logger
 }
 
+// CheckError writes msg to client and logs it with the whole chain of wrapped errors
 func (l ErrorLogger) CheckError(err error, w http.ResponseWriter, msg string, status int) error {
 	if err != nil {
-		log.Print(l.functionName + msg)
+		level := slog.LevelWarn
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		l.logger.Log(context.Background(), level, msg, slog.Int("status", status), slog.String("error", err.Error()))
 		WriteErrorToResponse(w, status, msg)
 	}
 
 	return err
 }
 
-func NewErrorLogger(funcName string) *ErrorLogger {
-	return &ErrorLogger{functionName: funcName + ": "}
-}
\ No newline at end of file
+// WriteError logs msg and writes it to client, for errors which are detected by handler itself
+func (l ErrorLogger) WriteError(w http.ResponseWriter, msg string, status int) {
+	l.logger.Warn(msg, slog.Int("status", status))
+	WriteErrorToResponse(w, status, msg)
+}
+
+// WriteErrorDetails is WriteError with machine-readable details merged into response
+func (l ErrorLogger) WriteErrorDetails(w http.ResponseWriter, msg string, status int, details map[string]any) {
+	l.logger.Warn(msg, slog.Int("status", status), slog.Any("details", details))
+	WriteErrorDetailsToResponse(w, status, msg, details)
+}
+
+func NewErrorLogger(logger *slog.Logger, funcName string) *ErrorLogger {
+	return &ErrorLogger{logger: logger.With(slog.String("handler", funcName))}
+}
@@ -26,3 +26,13 @@ func WriteErrorToResponse(w http.ResponseWriter, statusCode int, errorMsg string
 	resp, _ := json.Marshal(map[string]string{"error": errorMsg})
 	WriteJsonToResponse(w, statusCode, resp)
 }
+
+func WriteErrorDetailsToResponse(w http.ResponseWriter, statusCode int, errorMsg string, details map[string]any) {
+	body := map[string]any{"error": errorMsg}
+	for k, v := range details {
+		body[k] = v
+	}
+
+	resp, _ := json.Marshal(body)
+	WriteJsonToResponse(w, statusCode, resp)
+}
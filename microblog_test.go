@@ -2,17 +2,31 @@ package hw1_milestone1
 
 import (
 	"blog/internal/microblog"
+	"blog/internal/microblog/blobstore"
+	"blog/internal/microblog/lockout"
+	"blog/internal/microblog/notify"
 	"blog/internal/microblog/storage"
+	"blog/internal/microblog/totp"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -22,6 +36,7 @@ import (
 	"github.com/getkin/kin-openapi/openapi3filter"
 	openapi3_routers "github.com/getkin/kin-openapi/routers"
 	openapi3_legacy "github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -30,6 +45,10 @@ type ApiSuite struct {
 
 	client        http.Client
 	apiSpecRouter openapi3_routers.Router
+	// Notifications to users, like password reset tokens
+	notifierFile string
+	cfg          microblog.Config
+	srv          *microblog.MicroblogServer
 }
 
 //go:embed microblog.yaml
@@ -38,12 +57,42 @@ var microblogApi []byte
 var ctx = context.Background()
 
 func (s *ApiSuite) SetupSuite() {
-	srv := microblog.NewMicroblogServer(os.Getenv("MONGO_URL"))
+	cfg := microblog.ConfigFromEnv()
+
+	if cfg.MongoUrl == "" {
+		cfg.Storage = microblog.MemoryStorage
+	}
+
+	// Limits are covered by ratelimit tests, here they would only make tests order-dependent
+	cfg.RateLimitEnabled = false
+
+	s.notifierFile = filepath.Join(s.T().TempDir(), "notifications.jsonl")
+	cfg.Notifier, cfg.NotifierFile = notify.FileNotifier, s.notifierFile
+	cfg.MediaStore, cfg.MediaDir = blobstore.FileStore, s.T().TempDir()
+	cfg.MediaLimits.MaxSize = 1 << 20
+	// Linked pages are served by local fixture servers
+	cfg.LinkPreview.AllowPrivate = true
+
+	// Images are binary, only their status and headers are checked against spec
+	for _, contentType := range []string{"image/jpeg", "image/png", "image/gif"} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+
+	srv, err := microblog.NewMicroblogServer(cfg)
+	s.Require().NoError(err)
+	s.cfg = cfg
+	s.srv = srv
 
 	go func() {
 		srv.StartNewMicrobologServer(8081)
 	}()
 
+	s.Require().Eventually(func() bool {
+		resp, err := http.Get("http://localhost:8081/healthz")
+
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+
 	spec, err := openapi3.NewLoader().LoadFromData(microblogApi)
 	s.Require().NoError(err)
 	s.Require().NoError(spec.Validate(ctx))
@@ -127,9 +176,12 @@ func (fn RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error)
 	return fn(req)
 }
 
+// Satisfies default password policy
+const testPassword = "correct-horse-42"
+
 func registerUser(s *ApiSuite, login string) string {
 	reqBody := io.NopCloser(strings.NewReader(
-		fmt.Sprintf( /* language=json */ `{"login": "%s", "password": "%s"}`, login, "test")))
+		fmt.Sprintf( /* language=json */ `{"login": "%s", "password": "%s"}`, login, testPassword)))
 	resp, err := s.client.Post("http://localhost:8081/api/v1/register", "application/json", reqBody)
 
 	s.Require().NoError(err)
@@ -147,12 +199,12 @@ func registerUser(s *ApiSuite, login string) string {
 	return response.Id
 }
 
-func addPost(s *ApiSuite, postText, userIdForCheck string) storage.FrontendHandlerTransferObject {
+func addPost(s *ApiSuite, postText, token, userIdForCheck string) storage.FrontendHandlerTransferObject {
 	post := storage.NewFrontendDto()
 	post.Text = postText
 	reqRawBody, _ := json.Marshal(map[string]string{"text": postText})
 	req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/posts", bytes.NewReader(reqRawBody))
-	req.Header.Add("System-Design-User-Id", userIdForCheck)
+	req.Header.Add("Authorization", "Bearer "+token)
 	req.Header.Add("Content-Type", "application/json")
 	s.Require().NoError(err)
 
@@ -182,7 +234,19 @@ func (s *ApiSuite) TestRegisterAndCreatePost() {
 	var regPost storage.FrontendHandlerTransferObject
 
 	s.Run("addPost", func() {
-		regPost = addPost(s, "not aboba", userId)
+		regPost = addPost(s, "not aboba", login(s, "testregisterandcreatepost", testPassword), userId)
+	})
+
+	s.Run("userIdHeaderIsNotAuthentication", func() {
+		// Spec requires token, so request is sent without validating client
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/posts", strings.NewReader(`{"text": "forged"}`))
+		s.Require().NoError(err)
+		req.Header.Add("System-Design-User-Id", userId)
+		req.Header.Add("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
 	})
 
 	s.Run("getPost", func() {
@@ -200,6 +264,1365 @@ func (s *ApiSuite) TestRegisterAndCreatePost() {
 	})
 }
 
+func (s *ApiSuite) TestPostComposer() {
+	userId := registerUser(s, "testcomposer")
+	token := login(s, "testcomposer", testPassword)
+
+	s.Run("normalized", func() {
+		resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+			map[string]string{"text": "  cafe\u0301 @testprofile https://example.com/x. #News\u0007 "})
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var post storage.FrontendHandlerTransferObject
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+		s.Require().Equal("caf\u00e9 @testprofile https://example.com/x. #News", post.Text)
+		s.Require().Equal(userId, post.AuthorId)
+
+		resp, err := s.client.Get("http://localhost:8081/api/v1/posts/" + post.Id)
+		s.Require().NoError(err)
+
+		var body struct {
+			Entities []struct {
+				Type  string `json:"type"`
+				Start int    `json:"start"`
+				End   int    `json:"end"`
+				Value string `json:"value"`
+			} `json:"entities"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&body))
+		s.Require().Len(body.Entities, 3)
+		s.Require().Equal("mention", body.Entities[0].Type)
+		s.Require().Equal("testprofile", body.Entities[0].Value)
+		s.Require().Equal([]int{5, 17}, []int{body.Entities[0].Start, body.Entities[0].End})
+		s.Require().Equal("https://example.com/x", body.Entities[1].Value)
+		s.Require().Equal("News", body.Entities[2].Value)
+	})
+
+	s.Run("limits", func() {
+		for text, status := range map[string]int{
+			" \n\t ": http.StatusBadRequest,
+			strings.Repeat("\U0001F44D\U0001F3FD", 501): http.StatusBadRequest,
+			strings.Repeat("\U0001F44D\U0001F3FD", 500): http.StatusOK,
+		} {
+			resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+				map[string]string{"text": text})
+			s.Require().Equal(status, resp.StatusCode)
+		}
+	})
+}
+
+func (s *ApiSuite) TestMarkdownPosts() {
+	userId := registerUser(s, "testmarkdown")
+	token := login(s, "testmarkdown", testPassword)
+
+	var post struct {
+		Id     string `json:"id"`
+		Text   string `json:"text"`
+		Format string `json:"format"`
+		Html   string `json:"html"`
+	}
+	source := "**hi** <script>alert(1)</script> [site](https://example.com)"
+	resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+		map[string]string{"text": source, "format": "markdown"})
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+	s.Require().Equal(source, post.Text)
+	s.Require().Equal("markdown", post.Format)
+	s.Require().Equal("<p><strong>hi</strong> &lt;script&gt;alert(1)&lt;/script&gt; <a href=\"https://example.com\" "+
+		"rel=\"nofollow noopener noreferrer\" target=\"_blank\">site</a></p>\n", post.Html)
+
+	addPost(s, "**plain**", token, userId)
+
+	resp, err := s.client.Get("http://localhost:8081/api/v1/users/" + userId + "/posts")
+	s.Require().NoError(err)
+	var page struct {
+		Posts []struct {
+			Format string `json:"format"`
+			Html   string `json:"html"`
+		} `json:"posts"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&page))
+	s.Require().Len(page.Posts, 2)
+	s.Require().Equal(post.Html, page.Posts[1].Html)
+	s.Require().Empty(page.Posts[0].Format)
+	s.Require().Empty(page.Posts[0].Html, "plain posts aren't rendered")
+}
+
+func (s *ApiSuite) TestLinkPreview() {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>Fallback</title>
+<meta property="og:title" content="Fixture page">
+<meta property="og:description" content="Served by test">
+<meta property="og:image" content="/card.png">
+</head><body></body></html>`))
+	}))
+	defer page.Close()
+
+	registerUser(s, "testlinkpreview")
+	token := login(s, "testlinkpreview", testPassword)
+
+	type linkPreview struct {
+		Url         string `json:"url"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Image       string `json:"image"`
+	}
+	var post struct {
+		Id      string       `json:"id"`
+		Preview *linkPreview `json:"preview"`
+	}
+	resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+		map[string]string{"text": "look at " + page.URL + "/article#top and " + page.URL + "/other"})
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+
+	s.Require().Eventually(func() bool {
+		resp, err := s.client.Get("http://localhost:8081/api/v1/posts/" + post.Id)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false
+		}
+		defer resp.Body.Close()
+
+		return json.NewDecoder(resp.Body).Decode(&post) == nil && post.Preview != nil
+	}, 5*time.Second, 50*time.Millisecond)
+	s.Require().Equal(linkPreview{Url: page.URL + "/article", Title: "Fixture page", Description: "Served by test",
+		Image: page.URL + "/card.png"}, *post.Preview, "the first link is previewed")
+}
+
+func (s *ApiSuite) TestRegisterDuplicateLogin() {
+	s.Run("registerUser", func() {
+		registerUser(s, "testregisterduplicatelogin")
+	})
+
+	s.Run("registerSameLogin", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "testregisterduplicatelogin", "password": "correct-horse-42"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/register", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusConflict, resp.StatusCode)
+	})
+
+	s.Run("registerSameLoginInOtherCase", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "TestRegisterDuplicateLogin", "password": "correct-horse-42"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/register", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusConflict, resp.StatusCode)
+	})
+}
+
+func (s *ApiSuite) TestLoginLockout() {
+	// Separate server, so lockout comes before delays grow and failures from here don't block other tests
+	cfg := s.cfg
+	cfg.LoginLockoutThreshold = lockout.DefaultLoginPolicy.FreeAttempts + 1
+	srv, err := microblog.NewMicroblogServer(cfg)
+	s.Require().NoError(err)
+
+	srvCtx, stop := context.WithCancel(ctx)
+	stopped := make(chan error)
+	go func() {
+		stopped <- srv.Run(srvCtx, 8083)
+	}()
+	defer func() {
+		stop()
+		s.Require().NoError(<-stopped)
+	}()
+
+	s.Require().Eventually(func() bool {
+		resp, err := http.Get("http://localhost:8083/healthz")
+
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Login endpoint isn't part of api spec
+	loginAs := func(password string) *http.Response {
+		reqBody, _ := json.Marshal(map[string]string{"login": "TestLoginLockout", "password": password})
+		resp, err := http.Post("http://localhost:8083/api/v1/login", "application/json", bytes.NewReader(reqBody))
+		s.Require().NoError(err)
+
+		return resp
+	}
+
+	reqBody := strings.NewReader( /* language=json */ `{"login": "TestLoginLockout", "password": "correct-horse-42"}`)
+	resp, err := http.Post("http://localhost:8083/api/v1/register", "application/json", reqBody)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	s.Run("failuresBeforeLockout", func() {
+		for i := 0; i < cfg.LoginLockoutThreshold; i++ {
+			s.Require().Equal(http.StatusBadRequest, loginAs("wrong").StatusCode)
+		}
+	})
+
+	s.Run("lockedOut", func() {
+		resp := loginAs("wrong")
+		s.Require().Equal(http.StatusTooManyRequests, resp.StatusCode)
+
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		s.Require().NoError(err)
+		s.Require().Greater(retryAfter, int(lockout.DefaultLoginPolicy.MaxDelay.Seconds()))
+	})
+
+	s.Run("correctPasswordIsRefused", func() {
+		resp := loginAs("correct-horse-42")
+		s.Require().Equal(http.StatusTooManyRequests, resp.StatusCode)
+		s.Require().NotEmpty(resp.Header.Get("Retry-After"))
+	})
+}
+
+func (s *ApiSuite) TestRegisterWeakPassword() {
+	reqBody := strings.NewReader( /* language=json */ `{"login": "testregisterweakpassword", "password": "test"}`)
+	resp, err := s.client.Post("http://localhost:8081/api/v1/register", "application/json", reqBody)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+	var response struct {
+		Violations []struct {
+			Rule string `json:"rule"`
+		} `json:"violations"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+
+	var rules []string
+	for _, v := range response.Violations {
+		rules = append(rules, v.Rule)
+	}
+	s.Require().Equal([]string{"min_length", "char_classes"}, rules)
+}
+
+func (s *ApiSuite) TestLogin() {
+	s.Run("registerUser", func() {
+		registerUser(s, "testlogin")
+	})
+
+	s.Run("login", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "testlogin", "password": "correct-horse-42"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/login", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var response struct {
+			Token string `json:"token"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+		s.Require().NotEmpty(response.Token)
+	})
+
+	s.Run("wrongPassword", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "testlogin", "password": "wrong"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/login", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func login(s *ApiSuite, login, password string) string {
+	reqBody, _ := json.Marshal(map[string]string{"login": login, "password": password})
+	resp, err := s.client.Post("http://localhost:8081/api/v1/login", "application/json", bytes.NewReader(reqBody))
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var response struct {
+		Token string `json:"token"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+	s.Require().NotEmpty(response.Token)
+
+	return response.Token
+}
+
+func changePassword(s *ApiSuite, token, currentPassword, newPassword string) *http.Response {
+	reqBody, _ := json.Marshal(map[string]string{"currentPassword": currentPassword, "newPassword": newPassword})
+	req, err := http.NewRequest(http.MethodPut, "http://localhost:8081/api/v1/users/me/password", bytes.NewReader(reqBody))
+	s.Require().NoError(err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+
+	return resp
+}
+
+func (s *ApiSuite) TestChangePassword() {
+	registerUser(s, "testchangepassword")
+	token := login(s, "testchangepassword", testPassword)
+	otherToken := login(s, "testchangepassword", testPassword)
+
+	s.Run("wrongCurrentPassword", func() {
+		resp := changePassword(s, token, "wrong-horse-42", "battery-staple-7")
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+
+	s.Run("withoutToken", func() {
+		resp := changePassword(s, "", testPassword, "battery-staple-7")
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("change", func() {
+		resp := changePassword(s, token, testPassword, "battery-staple-7")
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+	})
+
+	s.Run("otherSessionRevoked", func() {
+		resp := changePassword(s, otherToken, "battery-staple-7", testPassword)
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("loginWithNewPassword", func() {
+		login(s, "testchangepassword", "battery-staple-7")
+	})
+}
+
+var resetTokenRegex = regexp.MustCompile(`token (\S+) to reset`)
+
+func (s *ApiSuite) TestPasswordReset() {
+	registerUser(s, "testpasswordreset")
+	token := login(s, "testpasswordreset", testPassword)
+
+	s.Run("unknownLogin", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "testpasswordresetunknown"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/password-reset", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+	})
+
+	s.Run("notifierFailureIsHidden", func() {
+		cfg := s.cfg
+		// Directory can't be opened as notifications file
+		cfg.NotifierFile = s.T().TempDir()
+		srv, err := microblog.NewMicroblogServer(cfg)
+		s.Require().NoError(err)
+
+		serverCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan error)
+		go func() {
+			stopped <- srv.Run(serverCtx, 8082)
+		}()
+		defer func() {
+			stop()
+			s.Require().NoError(<-stopped)
+		}()
+
+		reqBody := fmt.Sprintf( /* language=json */ `{"login": "testpasswordresetbroken", "password": "%s"}`, testPassword)
+		s.Require().Eventually(func() bool {
+			resp, err := http.Post("http://localhost:8082/api/v1/register", "application/json", strings.NewReader(reqBody))
+			return err == nil && resp.StatusCode == http.StatusOK
+		}, 5*time.Second, 50*time.Millisecond)
+
+		for _, login := range []string{"testpasswordresetbroken", "testpasswordresetbrokenunknown"} {
+			resp, err := s.client.Post("http://localhost:8082/api/v1/password-reset", "application/json",
+				strings.NewReader(`{"login": "`+login+`"}`))
+			s.Require().NoError(err)
+			s.Require().Equal(http.StatusAccepted, resp.StatusCode, login)
+		}
+	})
+
+	var resetToken string
+
+	s.Run("request", func() {
+		reqBody := strings.NewReader( /* language=json */ `{"login": "testpasswordreset"}`)
+		resp, err := s.client.Post("http://localhost:8081/api/v1/password-reset", "application/json", reqBody)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+
+		notifications, err := os.ReadFile(s.notifierFile)
+		s.Require().NoError(err)
+
+		for _, line := range strings.Split(strings.TrimSpace(string(notifications)), "\n") {
+			var msg notify.Message
+			s.Require().NoError(json.Unmarshal([]byte(line), &msg))
+
+			if msg.Login == "testpasswordreset" {
+				match := resetTokenRegex.FindStringSubmatch(msg.Body)
+				s.Require().Len(match, 2)
+				resetToken = match[1]
+			}
+		}
+		s.Require().NotEmpty(resetToken)
+	})
+
+	confirm := func(token, password string) int {
+		reqBody, _ := json.Marshal(map[string]string{"token": token, "password": password})
+		resp, err := s.client.Post("http://localhost:8081/api/v1/password-reset/confirm", "application/json", bytes.NewReader(reqBody))
+		s.Require().NoError(err)
+		return resp.StatusCode
+	}
+
+	s.Run("weakPassword", func() {
+		s.Require().Equal(http.StatusBadRequest, confirm(resetToken, "weak"))
+	})
+
+	s.Run("confirm", func() {
+		s.Require().Equal(http.StatusNoContent, confirm(resetToken, "battery-staple-7"))
+	})
+
+	s.Run("tokenIsSingleUse", func() {
+		s.Require().Equal(http.StatusBadRequest, confirm(resetToken, "another-staple-8"))
+	})
+
+	s.Run("sessionsRevoked", func() {
+		resp := changePassword(s, token, "battery-staple-7", testPassword)
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("loginWithNewPassword", func() {
+		login(s, "testpasswordreset", "battery-staple-7")
+	})
+}
+
+func postJson(s *ApiSuite, url, token string, body interface{}, response interface{}) int {
+	reqBody, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqBody))
+	s.Require().NoError(err)
+	req.Header.Add("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+
+	if response != nil && resp.StatusCode == http.StatusOK {
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(response))
+	}
+
+	return resp.StatusCode
+}
+
+func (s *ApiSuite) TestTotp() {
+	registerUser(s, "testtotp")
+	token := login(s, "testtotp", testPassword)
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+		Uri    string `json:"uri"`
+	}
+	var recovery struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+
+	s.Run("enroll", func() {
+		s.Require().Equal(http.StatusOK, postJson(s, "http://localhost:8081/api/v1/users/me/totp", token, nil, &enrollment))
+		s.Require().Contains(enrollment.Uri, "secret="+enrollment.Secret)
+	})
+
+	s.Run("confirmWrongCode", func() {
+		status := postJson(s, "http://localhost:8081/api/v1/users/me/totp/confirm", token, map[string]string{"code": "000000"}, nil)
+		s.Require().Equal(http.StatusBadRequest, status)
+	})
+
+	// Code of the previous step is accepted too, so confirmation and login don't have to wait for the next step
+	s.Run("confirm", func() {
+		code, err := totp.Code(enrollment.Secret, totp.Step(time.Now())-1)
+		s.Require().NoError(err)
+
+		status := postJson(s, "http://localhost:8081/api/v1/users/me/totp/confirm", token, map[string]string{"code": code}, &recovery)
+		s.Require().Equal(http.StatusOK, status)
+		s.Require().Len(recovery.RecoveryCodes, 10)
+	})
+
+	challenge := func() string {
+		var response struct {
+			Token     string `json:"token"`
+			Challenge string `json:"challenge"`
+		}
+		status := postJson(s, "http://localhost:8081/api/v1/login", "",
+			map[string]string{"login": "testtotp", "password": testPassword}, &response)
+		s.Require().Equal(http.StatusOK, status)
+		s.Require().Empty(response.Token)
+		s.Require().NotEmpty(response.Challenge)
+
+		return response.Challenge
+	}
+
+	s.Run("challengeIsNotAccessToken", func() {
+		resp := changePassword(s, challenge(), testPassword, "battery-staple-7")
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("loginWithCode", func() {
+		code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+		s.Require().NoError(err)
+
+		var response struct {
+			Token string `json:"token"`
+		}
+		status := postJson(s, "http://localhost:8081/api/v1/login/totp", "",
+			map[string]string{"challenge": challenge(), "code": code}, &response)
+		s.Require().Equal(http.StatusOK, status)
+		s.Require().NotEmpty(response.Token)
+
+		// Replayed code is rejected
+		status = postJson(s, "http://localhost:8081/api/v1/login/totp", "",
+			map[string]string{"challenge": challenge(), "code": code}, nil)
+		s.Require().Equal(http.StatusBadRequest, status)
+	})
+
+	s.Run("loginWithRecoveryCode", func() {
+		body := map[string]string{"challenge": challenge(), "recoveryCode": strings.ToUpper(recovery.RecoveryCodes[0])}
+		s.Require().Equal(http.StatusOK, postJson(s, "http://localhost:8081/api/v1/login/totp", "", body, nil))
+
+		body["challenge"] = challenge()
+		s.Require().Equal(http.StatusBadRequest, postJson(s, "http://localhost:8081/api/v1/login/totp", "", body, nil))
+	})
+
+	// Otherwise whoever knows the password could guess codes forever, logging in again before the lockout
+	s.Run("passwordLoginKeepsCodeFailures", func() {
+		for i := 0; i < 3; i++ {
+			status := postJson(s, "http://localhost:8081/api/v1/login/totp", "",
+				map[string]string{"challenge": challenge(), "code": "000000"}, nil)
+			s.Require().Equal(http.StatusBadRequest, status)
+		}
+
+		status := postJson(s, "http://localhost:8081/api/v1/login", "",
+			map[string]string{"login": "testtotp", "password": testPassword}, nil)
+		s.Require().Equal(http.StatusTooManyRequests, status, "the replayed recovery code and wrong codes are counted")
+	})
+}
+
+func (s *ApiSuite) TestApiTokens() {
+	userId := registerUser(s, "testapitokens")
+	session := login(s, "testapitokens", testPassword)
+
+	type apiToken struct {
+		Id         string `json:"id"`
+		Token      string `json:"token"`
+		LastUsedAt string `json:"lastUsedAt"`
+	}
+
+	createToken := func(name string, scopes ...string) apiToken {
+		reqBody, _ := json.Marshal(map[string]interface{}{"name": name, "scopes": scopes})
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/users/me/tokens", bytes.NewReader(reqBody))
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+session)
+		req.Header.Add("Content-Type", "application/json")
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+		var token apiToken
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&token))
+		s.Require().NotEmpty(token.Token)
+
+		return token
+	}
+
+	postWithToken := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/posts", strings.NewReader(`{"text": "from bot"}`))
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.Header.Add("Content-Type", "application/json")
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+
+		if resp.StatusCode == http.StatusOK {
+			var post storage.FrontendHandlerTransferObject
+			s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+			s.Require().Equal(userId, post.AuthorId)
+		}
+
+		return resp.StatusCode
+	}
+
+	writer := createToken("bot", "posts:write")
+	reader := createToken("reader", "posts:read")
+
+	s.Run("postWithToken", func() {
+		s.Require().Equal(http.StatusOK, postWithToken(writer.Token))
+	})
+
+	s.Run("missingScope", func() {
+		s.Require().Equal(http.StatusForbidden, postWithToken(reader.Token))
+	})
+
+	s.Run("noAccountManagement", func() {
+		resp := changePassword(s, writer.Token, testPassword, "battery-staple-7")
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode)
+	})
+
+	s.Run("list", func() {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8081/api/v1/users/me/tokens", nil)
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+session)
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var response struct {
+			Tokens []apiToken `json:"tokens"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+		s.Require().Len(response.Tokens, 2)
+		s.Require().Equal(writer.Id, response.Tokens[0].Id)
+		s.Require().Empty(response.Tokens[0].Token)
+		s.Require().NotEmpty(response.Tokens[0].LastUsedAt)
+	})
+
+	s.Run("revoke", func() {
+		req, err := http.NewRequest(http.MethodDelete, "http://localhost:8081/api/v1/users/me/tokens/"+writer.Id, nil)
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+session)
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		s.Require().Equal(http.StatusUnauthorized, postWithToken(writer.Token))
+	})
+}
+
+func (s *ApiSuite) TestOAuth() {
+	registerUser(s, "testoauth")
+	session := login(s, "testoauth", testPassword)
+
+	var client struct {
+		ClientId     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"name":         "bot",
+		"redirectUris": []string{"https://bot.example/callback"},
+		"scopes":       []string{"posts:read", "posts:write"},
+		"confidential": true,
+	})
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/oauth/clients", bytes.NewReader(reqBody))
+	s.Require().NoError(err)
+	req.Header.Add("Authorization", "Bearer "+session)
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode)
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&client))
+	s.Require().NotEmpty(client.ClientSecret)
+
+	// Example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	authorizeUrl := "http://localhost:8081/api/v1/oauth/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientId},
+		"redirect_uri":          {"https://bot.example/callback"},
+		"scope":                 {"posts:write"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	s.Run("consent", func() {
+		req, err := http.NewRequest(http.MethodGet, authorizeUrl, nil)
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+session)
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var consent struct {
+			ClientName string   `json:"clientName"`
+			Scopes     []string `json:"scopes"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&consent))
+		s.Require().Equal("bot", consent.ClientName)
+		s.Require().Equal([]string{"posts:write"}, consent.Scopes)
+	})
+
+	var code string
+
+	s.Run("approve", func() {
+		req, err := http.NewRequest(http.MethodPost, authorizeUrl, strings.NewReader(`{"approved": true}`))
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+session)
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var decision struct {
+			RedirectTo string `json:"redirectTo"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&decision))
+		redirect, err := url.Parse(decision.RedirectTo)
+		s.Require().NoError(err)
+		s.Require().Equal("xyz", redirect.Query().Get("state"))
+		code = redirect.Query().Get("code")
+		s.Require().NotEmpty(code)
+	})
+
+	exchange := func(verifier string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/oauth/token", strings.NewReader(url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://bot.example/callback"},
+			"code_verifier": {verifier},
+		}.Encode()))
+		s.Require().NoError(err)
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientId, client.ClientSecret)
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+
+		return resp
+	}
+
+	var accessToken string
+
+	s.Run("exchange", func() {
+		resp := exchange(verifier)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var token struct {
+			AccessToken string `json:"access_token"`
+			Scope       string `json:"scope"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&token))
+		s.Require().Equal("posts:write", token.Scope)
+		accessToken = token.AccessToken
+
+		s.Require().Equal(http.StatusBadRequest, exchange(verifier).StatusCode, "code is single-use")
+	})
+
+	s.Run("postWithAccessToken", func() {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/posts", strings.NewReader(`{"text": "from oauth"}`))
+		s.Require().NoError(err)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+
+	s.Run("introspect", func() {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/oauth/introspect",
+			strings.NewReader(url.Values{"token": {accessToken}}.Encode()))
+		s.Require().NoError(err)
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientId, client.ClientSecret)
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var introspection struct {
+			Active    bool   `json:"active"`
+			ClientId  string `json:"client_id"`
+			Scope     string `json:"scope"`
+			ExpiresAt int64  `json:"exp"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&introspection))
+		s.Require().True(introspection.Active)
+		s.Require().Equal(client.ClientId, introspection.ClientId)
+		s.Require().Equal("posts:write", introspection.Scope)
+		s.Require().Greater(introspection.ExpiresAt, time.Now().Unix())
+	})
+}
+
+// sendJson sends body as JSON unless it is nil
+func sendJson(s *ApiSuite, method, url, token string, body interface{}) *http.Response {
+	var reqBody io.Reader
+	if body != nil {
+		rawBody, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(rawBody)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	s.Require().NoError(err)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+
+	return resp
+}
+
+func (s *ApiSuite) TestAdmin() {
+	registerUser(s, "testadmin")
+	moderatorId := registerUser(s, "testmoderator")
+	victimId := registerUser(s, "testvictim")
+	s.Require().NoError(s.srv.SetRole(ctx, "testadmin", "admin"))
+
+	adminToken := login(s, "testadmin", testPassword)
+	victimToken := login(s, "testvictim", testPassword)
+	usersUrl := "http://localhost:8081/api/v1/admin/users"
+
+	s.Run("userHasNoPermission", func() {
+		resp := sendJson(s, http.MethodGet, usersUrl, victimToken, nil)
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode)
+	})
+
+	s.Run("assignModerator", func() {
+		resp := sendJson(s, http.MethodPut, usersUrl+"/"+moderatorId+"/role", adminToken, map[string]string{"role": "moderator"})
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+	})
+
+	moderatorToken := login(s, "testmoderator", testPassword)
+
+	s.Run("listUsers", func() {
+		var page struct {
+			Users []struct {
+				Id   string `json:"id"`
+				Role string `json:"role"`
+			} `json:"users"`
+			NextPage string `json:"nextPage"`
+		}
+		roles := map[string]string{}
+
+		for url := usersUrl + "?size=1"; url != ""; {
+			resp := sendJson(s, http.MethodGet, url, moderatorToken, nil)
+			s.Require().Equal(http.StatusOK, resp.StatusCode)
+			page.NextPage = ""
+			s.Require().NoError(json.NewDecoder(resp.Body).Decode(&page))
+
+			for _, user := range page.Users {
+				roles[user.Id] = user.Role
+			}
+
+			url = ""
+			if page.NextPage != "" {
+				url = usersUrl + "?size=1&page=" + page.NextPage
+			}
+		}
+
+		s.Require().Equal("moderator", roles[moderatorId])
+		s.Require().Equal("user", roles[victimId])
+	})
+
+	s.Run("moderatorCantAssignRoles", func() {
+		resp := sendJson(s, http.MethodPut, usersUrl+"/"+victimId+"/role", moderatorToken, map[string]string{"role": "admin"})
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode)
+	})
+
+	s.Run("forceDeletePost", func() {
+		post := addPost(s, "spam", victimToken, victimId)
+		postUrl := "http://localhost:8081/api/v1/admin/posts/" + post.Id
+
+		s.Require().Equal(http.StatusNoContent, sendJson(s, http.MethodDelete, postUrl, moderatorToken, nil).StatusCode)
+		s.Require().Equal(http.StatusNotFound, sendJson(s, http.MethodDelete, postUrl, moderatorToken, nil).StatusCode)
+	})
+
+	s.Run("suspend", func() {
+		suspensionUrl := usersUrl + "/" + victimId + "/suspension"
+		resp := sendJson(s, http.MethodPut, suspensionUrl, moderatorToken, map[string]string{"reason": "spam"})
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodGet, "http://localhost:8081/api/v1/users/me/tokens", victimToken, nil)
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode, "sessions are revoked")
+
+		reqBody, _ := json.Marshal(map[string]string{"login": "testvictim", "password": testPassword})
+		resp, err := s.client.Post("http://localhost:8081/api/v1/login", "application/json", bytes.NewReader(reqBody))
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodDelete, suspensionUrl, moderatorToken, nil)
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+		login(s, "testvictim", testPassword)
+	})
+
+	s.Run("onlyLowerRoles", func() {
+		resp := sendJson(s, http.MethodPut, usersUrl+"/"+moderatorId+"/suspension", moderatorToken, map[string]string{})
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode, "users can't suspend themselves")
+
+		resp = sendJson(s, http.MethodPut, usersUrl+"/"+moderatorId+"/suspension", adminToken, map[string]string{})
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodGet, usersUrl, moderatorToken, nil)
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func (s *ApiSuite) TestProfile() {
+	userId := registerUser(s, "testprofile")
+	token := login(s, "testprofile", testPassword)
+	meUrl := "http://localhost:8081/api/v1/users/me"
+	profileUrl := "http://localhost:8081/api/v1/users/" + userId
+
+	getProfile := func() map[string]interface{} {
+		resp, err := s.client.Get(profileUrl)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var profile map[string]interface{}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&profile))
+
+		return profile
+	}
+
+	s.Run("empty", func() {
+		profile := getProfile()
+		s.Require().Equal("testprofile", profile["login"])
+		s.Require().NotEmpty(profile["createdAt"])
+		s.Require().NotContains(profile, "displayName")
+		s.Require().NotContains(profile, "passwordHash")
+	})
+
+	s.Run("update", func() {
+		resp := sendJson(s, http.MethodPatch, meUrl, token, map[string]string{
+			"displayName": " Test Profile ",
+			"bio":         "about\nme",
+			"website":     "https://example.com",
+		})
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodPatch, meUrl, token, map[string]string{"location": "Moscow", "website": ""})
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		profile := getProfile()
+		s.Require().Equal("Test Profile", profile["displayName"])
+		s.Require().Equal("about\nme", profile["bio"])
+		s.Require().Equal("Moscow", profile["location"])
+		s.Require().NotContains(profile, "website")
+	})
+
+	s.Run("invalid", func() {
+		resp := sendJson(s, http.MethodPatch, meUrl, token, map[string]string{
+			"website":  "javascript:alert(1)",
+			"location": strings.Repeat("x", 31),
+		})
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		var body struct {
+			Violations []struct {
+				Field string `json:"field"`
+			} `json:"violations"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&body))
+		s.Require().Len(body.Violations, 2)
+		s.Require().Equal("Moscow", getProfile()["location"], "invalid patch isn't applied")
+	})
+
+	s.Run("unauthorized", func() {
+		resp := sendJson(s, http.MethodPatch, meUrl, "", map[string]string{"bio": "hacked"})
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("notFound", func() {
+		resp, err := s.client.Get("http://localhost:8081/api/v1/users/000000000000000000000000")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func (s *ApiSuite) TestUserSearch() {
+	firstId := registerUser(s, "testsearchfirst")
+	secondId := registerUser(s, "testsearchsecond")
+	registerUser(s, "testnotfound")
+
+	token := login(s, "testnotfound", testPassword)
+	resp := sendJson(s, http.MethodPatch, "http://localhost:8081/api/v1/users/me", token,
+		map[string]string{"displayName": "Searchable Person"})
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	s.Run("byLogin", func() {
+		resp, err := s.client.Get("http://localhost:8081/api/v1/users/by-login/TestSearchFirst")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var profile map[string]interface{}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&profile))
+		s.Require().Equal(firstId, profile["id"])
+
+		resp, err = s.client.Get("http://localhost:8081/api/v1/users/by-login/testnosuchuser")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+	})
+
+	search := func(query string) []string {
+		var ids []string
+		for page := ""; ; {
+			resp, err := s.client.Get("http://localhost:8081/api/v1/users/search?size=1&q=" + url.QueryEscape(query) + page)
+			s.Require().NoError(err)
+			s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+			var result struct {
+				Users []struct {
+					Id string `json:"id"`
+				} `json:"users"`
+				NextPage string `json:"nextPage"`
+			}
+			s.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+			for _, user := range result.Users {
+				ids = append(ids, user.Id)
+			}
+
+			if result.NextPage == "" {
+				return ids
+			}
+			page = "&page=" + result.NextPage
+		}
+	}
+
+	s.Run("byLoginPrefix", func() {
+		s.Require().Equal([]string{firstId, secondId}, search("TestSearch"))
+	})
+
+	s.Run("byDisplayNameWord", func() {
+		s.Require().Len(search("pers"), 1)
+		s.Require().Equal(search("pers"), search("searchable p"))
+	})
+
+	s.Run("regexIsEscaped", func() {
+		s.Require().Empty(search("test.*"))
+	})
+}
+
+func (s *ApiSuite) TestSearchPosts() {
+	authorId := registerUser(s, "testpostsauthor")
+	otherId := registerUser(s, "testpostsother")
+	authorToken, otherToken := login(s, "testpostsauthor", testPassword), login(s, "testpostsother", testPassword)
+	first := addPost(s, "Searching zebras in the savanna #safari", authorToken, authorId)
+	second := addPost(s, "zebras and lions at night #Safari #night", otherToken, otherId)
+	third := addPost(s, "savanna zebras zebras everywhere", authorToken, authorId)
+
+	search := func(query string) ([]string, string) {
+		resp, err := s.client.Get("http://localhost:8081/api/v1/search/posts?" + query)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Posts []struct {
+				Id string `json:"id"`
+			} `json:"posts"`
+			NextPage string `json:"nextPage"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+		ids := make([]string, 0)
+		for _, post := range result.Posts {
+			ids = append(ids, post.Id)
+		}
+		return ids, result.NextPage
+	}
+
+	s.Run("relevance", func() {
+		ids, _ := search("q=zebras+savanna")
+		s.Require().Equal([]string{third.Id, first.Id}, ids)
+	})
+
+	s.Run("phrase", func() {
+		ids, _ := search("q=" + url.QueryEscape(`"zebras in the savanna"`))
+		s.Require().Equal([]string{first.Id}, ids)
+	})
+
+	s.Run("filters", func() {
+		ids, _ := search("q=" + url.QueryEscape("#safari from:TestPostsAuthor"))
+		s.Require().Equal([]string{first.Id}, ids)
+
+		ids, _ = search("q=" + url.QueryEscape("zebras from:testnosuchauthor"))
+		s.Require().Empty(ids)
+
+		ids, _ = search("q=zebras&to=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+		s.Require().Empty(ids)
+	})
+
+	s.Run("recentPages", func() {
+		ids, page := search("q=zebras&sort=recent&size=2")
+		s.Require().Equal([]string{third.Id, second.Id}, ids)
+		s.Require().NotEmpty(page)
+
+		ids, _ = search("q=zebras&sort=recent&size=2&page=" + page)
+		s.Require().Equal([]string{first.Id}, ids)
+	})
+
+	s.Run("deletedPostIsNotFound", func() {
+		registerUser(s, "testpostsadmin")
+		s.Require().NoError(s.srv.SetRole(ctx, "testpostsadmin", "admin"))
+
+		resp := sendJson(s, http.MethodDelete, "http://localhost:8081/api/v1/admin/posts/"+second.Id,
+			login(s, "testpostsadmin", testPassword), nil)
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		ids, _ := search("q=lions")
+		s.Require().Empty(ids)
+	})
+}
+
+func uploadMedia(s *ApiSuite, token string, data []byte) *http.Response {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "image.png")
+	s.Require().NoError(err)
+	_, err = part.Write(data)
+	s.Require().NoError(err)
+	s.Require().NoError(writer.Close())
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8081/api/v1/media", &body)
+	s.Require().NoError(err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+
+	return resp
+}
+
+func testPng(s *ApiSuite, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+
+	var b bytes.Buffer
+	s.Require().NoError(png.Encode(&b, img))
+
+	return b.Bytes()
+}
+
+func (s *ApiSuite) TestMedia() {
+	userId := registerUser(s, "testmedia")
+	token := login(s, "testmedia", testPassword)
+	registerUser(s, "testmediaother")
+	otherToken := login(s, "testmediaother", testPassword)
+
+	var uploaded struct {
+		Id           string `json:"id"`
+		ContentType  string `json:"contentType"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		Url          string `json:"url"`
+		ThumbnailUrl string `json:"thumbnailUrl"`
+	}
+	resp := uploadMedia(s, token, testPng(s, 640, 480))
+	s.Require().Equal(http.StatusCreated, resp.StatusCode)
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&uploaded))
+	s.Require().Equal("image/png", uploaded.ContentType)
+	s.Require().Equal([]int{640, 480}, []int{uploaded.Width, uploaded.Height})
+
+	s.Run("download", func() {
+		resp, err := s.client.Get("http://localhost:8081" + uploaded.Url)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal("image/png", resp.Header.Get("Content-Type"))
+		s.Require().Equal("nosniff", resp.Header.Get("X-Content-Type-Options"))
+
+		resp, err = s.client.Get("http://localhost:8081" + uploaded.ThumbnailUrl)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		thumbnail, err := png.DecodeConfig(resp.Body)
+		s.Require().NoError(err)
+		s.Require().Equal([]int{320, 240}, []int{thumbnail.Width, thumbnail.Height})
+
+		resp, err = s.client.Get("http://localhost:8081/api/v1/media/nosuchmedia")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+	})
+
+	s.Run("rejected", func() {
+		resp := uploadMedia(s, token, []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"))
+		s.Require().Equal(http.StatusUnsupportedMediaType, resp.StatusCode)
+
+		resp = uploadMedia(s, token, make([]byte, 1<<20+1))
+		s.Require().Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		resp = uploadMedia(s, "", testPng(s, 10, 10))
+		s.Require().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("attachToPost", func() {
+		resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+			map[string]interface{}{"text": "look at this", "mediaIds": []string{uploaded.Id}})
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var post storage.FrontendHandlerTransferObject
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+		s.Require().Equal(userId, post.AuthorId)
+
+		resp, err := s.client.Get("http://localhost:8081/api/v1/posts/" + post.Id)
+		s.Require().NoError(err)
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&post))
+		s.Require().Equal([]string{uploaded.Id}, post.MediaIds)
+	})
+
+	s.Run("foreignMedia", func() {
+		resp := sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", otherToken,
+			map[string]interface{}{"text": "stolen", "mediaIds": []string{uploaded.Id}})
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodPost, "http://localhost:8081/api/v1/posts", token,
+			map[string]interface{}{"text": "missing", "mediaIds": []string{"nosuchmedia"}})
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		resp = sendJson(s, http.MethodPatch, "http://localhost:8081/api/v1/users/me", otherToken,
+			map[string]string{"avatarMediaId": uploaded.Id})
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+
+	s.Run("avatar", func() {
+		resp := sendJson(s, http.MethodPatch, "http://localhost:8081/api/v1/users/me", token,
+			map[string]string{"avatarMediaId": uploaded.Id})
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+}
+
+type auditPage struct {
+	Entries []struct {
+		Seq     int64             `json:"seq"`
+		Actor   string            `json:"actor"`
+		Action  string            `json:"action"`
+		Target  string            `json:"target"`
+		Details map[string]string `json:"details"`
+	} `json:"entries"`
+	NextPage string `json:"nextPage"`
+}
+
+func getAuditPage(s *ApiSuite, token, query string) *auditPage {
+	resp := sendJson(s, http.MethodGet, "http://localhost:8081/api/v1/admin/audit?"+query, token, nil)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var page auditPage
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&page))
+
+	return &page
+}
+
+func (s *ApiSuite) TestAudit() {
+	registerUser(s, "testauditor")
+	userId := registerUser(s, "testaudited")
+	s.Require().NoError(s.srv.SetRole(ctx, "testauditor", "admin"))
+
+	adminToken := login(s, "testauditor", testPassword)
+	userToken := login(s, "testaudited", testPassword)
+
+	reqBody, _ := json.Marshal(map[string]string{"login": "testaudited", "password": "wrong password"})
+	resp, err := s.client.Post("http://localhost:8081/api/v1/login", "application/json", bytes.NewReader(reqBody))
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+	s.Run("userHasNoPermission", func() {
+		resp := sendJson(s, http.MethodGet, "http://localhost:8081/api/v1/admin/audit", userToken, nil)
+		s.Require().Equal(http.StatusForbidden, resp.StatusCode)
+	})
+
+	s.Run("byActor", func() {
+		var actions []string
+		seen := map[int64]bool{}
+
+		for query := "size=1&actor=" + userId; query != ""; {
+			page := getAuditPage(s, adminToken, query)
+
+			for _, e := range page.Entries {
+				s.Require().False(seen[e.Seq], "pages don't overlap")
+				seen[e.Seq] = true
+				actions = append(actions, e.Action)
+			}
+
+			query = ""
+			if page.NextPage != "" {
+				query = "size=1&actor=" + userId + "&page=" + page.NextPage
+			}
+		}
+
+		s.Require().Equal([]string{"login.success", "user.register"}, actions)
+	})
+
+	s.Run("byAction", func() {
+		page := getAuditPage(s, adminToken, "action=login.failure&from="+url.QueryEscape(time.Now().Add(-time.Minute).Format(time.RFC3339)))
+		s.Require().NotEmpty(page.Entries)
+		s.Require().Equal("login:testaudited", page.Entries[0].Target)
+		s.Require().Equal("password", page.Entries[0].Details["factor"])
+	})
+
+	s.Run("verify", func() {
+		resp := sendJson(s, http.MethodGet, "http://localhost:8081/api/v1/admin/audit/verify", adminToken, nil)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var v struct {
+			Valid   bool  `json:"valid"`
+			HeadSeq int64 `json:"headSeq"`
+		}
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&v))
+		s.Require().True(v.Valid)
+		s.Require().NotZero(v.HeadSeq)
+	})
+}
+
+func (s *ApiSuite) TestJwks() {
+	registerUser(s, "testjwks")
+	token := login(s, "testjwks", testPassword)
+
+	resp, err := s.client.Get("http://localhost:8081/.well-known/jwks.json")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&jwks))
+	s.Require().NotEmpty(jwks.Keys)
+
+	// Other services verify access tokens with published keys only
+	_, err = jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		for _, key := range jwks.Keys {
+			if key.Kid == t.Header["kid"] && key.Alg == t.Method.Alg() {
+				x, err := base64.RawURLEncoding.DecodeString(key.X)
+				return ed25519.PublicKey(x), err
+			}
+		}
+
+		return nil, fmt.Errorf("unknown key %v", t.Header["kid"])
+	})
+	s.Require().NoError(err)
+}
+
+func (s *ApiSuite) TestHealth() {
+	s.Run("liveness", func() {
+		resp, err := s.client.Get("http://localhost:8081/healthz")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+
+	s.Run("readiness", func() {
+		resp, err := s.client.Get("http://localhost:8081/readyz")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+
+	s.Run("requestIdPropagation", func() {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8081/healthz", nil)
+		s.Require().NoError(err)
+		req.Header.Set("X-Request-Id", "test-request-id")
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().Equal("test-request-id", resp.Header.Get("X-Request-Id"))
+	})
+
+	s.Run("metrics", func() {
+		// Unknown paths and metrics itself aren't part of api spec
+		resp, err := http.Get("http://localhost:8081/no/such/path")
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+
+		resp, err = http.Get("http://localhost:8081/metrics")
+		s.Require().NoError(err)
+		body, err := io.ReadAll(resp.Body)
+		s.Require().NoError(err)
+		s.Require().Contains(string(body), "microblog_registered_users ")
+		s.Require().Contains(string(body), `microblog_http_requests_total{code="200",method="GET",route="healthz"}`)
+		s.Require().Contains(string(body), `microblog_http_requests_total{code="404",method="GET",route="unknown"}`)
+		s.Require().Contains(string(body), "go_goroutines ")
+	})
+
+	s.Run("secondServerInProcess", func() {
+		// Collectors of every server are registered on its own registry, so they don't clash
+		srv, err := microblog.NewMicroblogServer(s.cfg)
+		s.Require().NoError(err)
+
+		stopped, stop := context.WithCancel(ctx)
+		stop()
+		s.Require().NoError(srv.Run(stopped, 0))
+	})
+}
+
 func getLastPosts(s *ApiSuite, size int, page, url string) ([]storage.Post, string, int) {
 	req, err := http.NewRequest(http.MethodGet, url, io.NopCloser(strings.NewReader("")))
 	s.Require().NoError(err)
@@ -230,17 +1653,18 @@ func getLastPosts(s *ApiSuite, size int, page, url string) ([]storage.Post, stri
 }
 
 func (s *ApiSuite) TestUsersPosts() {
-	var userId string
+	var userId, token string
 	s.Run("registerUser", func() {
 		userId = registerUser(s, "testusersposts")
+		token = login(s, "testusersposts", testPassword)
 	})
 
 	for i := 0; i < 10; i++ {
 		s.Run("addPostInUsersPosts", func() {
-			addPost(s, strconv.Itoa(i), userId)
+			addPost(s, strconv.Itoa(i), token, userId)
 		})
 		time.Sleep(1 * time.Second)
-		// TODO: время в бд слишком сильно округляется 
+		// TODO: время в бд слишком сильно округляется
 	}
 
 	url := fmt.Sprintf("http://localhost:8081/api/v1/users/%s/posts", userId)